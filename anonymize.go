@@ -0,0 +1,113 @@
+package appdash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Anonymizer obfuscates the annotation values of traces so that they
+// can be shared publicly (e.g., in bug reports) without revealing
+// queries, URLs, user data, etc. It preserves the structure and timing of
+// the traces: span IDs, annotation keys, timestamps and the numeric
+// values of timing keys (see NumberKeys) are kept, and equal values are
+// replaced with equal hashes, so repeated queries and hot paths remain
+// recognizable.
+type Anonymizer struct {
+	// Keys are path.Match patterns (e.g., "SQL.*" or "*.URI") for the
+	// annotation keys whose values are obfuscated. If empty, the values
+	// of all keys are obfuscated.
+	Keys []string
+
+	// KeepKeys are path.Match patterns for annotation keys whose values
+	// are kept even if they match Keys (e.g., "Name").
+	KeepKeys []string
+
+	// NumberKeys are path.Match patterns for the annotation keys whose
+	// numeric values are kept, such as durations (which events record
+	// in milliseconds). Numbers in other keys, such as user or order
+	// IDs, are obfuscated. If nil, DefaultNumberKeys is used.
+	NumberKeys []string
+
+	// Salt is mixed into the hashes, so that short or guessable values
+	// can't be recovered by hashing candidates. Use a random salt that
+	// isn't shared along with the traces.
+	Salt []byte
+}
+
+// DefaultNumberKeys are the NumberKeys of an Anonymizer whose NumberKeys
+// is nil: those of durations, latencies and histograms of them.
+var DefaultNumberKeys = []string{"*Duration", "*Latency", "*.KeepFor", "Histogram.*"}
+
+// anonPrefix is the prefix of obfuscated values.
+const anonPrefix = "anon:"
+
+// Trace returns an anonymized copy of t. It does not modify t.
+func (a *Anonymizer) Trace(t *Trace) *Trace {
+	t2 := &Trace{Span: Span{ID: t.ID}}
+	t2.Annotations = a.Annotations(t.Annotations)
+	for _, sub := range t.Sub {
+		t2.Sub = append(t2.Sub, a.Trace(sub))
+	}
+	return t2
+}
+
+// Annotations returns an anonymized copy of anns.
+func (a *Anonymizer) Annotations(anns Annotations) Annotations {
+	anns2 := make(Annotations, len(anns))
+	for i, ann := range anns {
+		anns2[i] = Annotation{Key: ann.Key, Value: ann.Value}
+		if a.obfuscate(ann) {
+			anns2[i].Value = a.hash(ann.Value)
+		}
+	}
+	return anns2
+}
+
+// obfuscate reports whether the value of ann should be obfuscated.
+func (a *Anonymizer) obfuscate(ann Annotation) bool {
+	if len(ann.Value) == 0 || strings.HasPrefix(ann.Key, schemaPrefix) {
+		return false
+	}
+	if matchAny(a.KeepKeys, ann.Key) {
+		return false
+	}
+	if len(a.Keys) > 0 && !matchAny(a.Keys, ann.Key) {
+		return false
+	}
+
+	// Keep timing information, which is what the traces are for.
+	v := string(ann.Value)
+	if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return false
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		numberKeys := a.NumberKeys
+		if numberKeys == nil {
+			numberKeys = DefaultNumberKeys
+		}
+		return !matchAny(numberKeys, ann.Key)
+	}
+	return true
+}
+
+func (a *Anonymizer) hash(v []byte) []byte {
+	m := hmac.New(sha256.New, a.Salt)
+	m.Write(v)
+	return []byte(anonPrefix + hex.EncodeToString(m.Sum(nil))[:16])
+}
+
+// matchAny reports whether key matches any of the path.Match patterns.
+// Malformed patterns match nothing.
+func matchAny(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package appdash
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnonymizer(t *testing.T) {
+	tr := &Trace{
+		Span: Span{
+			ID: SpanID{1, 2, 0},
+			Annotations: Annotations{
+				{"Name", []byte("checkout")},
+				{"SQL.SQL", []byte("SELECT * FROM users WHERE email='a@example.com'")},
+				{"SQL.ClientSend", []byte("2015-01-02T03:04:05.678Z")},
+				{"SQL.Duration", []byte("12.5")},
+				{"SQL.UserID", []byte("12345")},
+				{"_schema:SQL", nil},
+			},
+		},
+		Sub: []*Trace{{
+			Span: Span{
+				ID:          SpanID{1, 3, 2},
+				Annotations: Annotations{{"SQL.SQL", []byte("SELECT * FROM users WHERE email='a@example.com'")}},
+			},
+		}},
+	}
+	orig := tr.String()
+
+	a := &Anonymizer{KeepKeys: []string{"Name"}, Salt: []byte("s")}
+	got := a.Trace(tr)
+	if tr.String() != orig {
+		t.Error("original trace was modified")
+	}
+
+	if got.ID != tr.ID || got.Sub[0].ID != tr.Sub[0].ID {
+		t.Error("span IDs were not preserved")
+	}
+	m := got.Annotations.StringMap()
+	for _, key := range []string{"Name", "SQL.ClientSend", "SQL.Duration", "_schema:SQL"} {
+		if m[key] != tr.Annotations.StringMap()[key] {
+			t.Errorf("%s: got %q, want it preserved", key, m[key])
+		}
+	}
+	if id := m["SQL.UserID"]; !strings.HasPrefix(id, anonPrefix) {
+		t.Errorf("SQL.UserID: got %q, want the number obfuscated", id)
+	}
+	sql := m["SQL.SQL"]
+	if !strings.HasPrefix(sql, anonPrefix) || strings.Contains(sql, "example.com") {
+		t.Errorf("SQL.SQL: got %q, want it obfuscated", sql)
+	}
+	if sub := got.Sub[0].Annotations.StringMap()["SQL.SQL"]; sub != sql {
+		t.Errorf("got different hashes %q and %q for equal values", sub, sql)
+	}
+
+	// Numbers are kept in the keys matching NumberKeys.
+	a = &Anonymizer{NumberKeys: []string{"*ID"}}
+	m = a.Annotations(tr.Annotations).StringMap()
+	if m["SQL.UserID"] != "12345" || !strings.HasPrefix(m["SQL.Duration"], anonPrefix) {
+		t.Errorf("got SQL.UserID %q and SQL.Duration %q, want only SQL.UserID kept", m["SQL.UserID"], m["SQL.Duration"])
+	}
+
+	// Only keys matching the patterns are obfuscated.
+	a = &Anonymizer{Keys: []string{"*.URI"}}
+	if got := a.Annotations(tr.Annotations); !reflect.DeepEqual(got, tr.Annotations) {
+		t.Errorf("got %v, want annotations unchanged", got)
+	}
+}
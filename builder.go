@@ -0,0 +1,114 @@
+package appdash
+
+import (
+	"reflect"
+	"sync"
+)
+
+// maxPooledBuffer is the maximum size of the value buffer of a
+// SpanBuilder that is returned to the pool. Larger buffers are left to
+// the garbage collector, so that a single huge span doesn't pin memory.
+const maxPooledBuffer = 64 * 1024
+
+var spanBuilderPool = sync.Pool{
+	New: func() interface{} {
+		return &SpanBuilder{
+			anns: make([]Annotation, 0, 16),
+			ends: make([]int, 0, 16),
+			buf:  make([]byte, 0, 1024),
+		}
+	},
+}
+
+// A SpanBuilder accumulates the annotations of a span in reusable
+// buffers and sends them to a collector in a single Collect call. It
+// avoids the per-annotation allocations of a Recorder, for
+// instrumentation in hot paths:
+//
+//	b := appdash.NewSpanBuilder(spanID, collector)
+//	b.AddString("Name", "lookup")
+//	b.Event(&LookupEvent{...})
+//	if err := b.Submit(); err != nil {
+//		...
+//	}
+//
+// SpanBuilders are pooled: after Submit or Release, the SpanBuilder
+// must not be used again. The annotations passed to the collector are
+// not reused, so collectors may retain them.
+type SpanBuilder struct {
+	span SpanID
+	c    Collector
+
+	anns []Annotation
+	ends []int  // ends[i] is the end of anns[i]'s value in buf
+	buf  []byte // annotation values
+}
+
+// NewSpanBuilder returns a SpanBuilder (from a pool) for the given span
+// and collector.
+func NewSpanBuilder(span SpanID, c Collector) *SpanBuilder {
+	b := spanBuilderPool.Get().(*SpanBuilder)
+	b.span, b.c = span, c
+	return b
+}
+
+// Add adds an annotation. The value is copied.
+func (b *SpanBuilder) Add(key string, value []byte) {
+	b.buf = append(b.buf, value...)
+	b.anns = append(b.anns, Annotation{Key: key})
+	b.ends = append(b.ends, len(b.buf))
+}
+
+// AddString adds an annotation with a string value.
+func (b *SpanBuilder) AddString(key, value string) {
+	b.buf = append(b.buf, value...)
+	b.anns = append(b.anns, Annotation{Key: key})
+	b.ends = append(b.ends, len(b.buf))
+}
+
+// Event adds the annotations of an event, as MarshalEvent would return
+// them.
+func (b *SpanBuilder) Event(e Event) {
+	if !Enabled() {
+		return
+	}
+	flattenValue("", reflect.ValueOf(e), b.AddString)
+	b.AddString(schemaPrefix+e.Schema(), "")
+}
+
+// Submit sends the annotations to the collector in a single Collect
+// call and returns the SpanBuilder to the pool. The collector is given
+// a copy of the annotations (in two allocations, however many there
+// are), since the SpanBuilder's buffers are reused.
+func (b *SpanBuilder) Submit() error {
+	var err error
+	if len(b.anns) > 0 && Enabled() {
+		anns := make([]Annotation, len(b.anns))
+		buf := append([]byte(nil), b.buf...)
+		start := 0
+		for i, end := range b.ends {
+			anns[i].Key = b.anns[i].Key
+			if end > start {
+				anns[i].Value = buf[start:end:end]
+			}
+			start = end
+		}
+		err = b.c.Collect(b.span, anns...)
+	}
+	b.Release()
+	return err
+}
+
+// Release returns the SpanBuilder to the pool without sending its
+// annotations.
+func (b *SpanBuilder) Release() {
+	if cap(b.buf) > maxPooledBuffer {
+		return
+	}
+	for i := range b.anns {
+		b.anns[i] = Annotation{} // don't retain the values
+	}
+	b.anns, b.ends, b.buf = b.anns[:0], b.ends[:0], b.buf[:0]
+	b.c = nil
+	spanBuilderPool.Put(b)
+}
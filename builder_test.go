@@ -0,0 +1,97 @@
+package appdash
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSpanBuilder(t *testing.T) {
+	ms := NewMemoryStore()
+
+	b := NewSpanBuilder(SpanID{1, 2, 0}, ms)
+	b.AddString("Name", "first")
+	b.Add("k", []byte("v"))
+	b.Event(Msg("hello"))
+	if err := b.Submit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reuse the (probably same) pooled builder, which must not affect
+	// the annotations that were already collected.
+	b = NewSpanBuilder(SpanID{1, 3, 2}, ms)
+	b.AddString("Name", "XXXXXXXXXXXX")
+	if err := b.Submit(); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := ms.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgAnns, _ := MarshalEvent(Msg("hello"))
+	want := append(Annotations{{"Name", []byte("first")}, {"k", []byte("v")}}, msgAnns...)
+	if !reflect.DeepEqual(trace.Annotations, want) {
+		t.Errorf("got annotations %v, want %v", trace.Annotations, want)
+	}
+	if name := trace.Sub[0].Name(); name != "XXXXXXXXXXXX" {
+		t.Errorf("got child name %q", name)
+	}
+}
+
+func TestSpanBuilder_retained(t *testing.T) {
+	// A collector that keeps the annotations without copying them.
+	var kept []Annotation
+	c := collectorFunc(func(_ SpanID, anns ...Annotation) error {
+		kept = anns
+		return nil
+	})
+
+	b := NewSpanBuilder(SpanID{1, 2, 0}, c)
+	b.AddString("Name", "first")
+	if err := b.Submit(); err != nil {
+		t.Fatal(err)
+	}
+	first := kept
+	for i := 0; i < 10; i++ {
+		b = NewSpanBuilder(SpanID{1, 3, 2}, c)
+		b.AddString("Other", "XXXXX")
+		if err := b.Submit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []Annotation{{"Name", []byte("first")}}; !reflect.DeepEqual(first, want) {
+		t.Errorf("got retained annotations %v after reuse, want %v", first, want)
+	}
+}
+
+func BenchmarkSpanBuilder(b *testing.B) {
+	cc := &ChunkedCollector{
+		Collector:   collectorFunc(func(SpanID, ...Annotation) error { return nil }),
+		MinInterval: time.Millisecond * 10,
+	}
+	defer cc.Stop()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sb := NewSpanBuilder(SpanID{1, ID(i), 1}, cc)
+		sb.AddString("Name", "span")
+		sb.AddString("k1", "v1")
+		sb.AddString("k2", "v2")
+		sb.Submit()
+	}
+}
+
+func BenchmarkRecorder_annotations(b *testing.B) {
+	cc := &ChunkedCollector{
+		Collector:   collectorFunc(func(SpanID, ...Annotation) error { return nil }),
+		MinInterval: time.Millisecond * 10,
+	}
+	defer cc.Stop()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := NewRecorder(SpanID{1, ID(i), 1}, cc)
+		r.Name("span")
+		r.Annotation(Annotation{"k1", []byte("v1")})
+		r.Annotation(Annotation{"k2", []byte("v2")})
+	}
+}
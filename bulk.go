@@ -0,0 +1,368 @@
+package appdash
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// A BulkImporter is a Store that can ingest large numbers of
+// historical spans (e.g., when backfilling from another tracing
+// system) more efficiently than by collecting them one at a time.
+type BulkImporter interface {
+	Store
+
+	// BeginImport starts a bulk import. Spans added to the import are
+	// not visible in the store until it is committed.
+	BeginImport() (Import, error)
+}
+
+// An Import is a bulk import into a store (see BulkImporter). Its
+// methods must not be called concurrently.
+type Import interface {
+	// Add adds spans to the import. Annotations of spans with the same
+	// ID are merged as if they had been collected separately (see
+	// ConflictPolicy).
+	Add(spans ...Span) error
+
+	// Commit makes the imported spans visible in the store, building
+	// any indexes (such as trace trees) that were deferred.
+	Commit() error
+
+	// Abort discards the import.
+	Abort() error
+}
+
+// ErrImportDone is returned by the methods of an Import that has
+// already been committed or aborted.
+var ErrImportDone = errors.New("import already committed or aborted")
+
+// ImportProgress describes the progress of ImportSpans.
+type ImportProgress struct {
+	Spans   int64         // spans imported so far
+	Batches int64         // batches added so far
+	Elapsed time.Duration // time since the import started
+	Done    bool          // whether the import has been committed
+}
+
+// DefaultImportBatchSize is the number of spans that ImportSpans adds
+// to an import at once if its batch size is not positive.
+const DefaultImportBatchSize = 10000
+
+// ImportSpans imports the spans returned by next into s, until next
+// returns io.EOF (or another error, which aborts the import). If s is
+// a BulkImporter, the spans are added to a single import in batches of
+// batchSize spans and committed at the end; otherwise they are
+// collected one at a time. If progress is non-nil, it is called after
+// each batch and once the import is done.
+func ImportSpans(ctx context.Context, s Store, next func() (*Span, error), batchSize int, progress func(ImportProgress)) (ImportProgress, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
+	}
+	start := time.Now()
+	var p ImportProgress
+	report := func() {
+		p.Elapsed = time.Since(start)
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	imp, err := beginImport(ctx, s)
+	if err != nil {
+		return p, err
+	}
+	batch := make([]Span, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := imp.Add(batch...); err != nil {
+			return err
+		}
+		p.Spans += int64(len(batch))
+		p.Batches++
+		batch = batch[:0]
+		report()
+		return nil
+	}
+	for {
+		span, err := next()
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			imp.Abort()
+			return p, err
+		}
+		batch = append(batch, *span)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				imp.Abort()
+				return p, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		imp.Abort()
+		return p, err
+	}
+	if err := imp.Commit(); err != nil {
+		return p, err
+	}
+	p.Done = true
+	report()
+	return p, nil
+}
+
+// beginImport begins an import into s, which collects the spans one
+// at a time if s is not a BulkImporter.
+func beginImport(ctx context.Context, s Store) (Import, error) {
+	if bi, ok := s.(BulkImporter); ok {
+		return bi.BeginImport()
+	}
+	return &collectImport{ctx: ctx, c: s}, nil
+}
+
+// collectImport is an Import that collects each span as it is added.
+type collectImport struct {
+	ctx context.Context
+	c   Collector
+}
+
+func (ci *collectImport) Add(spans ...Span) error {
+	for _, s := range spans {
+		if err := CollectContext(ci.ctx, ci.c, s.ID, s.Annotations...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ci *collectImport) Commit() error { return nil }
+func (ci *collectImport) Abort() error  { return nil }
+
+// BeginImport implements the BulkImporter interface. The spans added
+// to the import are held outside of the store, so adding them does not
+// contend with collection, and the store is locked only once, to build
+// the trace trees of the imported traces on commit.
+func (ms *MemoryStore) BeginImport() (Import, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	if ms.closed {
+		return nil, ErrStoreClosed
+	}
+	return &memoryImport{ms: ms, span: map[ID]map[ID]*Trace{}}, nil
+}
+
+// memoryImport is an Import into a MemoryStore.
+type memoryImport struct {
+	ms   *MemoryStore
+	span map[ID]map[ID]*Trace // trace ID -> span ID -> span, as in MemoryStore
+	seq  map[*Trace]int       // order in which spans were first added
+	done bool
+}
+
+func (mi *memoryImport) Add(spans ...Span) error {
+	if mi.done {
+		return ErrImportDone
+	}
+	if mi.seq == nil {
+		mi.seq = map[*Trace]int{}
+	}
+	for _, s := range spans {
+		id := s.ID
+		as := s.Annotations.clone()
+		spans, present := mi.span[id.Trace]
+		if !present {
+			spans = map[ID]*Trace{}
+			mi.span[id.Trace] = spans
+		}
+		if t, present := spans[id.Span]; present {
+			t.Annotations = mi.ms.Conflicts.merge(t.Annotations, as)
+			continue
+		}
+		t := &Trace{Span: Span{ID: id, Annotations: mi.ms.Conflicts.merge(nil, as)}}
+		spans[id.Span] = t
+		mi.seq[t] = len(mi.seq)
+	}
+	return nil
+}
+
+func (mi *memoryImport) Abort() error {
+	if mi.done {
+		return ErrImportDone
+	}
+	mi.done = true
+	mi.span, mi.seq = nil, nil
+	return nil
+}
+
+// Commit implements the Import interface. Traces that are new to the
+// store have their trees built directly, as MemoryStore.Collect would
+// have built them; spans of traces that already exist (or whose parent
+// links do not form a tree) are collected one at a time, under a
+// single lock.
+func (mi *memoryImport) Commit() error {
+	if mi.done {
+		return ErrImportDone
+	}
+	mi.done = true
+	ms := mi.ms
+	ms.Lock()
+	defer ms.Unlock()
+	if ms.closed {
+		return ErrStoreClosed
+	}
+	for traceID, spans := range mi.span {
+		if _, present := ms.span[traceID]; !present {
+			if root := mi.buildTree(spans); root != nil {
+				ms.span[traceID] = spans
+				ms.trace[traceID] = root
+				continue
+			}
+		}
+		for _, t := range mi.inOrder(spans) {
+			if err := ms.collectNoLock(t.Span.ID, t.Annotations); err != nil {
+				return err
+			}
+		}
+	}
+	mi.span, mi.seq = nil, nil
+	return nil
+}
+
+// inOrder returns the spans in the order in which they were first
+// added to the import.
+func (mi *memoryImport) inOrder(spans map[ID]*Trace) []*Trace {
+	ts := make([]*Trace, 0, len(spans))
+	for _, t := range spans {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool { return mi.seq[ts[i]] < mi.seq[ts[j]] })
+	return ts
+}
+
+// buildTree links the spans of a trace into a tree and returns its
+// root. As in a MemoryStore, the root is the trace's root span if
+// there is one and otherwise the topmost ancestor of the span that was
+// added first, and spans whose parents are missing are children of
+// the root. If the spans' parent links contain a cycle, buildTree
+// unlinks them and returns nil.
+func (mi *memoryImport) buildTree(spans map[ID]*Trace) *Trace {
+	ordered := mi.inOrder(spans)
+	var root *Trace
+	var tops []*Trace
+	for _, t := range ordered {
+		if t.Span.ID.IsRoot() {
+			root = t
+			continue
+		}
+		if p, present := spans[t.Span.ID.Parent]; present && p != t {
+			p.Sub = append(p.Sub, t)
+			continue
+		}
+		tops = append(tops, t)
+	}
+	if root == nil {
+		// Like MemoryStore.Collect, root the trace at the topmost
+		// ancestor of the first span.
+		root = ordered[0]
+		for i := 0; i < len(spans); i++ {
+			p, present := spans[root.Span.ID.Parent]
+			if !present || p == root {
+				break
+			}
+			root = p
+		}
+	}
+	for _, t := range tops {
+		if t != root {
+			root.Sub = append(root.Sub, t)
+		}
+	}
+	if countSpans(root, len(spans)) != len(spans) {
+		for _, t := range ordered {
+			t.Sub = nil
+		}
+		return nil
+	}
+	return root
+}
+
+// countSpans returns the number of spans in the tree t, counting no
+// more than max of them (so that it terminates on cyclic trees).
+func countSpans(t *Trace, max int) int {
+	n := 1
+	for _, sub := range t.Sub {
+		if n > max {
+			break
+		}
+		n += countSpans(sub, max-n)
+	}
+	return n
+}
+
+// BeginImport implements the BulkImporter interface. If the underlying
+// store is a BulkImporter, its import is used, and the imported traces
+// are considered first seen when the import is committed; otherwise
+// the spans are collected one at a time.
+func (rs *RecentStore) BeginImport() (Import, error) {
+	if bi, ok := rs.DeleteStore.(BulkImporter); ok {
+		imp, err := bi.BeginImport()
+		if err != nil {
+			return nil, err
+		}
+		return &recentImport{Import: imp, rs: rs, traces: map[ID]struct{}{}}, nil
+	}
+	return &collectImport{ctx: context.Background(), c: rs}, nil
+}
+
+// recentImport is an Import into the store underlying a RecentStore.
+type recentImport struct {
+	Import
+	rs *RecentStore
+
+	traces  map[ID]struct{}
+	keepFor map[ID]time.Duration
+}
+
+func (ri *recentImport) Add(spans ...Span) error {
+	for _, s := range spans {
+		ri.traces[s.ID.Trace] = struct{}{}
+		if d, ok := retentionHint(s.Annotations); ok {
+			if ri.keepFor == nil {
+				ri.keepFor = map[ID]time.Duration{}
+			}
+			ri.keepFor[s.ID.Trace] = d
+		}
+	}
+	return ri.Import.Add(spans...)
+}
+
+func (ri *recentImport) Commit() error {
+	if err := ri.Import.Commit(); err != nil {
+		return err
+	}
+	rs := ri.rs
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.created == nil {
+		rs.created = map[ID]int64{}
+	}
+	now := time.Now().UnixNano()
+	for id := range ri.traces {
+		if _, present := rs.created[id]; !present {
+			rs.created[id] = now
+		}
+	}
+	for id, d := range ri.keepFor {
+		rs.setKeepFor(id, d)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package appdash
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestImportSpans(t *testing.T) {
+	// Trace 1 is complete, trace 2 lacks its root, and trace 3 is
+	// partly collected before the import.
+	var spans []Span
+	for i := 0; i < 20; i++ {
+		var parent ID
+		if i > 0 {
+			parent = ID(rand.Intn(i) + 1)
+		}
+		spans = append(spans, Span{ID: SpanID{1, ID(i + 1), parent}, Annotations: Annotations{{"k", []byte{byte(i)}}}})
+	}
+	spans = append(spans,
+		Span{ID: SpanID{2, 2, 1}},
+		Span{ID: SpanID{2, 3, 2}},
+		Span{ID: SpanID{2, 4, 9}},
+		Span{ID: SpanID{3, 2, 1}},
+		Span{ID: SpanID{1, 5, 0}, Annotations: Annotations{{"k2", []byte("v")}}}, // merged into an earlier span
+	)
+	rand.Shuffle(len(spans), func(i, j int) { spans[i], spans[j] = spans[j], spans[i] })
+
+	collected, imported := NewMemoryStore(), NewMemoryStore()
+	for _, s := range []*MemoryStore{collected, imported} {
+		if err := s.Collect(SpanID{3, 1, 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range spans {
+		if err := collected.Collect(s.ID, s.Annotations...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var reports []ImportProgress
+	i := 0
+	p, err := ImportSpans(context.Background(), imported, func() (*Span, error) {
+		if i == len(spans) {
+			return nil, io.EOF
+		}
+		i++
+		return &spans[i-1], nil
+	}, 10, func(p ImportProgress) { reports = append(reports, p) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(spans)); p.Spans != want || p.Batches != 3 || !p.Done {
+		t.Errorf("got progress %+v, want %d spans in 3 batches, done", p, want)
+	}
+	if len(reports) != 4 || !reports[3].Done || reports[2].Done {
+		t.Errorf("got progress reports %+v, want 3 batches then done", reports)
+	}
+
+	for _, id := range []ID{1, 2, 3} {
+		want, err := collected.Trace(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := imported.Trace(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.sortSubRecursive()
+		got.sortSubRecursive()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("trace %v: got %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestMemoryStore_BeginImport_abort(t *testing.T) {
+	ms := NewMemoryStore()
+	imp, err := ms.BeginImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := imp.Add(Span{ID: SpanID{1, 1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := imp.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if err := imp.Commit(); err != ErrImportDone {
+		t.Errorf("got Commit error %v, want %v", err, ErrImportDone)
+	}
+	if _, err := ms.Trace(1); err != ErrTraceNotFound {
+		t.Errorf("got Trace error %v, want %v", err, ErrTraceNotFound)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// newAdminHandler returns the handler of the admin listener, which serves
+// diagnostics about the appdash server itself:
+//
+//	/debug/pprof/      pprof profiles
+//	/debug/vars        expvar variables
+//	/debug/runtime     runtime metrics, as JSON
+//	/debug/goroutines  a dump of all goroutine stacks (POST also writes it to stderr)
+//	/debug/conns       the collector's client connections, busiest first, as JSON
+//	/metrics           the store's Prometheus metrics
+func newAdminHandler(storeMetrics http.Handler, cs *appdash.CollectorServer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/runtime", serveRuntimeMetrics)
+	mux.HandleFunc("/debug/goroutines", serveGoroutineDump)
+	mux.HandleFunc("/debug/conns", func(w http.ResponseWriter, r *http.Request) { serveConns(w, cs) })
+	mux.Handle("/metrics", storeMetrics)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "appdash admin: /debug/pprof/ /debug/vars /debug/runtime /debug/goroutines /debug/conns /metrics")
+	})
+	return mux
+}
+
+// serveRuntimeMetrics serves all supported runtime/metrics samples whose
+// values are scalars, as a JSON object keyed by metric name.
+func serveRuntimeMetrics(w http.ResponseWriter, r *http.Request) {
+	descs := metrics.All()
+	samples := make([]metrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+
+	vals := make(map[string]interface{}, len(samples))
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			vals[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			vals[s.Name] = s.Value.Float64()
+		}
+	}
+	vals["goroutines"] = runtime.NumGoroutine()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(vals); err != nil {
+		log.Printf("admin: writing runtime metrics: %s", err)
+	}
+}
+
+// serveGoroutineDump serves the stacks of all goroutines. On POST, it
+// also writes them to stderr, so that they are kept in the server's log.
+func serveGoroutineDump(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	if r.Method == "POST" {
+		fmt.Fprintf(os.Stderr, "=== goroutine dump requested via admin listener at %s ===\n%s=== end of goroutine dump ===\n", time.Now().Format(time.RFC3339), buf)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf)
+}
+
+// connInfo is the JSON form of an appdash.ConnInfo served by serveConns.
+type connInfo struct {
+	RemoteAddr      string            `json:"remoteAddr"`
+	Identity        string            `json:"identity,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Connected       time.Time         `json:"connected"`
+	Packets         int64             `json:"packets"`
+	SpansPerSec     float64           `json:"spansPerSec"`
+	LastPacket      *time.Time        `json:"lastPacket,omitempty"`
+	LastError       string            `json:"lastError,omitempty"`
+	LastErrorTime   *time.Time        `json:"lastErrorTime,omitempty"`
+	ProtocolVersion int               `json:"protocolVersion"`
+	LagMS           float64           `json:"lagMS"`
+}
+
+// serveConns serves the active client connections of the collector
+// server, so that operators can see which clients are flooding it or
+// failing to report.
+func serveConns(w http.ResponseWriter, cs *appdash.CollectorServer) {
+	conns := []connInfo{}
+	for _, c := range cs.Conns() {
+		ci := connInfo{
+			RemoteAddr:      c.RemoteAddr,
+			Identity:        c.Identity,
+			Labels:          c.Labels,
+			Connected:       c.Connected,
+			Packets:         c.Packets,
+			SpansPerSec:     c.SpansPerSec,
+			LastError:       c.LastError,
+			ProtocolVersion: c.ProtocolVersion,
+			LagMS:           float64(c.Lag) / float64(time.Millisecond),
+		}
+		if !c.LastPacket.IsZero() {
+			ci.LastPacket = &c.LastPacket
+		}
+		if !c.LastErrorTime.IsZero() {
+			ci.LastErrorTime = &c.LastErrorTime
+		}
+		conns = append(conns, ci)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(conns); err != nil {
+		log.Printf("admin: writing collector connections: %s", err)
+	}
+}
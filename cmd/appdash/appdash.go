@@ -48,6 +48,19 @@
 //
 //  appdash send -c="localhost:7701"
 //
+// Doctor mode
+//
+// To diagnose why an application's traces don't show up, the appdash command
+// can check its connection to a server by running:
+//
+//  appdash doctor -c="localhost:7701" -u="http://localhost:7700"
+//
+// It checks that the collector is reachable with the given protocol (and TLS
+// certificate) and that the server's protocol version, store, clock and
+// sampling configuration are sound, printing advice for each problem it finds.
+// With --send, it also sends a test span, which is written to the server's
+// store, and checks that it shows up in the web UI.
+//
 package main
 
 import (
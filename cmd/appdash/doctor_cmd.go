@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func init() {
+	_, err := CLI.AddCommand("doctor",
+		"diagnose a connection to an Appdash server",
+		"The doctor command connects to an Appdash server and checks that its collector is reachable, that the TLS setup and protocol version match, that its store is healthy, that the clocks agree and how traces are sampled. It prints advice for each problem it finds. With --send, it also sends a test span, which is written to the server's store, and checks that it shows up in the web app.",
+		&doctorCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// DoctorCmd is the command for running Appdash in doctor mode, where it
+// diagnoses common misconfigurations of a client's connection to a
+// server.
+type DoctorCmd struct {
+	CollectorAddr  string        `short:"c" long:"collector" description:"collector server address (or comma-separated addresses)" default:"localhost:7701"`
+	CollectorProto string        `short:"p" long:"proto" description:"collector protocol (tcp or tls)" default:"tcp"`
+	ServerName     string        `short:"s" long:"server-name" description:"server name to verify the collector's TLS certificate against (default: the host in --collector)"`
+	TLSCA          string        `long:"tls-ca" description:"PEM file of CA certificates to verify the collector's TLS certificate with (default: the system's)"`
+	URL            string        `short:"u" long:"url" description:"URL of the server's web app" default:"http://localhost:7700"`
+	BasicAuth      string        `long:"basic-auth" description:"'user:passwd' to authenticate to the web app with, if it requires HTTP Basic auth"`
+	Timeout        time.Duration `long:"timeout" description:"how long to wait for each check" default:"5s"`
+	Send           bool          `long:"send" description:"send a test span to the collector and check that it shows up in the web app (the span is written to the server's store)"`
+	ProbeTLS       bool          `long:"probe-tls" description:"with --proto=tcp, check whether the collector expects TLS by sending it a TLS ClientHello (which a plaintext collector logs as a bad packet)"`
+}
+
+var doctorCmd DoctorCmd
+
+// maxClockSkew is the clock skew between the client and the server
+// above which the doctor command warns.
+const maxClockSkew = time.Second
+
+// doctor prints the results of the doctor command's checks.
+type doctor struct {
+	failed bool
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	fmt.Printf("ok    "+format+"\n", args...)
+}
+
+func (d *doctor) warn(advice, format string, args ...interface{}) {
+	fmt.Printf("warn  "+format+"\n", args...)
+	d.advise(advice)
+}
+
+func (d *doctor) fail(advice, format string, args ...interface{}) {
+	fmt.Printf("FAIL  "+format+"\n", args...)
+	d.advise(advice)
+	d.failed = true
+}
+
+func (d *doctor) advise(advice string) {
+	if advice != "" {
+		fmt.Printf("      -> %s\n", advice)
+	}
+}
+
+// Execute execudes the commands with the given arguments and returns an error,
+// if any.
+func (c *DoctorCmd) Execute(args []string) error {
+	if c.CollectorProto != "tcp" && c.CollectorProto != "tls" {
+		return fmt.Errorf("unknown proto: %q", c.CollectorProto)
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	d := &doctor{}
+	reachable := c.checkCollector(d, tlsConfig)
+	st := c.checkStatus(d)
+	if st != nil {
+		c.checkSampling(d, st)
+	}
+	switch {
+	case !c.Send:
+		fmt.Println("skip  sending a test span (pass --send to check that spans reach the web app)")
+	case reachable && st != nil:
+		c.checkRoundTrip(d, tlsConfig)
+	default:
+		fmt.Println("skip  sending a test span (the collector or the web app is unreachable)")
+	}
+
+	if d.failed {
+		return errors.New("doctor found problems")
+	}
+	return nil
+}
+
+func (c *DoctorCmd) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerName}
+	if c.TLSCA != "" {
+		pem, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.TLSCA)
+		}
+	}
+	return config, nil
+}
+
+// checkCollector checks that each collector address can be connected
+// to using the collector protocol, and reports whether any could be.
+func (c *DoctorCmd) checkCollector(d *doctor, tlsConfig *tls.Config) bool {
+	reachable := false
+	for _, addr := range strings.Split(c.CollectorAddr, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		conn, err := net.DialTimeout("tcp", addr, c.Timeout)
+		if err != nil {
+			d.fail("check that 'appdash serve' is running there and that its --collector flag and any firewalls allow connections from this host", "collector %s is unreachable: %s", addr, err)
+			continue
+		}
+		d.ok("collector %s is reachable", addr)
+
+		if c.CollectorProto == "tls" {
+			if c.checkTLS(d, conn, addr, tlsConfig) {
+				reachable = true
+			}
+		} else {
+			conn.Close()
+			if c.ProbeTLS {
+				c.probeTLS(d, addr)
+			}
+			reachable = true
+		}
+	}
+	return reachable
+}
+
+// checkTLS performs a TLS handshake with the collector at addr over
+// conn, which it closes, and reports whether it succeeded.
+func (c *DoctorCmd) checkTLS(d *doctor, conn net.Conn, addr string, tlsConfig *tls.Config) bool {
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tc := tls.Client(conn, config)
+	defer tc.Close()
+	tc.SetDeadline(time.Now().Add(c.Timeout))
+	if err := tc.Handshake(); err != nil {
+		var advice string
+		switch err.(type) {
+		case x509.UnknownAuthorityError:
+			advice = "the certificate is not signed by a trusted CA; pass the CA that signed it with --tls-ca"
+		case x509.HostnameError:
+			advice = "the certificate is not valid for the collector's host name; connect with a name it is valid for or set --server-name"
+		case x509.CertificateInvalidError:
+			advice = "the certificate is invalid (e.g., expired); renew it and restart the server"
+		default:
+			advice = "check that the server was started with --tls-cert and --tls-key, or use --proto=tcp if it doesn't use TLS"
+		}
+		d.fail(advice, "TLS handshake with collector %s failed: %s", addr, err)
+		return false
+	}
+
+	cert := tc.ConnectionState().PeerCertificates[0]
+	if left := time.Until(cert.NotAfter); left < 14*24*time.Hour {
+		d.warn("renew the certificate before it expires, or clients will fail to connect", "collector %s certificate for %q expires in %s (%s)", addr, cert.Subject.CommonName, left.Round(time.Hour), cert.NotAfter.Format(time.RFC3339))
+	} else {
+		d.ok("collector %s TLS certificate for %q is valid until %s", addr, cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+	}
+	return true
+}
+
+// probeTLS warns if the collector at addr, which is being used without
+// TLS, completes a TLS handshake and so probably expects TLS clients. A
+// plaintext collector reads the ClientHello as a malformed packet and
+// logs an error, so the probe is opt-in (see --probe-tls).
+func (c *DoctorCmd) probeTLS(d *doctor, addr string) {
+	timeout := c.Timeout
+	if timeout > time.Second {
+		timeout = time.Second
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return
+	}
+	conn.Close()
+	d.fail("the server was started with --tls-cert and --tls-key; use --proto=tls (and configure clients with NewTLSRemoteCollector)", "collector %s expects TLS, but --proto=tcp was given", addr)
+}
+
+// serverStatus is the response of the web app's status API.
+type serverStatus struct {
+	Time            time.Time `json:"time"`
+	ProtocolVersion int       `json:"protocolVersion"`
+	Store           struct {
+		OK        bool    `json:"ok"`
+		Error     string  `json:"error"`
+		Traces    int     `json:"traces"`
+		QueryMS   float64 `json:"queryMS"`
+		Queryable bool    `json:"queryable"`
+	} `json:"store"`
+	Sampling struct {
+		Recorded  int            `json:"recorded"`
+		Samplers  map[string]int `json:"samplers"`
+		HeadRates []float64      `json:"headRates"`
+	} `json:"sampling"`
+}
+
+func (c *DoctorCmd) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(c.URL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.BasicAuth != "" {
+		parts := strings.SplitN(c.BasicAuth, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("basic auth must be specified as 'user:passwd'")
+		}
+		req.SetBasicAuth(parts[0], parts[1])
+	}
+	return (&http.Client{Timeout: c.Timeout}).Do(req)
+}
+
+// checkStatus fetches the server's status and checks its clock,
+// protocol version and store. It returns nil if the status could not
+// be fetched.
+func (c *DoctorCmd) checkStatus(d *doctor) *serverStatus {
+	start := time.Now()
+	resp, err := c.get("/api/status")
+	if err != nil {
+		d.fail("check that 'appdash serve' is running and that --url matches its --http address", "web app %s is unreachable: %s", c.URL, err)
+		return nil
+	}
+	defer resp.Body.Close()
+	end := time.Now()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		d.fail("the web app requires HTTP Basic auth; pass --basic-auth=user:passwd", "web app %s refused the request: %s", c.URL, resp.Status)
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		d.warn("the server predates the status API; upgrade it to check its clock, protocol version, store and sampling", "web app %s has no status API", c.URL)
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		d.fail("check the server's log for errors", "web app %s status API returned %s", c.URL, resp.Status)
+		return nil
+	}
+	var st serverStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		d.fail("check that --url points to an Appdash web app", "web app %s returned an invalid status: %s", c.URL, err)
+		return nil
+	}
+	d.ok("web app %s is reachable", c.URL)
+
+	// The server's time was read about halfway through the request.
+	skew := st.Time.Sub(start.Add(end.Sub(start) / 2))
+	if skew < 0 {
+		skew = -skew
+	}
+	if uncertainty := end.Sub(start) / 2; skew > maxClockSkew+uncertainty {
+		d.warn("synchronize the clocks of the client and server hosts (e.g., with NTP); skew distorts span timings and the collector's reorder window", "clock skew versus the server is about %s", skew.Round(time.Millisecond))
+	} else {
+		d.ok("clock skew versus the server is within %s", maxClockSkew)
+	}
+
+	switch {
+	case st.ProtocolVersion == appdash.ProtocolVersion:
+		d.ok("server speaks collector protocol version %d", st.ProtocolVersion)
+	case st.ProtocolVersion < appdash.ProtocolVersion:
+		d.warn("upgrade the server; it ignores fields added by newer clients (e.g., client send times)", "server speaks collector protocol version %d, older than this client's %d", st.ProtocolVersion, appdash.ProtocolVersion)
+	default:
+		d.warn("upgrade the clients so the server can use the fields they lack (e.g., client send times)", "server speaks collector protocol version %d, newer than this client's %d", st.ProtocolVersion, appdash.ProtocolVersion)
+	}
+
+	switch {
+	case !st.Store.OK:
+		d.fail("check the server's log and the store it was started with (--store)", "store is unhealthy: %s", st.Store.Error)
+	case !st.Store.Queryable:
+		d.ok("store is healthy (it cannot list traces)")
+	case st.Store.QueryMS > 1000:
+		d.warn("the store is slow to list traces; consider a store that expires old traces (see --store)", "store lists %d traces in %.0fms", st.Store.Traces, st.Store.QueryMS)
+	default:
+		d.ok("store is healthy and holds %d traces", st.Store.Traces)
+	}
+	return &st
+}
+
+// checkSampling summarizes the sampling decisions recorded in the
+// server's traces.
+func (c *DoctorCmd) checkSampling(d *doctor, st *serverStatus) {
+	s := st.Sampling
+	switch {
+	case !st.Store.Queryable || st.Store.Traces == 0:
+		return
+	case s.Recorded == 0:
+		d.ok("no sampling decisions recorded; clients record every trace")
+	case len(s.HeadRates) > 1:
+		d.warn("clients are configured with different head sampling rates, so per-service trace counts aren't comparable; configure them alike", "traces were head-sampled at %d different rates: %v", len(s.HeadRates), s.HeadRates)
+	case s.Recorded < st.Store.Traces:
+		d.warn("some clients don't record sampling decisions; configure all of them with the same sampler", "%d of %d traces record a sampling decision", s.Recorded, st.Store.Traces)
+	default:
+		var samplers []string
+		for name, n := range s.Samplers {
+			samplers = append(samplers, fmt.Sprintf("%s: %d", name, n))
+		}
+		d.ok("sampling decisions recorded for all traces (%s)", strings.Join(samplers, ", "))
+	}
+}
+
+// checkRoundTrip sends a test span to the collector and waits for the
+// web app to show it.
+func (c *DoctorCmd) checkRoundTrip(d *doctor, tlsConfig *tls.Config) {
+	var rc *appdash.RemoteCollector
+	if c.CollectorProto == "tls" {
+		rc = appdash.NewTLSRemoteCollector(c.CollectorAddr, tlsConfig)
+	} else {
+		rc = appdash.NewRemoteCollector(c.CollectorAddr)
+	}
+	rc.DialTimeout = c.Timeout
+	defer rc.Close()
+
+	span := appdash.NewRootSpanID()
+	rec := appdash.NewRecorder(span, rc)
+	rec.Name("appdash doctor")
+	rec.Log("sent by appdash doctor")
+	if errs := rec.Errors(); len(errs) > 0 {
+		d.fail("check the collector address and protocol", "sending a test span failed: %s", errs[0])
+		return
+	}
+
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		resp, err := c.get("/traces/" + span.Trace.String())
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				d.ok("test span was collected (trace %s)", span.Trace)
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	d.fail("check that --collector and --url belong to the same server, and the server's log for dropped packets (e.g., --max-message-size or sampling)", "test span (trace %s) did not appear in the web app within %s", span.Trace, c.Timeout)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func init() {
+	_, err := CLI.AddCommand("export",
+		"export traces as JSON",
+		"The export command writes the traces in a persisted store file as JSON, which can be imported in the web UI. With --anonymize, annotation values are obfuscated (preserving structure and timing) so the traces can be shared publicly.",
+		&exportCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ExportCmd is the command for exporting traces from a persisted store
+// file.
+type ExportCmd struct {
+	StoreFile string `short:"f" long:"store-file" description:"persisted store file" default:"/tmp/appdash.gob"`
+	Out       string `short:"o" long:"out" description:"output file (default: stdout)"`
+
+	Anonymize     bool   `long:"anonymize" description:"obfuscate annotation values (except timestamps and durations)"`
+	AnonymizeKeys string `long:"anonymize-keys" description:"comma-separated patterns of annotation keys to obfuscate (default: all)"`
+	KeepKeys      string `long:"keep-keys" description:"comma-separated patterns of annotation keys not to obfuscate" default:"Name"`
+	NumberKeys    string `long:"number-keys" description:"comma-separated patterns of annotation keys whose numbers are not obfuscated (default: durations)"`
+	AnonymizeSalt string `long:"anonymize-salt" description:"salt for obfuscated values, to make them comparable across exports (default: random)"`
+}
+
+var exportCmd ExportCmd
+
+// Execute execudes the commands with the given arguments and returns an error,
+// if any.
+func (c *ExportCmd) Execute(args []string) error {
+	f, err := os.Open(c.StoreFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ms := appdash.NewMemoryStore()
+	if _, err := ms.ReadFrom(f); err != nil {
+		return err
+	}
+	traces, err := ms.Traces()
+	if err != nil {
+		return err
+	}
+
+	if c.Anonymize {
+		a := &appdash.Anonymizer{
+			Keys:     splitPatterns(c.AnonymizeKeys),
+			KeepKeys: splitPatterns(c.KeepKeys),
+			Salt:     []byte(c.AnonymizeSalt),
+		}
+		if c.NumberKeys != "" {
+			a.NumberKeys = splitPatterns(c.NumberKeys)
+		}
+		if len(a.Salt) == 0 {
+			a.Salt = make([]byte, 16)
+			if _, err := rand.Read(a.Salt); err != nil {
+				return err
+			}
+		}
+		for i, t := range traces {
+			traces[i] = a.Trace(t)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if c.Out != "" {
+		f, err := os.Create(c.Out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(traces); err != nil {
+		return err
+	}
+	log.Printf("Exported %d traces", len(traces))
+	return nil
+}
+
+// splitPatterns splits a comma-separated list of patterns.
+func splitPatterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
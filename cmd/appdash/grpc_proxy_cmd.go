@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/grpctrace"
+)
+
+func init() {
+	_, err := CLI.AddCommand("grpc-proxy",
+		"trace gRPC calls to a server by proxying them",
+		"The grpc-proxy command runs an HTTP/2 proxy in front of a gRPC server and sends a span for each call that passes through it to a remote collector. Spans are named after the call's service and method, and record the number and size of messages sent each way. With --descriptors or --reflect, they also record the method's message types.",
+		&grpcProxyCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// GRPCProxyCmd is the command for running Appdash as a tracing gRPC proxy.
+type GRPCProxyCmd struct {
+	ListenAddr    string `short:"l" long:"listen" description:"address to accept gRPC calls on (unencrypted HTTP/2)" default:":50051"`
+	Target        string `short:"t" long:"target" description:"URL of the gRPC server to forward calls to (http:// or https://)" required:"yes"`
+	CollectorAddr string `short:"c" long:"collector" description:"collector server address (or comma-separated addresses to fail over between)" default:":7701"`
+
+	Descriptors string `long:"descriptors" description:"file descriptor set of the services behind the proxy (from protoc --descriptor_set_out)"`
+	Reflect     bool   `long:"reflect" description:"fetch the descriptors of unknown services from the server's gRPC reflection service"`
+
+	Debug bool `short:"d" long:"debug" description:"debug log"`
+}
+
+var grpcProxyCmd GRPCProxyCmd
+
+// Execute execudes the commands with the given arguments and returns an error,
+// if any.
+func (c *GRPCProxyCmd) Execute(args []string) error {
+	target, err := url.Parse(c.Target)
+	if err != nil {
+		return err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("target must be an http:// or https:// URL, got %q", c.Target)
+	}
+
+	rc := appdash.NewRemoteCollector(c.CollectorAddr)
+	rc.Debug = c.Debug
+	proxy := &grpctrace.Proxy{
+		Target: target,
+		Collector: &appdash.ChunkedCollector{
+			Collector:   rc,
+			MinInterval: time.Second,
+		},
+		Reflect: c.Reflect,
+	}
+	if c.Descriptors != "" {
+		f, err := os.Open(c.Descriptors)
+		if err != nil {
+			return err
+		}
+		proxy.Descriptors, err = grpctrace.LoadDescriptorSet(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %s", c.Descriptors, err)
+		}
+	}
+
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	s := &http.Server{Addr: c.ListenAddr, Handler: proxy, Protocols: &protocols}
+	log.Printf("appdash gRPC proxy listening on %s, forwarding to %s", c.ListenAddr, target)
+	return s.ListenAndServe()
+}
@@ -39,6 +39,14 @@ func sampleData(c appdash.Collector) error {
 		traceID := appdash.NewRootSpanID()
 		traceRec := appdash.NewRecorder(traceID, c)
 		traceRec.Name(fakeHosts[rand.Intn(len(fakeHosts))])
+		switch {
+		case i%10 == 0:
+			traceRec.Event(appdash.DebugSampled())
+		case i%4 == 0:
+			traceRec.Event(appdash.TailSampled("slow-request"))
+		default:
+			traceRec.Event(appdash.HeadSampled(0.1))
+		}
 
 		// A random length for the trace.
 		length := time.Duration(rand.Intn(1000)) * time.Millisecond
@@ -23,7 +23,7 @@ func init() {
 // SendCmd is the command for running Appdash in sender mode, where it sends
 // sample data to a remote collector.
 type SendCmd struct {
-	CollectorAddr  string `short:"c" long:"collector" description:"collector listen address" default:":7701"`
+	CollectorAddr  string `short:"c" long:"collector" description:"collector server address (or comma-separated addresses to fail over between)" default:":7701"`
 	CollectorProto string `short:"p" long:"proto" description:"collector protocol (tcp or tls)" default:"tcp"`
 	ServerName     string `short:"s" long:"server-name" description:"server name (required for TLS)"`
 	Debug          bool   `short:"d" long:"debug" description:"debug log"`
@@ -4,17 +4,21 @@ import (
 	"crypto/subtle"
 	"crypto/tls"
 	"encoding/base64"
+	"expvar"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
-	"os"
+	"net/url"
+	"path/filepath"
 	"time"
 
 	"strings"
 
 	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/k8s"
+	"sourcegraph.com/sourcegraph/appdash/metrics"
 	"sourcegraph.com/sourcegraph/appdash/traceapp"
 )
 
@@ -36,18 +40,36 @@ type ServeCmd struct {
 	HTTPAddr      string `long:"http" description:"HTTP listen address" default:":7700"`
 	SampleData    bool   `long:"sample-data" description:"add sample data"`
 
+	StoreURL        string        `long:"store" description:"store URL, selecting the backend by scheme (e.g. memory:///tmp/appdash.gob?persist=2s); overrides --store-file and --persist-interval"`
 	StoreFile       string        `short:"f" long:"store-file" description:"persisted store file" default:"/tmp/appdash.gob"`
 	PersistInterval time.Duration `short:"p" long:"persist-interval" description:"interval between persisting store to file" default:"2s"`
 
 	Debug bool `short:"d" long:"debug" description:"debug log"`
 	Trace bool `long:"trace" description:"trace log"`
 
-	DeleteAfter time.Duration `long:"delete-after" description:"delete traces after a certain age (0 to disable)" default:"30m"`
+	DeleteAfter      time.Duration `long:"delete-after" description:"delete traces after a certain age (0 to disable)" default:"30m"`
+	MaxRetentionHint time.Duration `long:"max-retention-hint" description:"maximum age that a client's retention hint may keep a trace for (0 for no limit)" default:"72h"`
 
 	TLSCert string `long:"tls-cert" description:"TLS certificate file (if set, enables TLS)"`
 	TLSKey  string `long:"tls-key" description:"TLS key file (if set, enables TLS)"`
 
 	BasicAuth string `long:"basic-auth" description:"if set to 'user:passwd', require HTTP Basic Auth for web app"`
+
+	InfluxDBAddr     string        `long:"influxdb" description:"if set, export span metrics to the InfluxDB HTTP API at this address (e.g. http://localhost:8086)"`
+	InfluxDBName     string        `long:"influxdb-db" description:"InfluxDB database to export span metrics to" default:"appdash"`
+	InfluxDBInterval time.Duration `long:"influxdb-interval" description:"interval between span metrics exports to InfluxDB" default:"10s"`
+
+	MetricsPath string `long:"metrics-path" description:"if set, serve Prometheus metrics about the store at this HTTP path (e.g. /metrics)"`
+
+	TopWindow time.Duration `long:"top-window" description:"how far back to aggregate span latencies and errors for the slowest span names API (0 to disable)" default:"1h"`
+
+	MaxMessageSize int           `long:"max-message-size" description:"maximum size in bytes of a packet received by the collector" default:"32768"`
+	Oversized      string        `long:"oversized" description:"what to do with packets larger than --max-message-size ('reject' or 'truncate')" default:"reject"`
+	ReorderWindow  time.Duration `long:"reorder-window" description:"if set, hold received packets this long to collect them in the order clients sent them and drop duplicates (e.g. 500ms)"`
+
+	AdminAddr string `long:"admin" description:"if set, serve pprof, expvar, runtime metrics, goroutine dumps and collector connections about the server itself on this address (e.g. localhost:7702); do not expose it publicly"`
+
+	K8sEnrich bool `long:"k8s-enrich" description:"tag spans with the Kubernetes pod, namespace and deployment of the client that sent them (requires running in the cluster)"`
 }
 
 var serveCmd ServeCmd
@@ -55,50 +77,78 @@ var serveCmd ServeCmd
 // Execute execudes the commands with the given arguments and returns an error,
 // if any.
 func (c *ServeCmd) Execute(args []string) error {
+	storeURL := c.StoreURL
+	if storeURL == "" {
+		var err error
+		storeURL, err = memoryStoreURL(c.StoreFile, c.PersistInterval)
+		if err != nil {
+			return err
+		}
+	}
+	backend, err := appdash.OpenStore(storeURL)
+	if err != nil {
+		return err
+	}
+	if _, ok := backend.(appdash.Queryer); !ok {
+		return fmt.Errorf("store %s cannot list traces, which the web UI requires", storeURL)
+	}
+	if _, ok := backend.(appdash.DeleteStore); !ok && c.DeleteAfter > 0 {
+		return fmt.Errorf("store %s cannot delete traces, which --delete-after requires (set it to 0)", storeURL)
+	}
+
 	var (
-		memStore = appdash.NewMemoryStore()
-		Store    = appdash.Store(memStore)
-		Queryer  = memStore
+		storeMetrics = &metrics.StoreMetrics{}
+		instrumented = &appdash.InstrumentedStore{Store: backend, Instrumentation: storeMetrics}
+		Store        = appdash.Store(instrumented)
+		Queryer      = instrumented
 	)
 
-	if c.StoreFile != "" {
-		f, err := os.Open(c.StoreFile)
-		if err != nil && !os.IsNotExist(err) {
-			return err
-		}
-		if f != nil {
-			if n, err := memStore.ReadFrom(f); err == nil {
-				log.Printf("Read %d traces from file %s", n, c.StoreFile)
-			} else if err != nil {
-				f.Close()
-				return err
-			}
-			if err := f.Close(); err != nil {
-				return err
-			}
-		}
-		if c.PersistInterval != 0 {
-			go func() {
-				if err := appdash.PersistEvery(memStore, c.PersistInterval, c.StoreFile); err != nil {
-					log.Fatal(err)
-				}
-			}()
-		}
+	var oversized appdash.OversizedPolicy
+	switch c.Oversized {
+	case "reject":
+		oversized = appdash.RejectOversized
+	case "truncate":
+		oversized = appdash.TruncateOversized
+	default:
+		return fmt.Errorf("invalid --oversized value %q (must be 'reject' or 'truncate')", c.Oversized)
 	}
 
 	if c.DeleteAfter > 0 {
 		Store = &appdash.RecentStore{
 			MinEvictAge: c.DeleteAfter,
-			DeleteStore: memStore,
+			MaxHintAge:  c.MaxRetentionHint,
+			DeleteStore: instrumented,
 			Debug:       true,
 		}
 	}
 
+	if c.InfluxDBAddr != "" {
+		exporter := &metrics.InfluxExporter{
+			Queryer: Queryer,
+			W:       metrics.NewInfluxDBWriter(c.InfluxDBAddr, c.InfluxDBName),
+		}
+		log.Printf("Exporting span metrics to InfluxDB %s (database %q) every %s", c.InfluxDBAddr, c.InfluxDBName, c.InfluxDBInterval)
+		go exporter.ExportEvery(c.InfluxDBInterval)
+	}
+
 	app := traceapp.New(nil)
 	app.Store = Store
 	app.Queryer = Queryer
 
-	var h http.Handler
+	var collector appdash.Collector = appdash.NewLocalCollector(Store)
+	if c.TopWindow != 0 {
+		agg := &metrics.Aggregator{Collector: collector, Window: c.TopWindow}
+		app.Aggregator = agg
+		collector = agg
+	}
+
+	var h http.Handler = app
+	if c.MetricsPath != "" {
+		mux := http.NewServeMux()
+		mux.Handle(c.MetricsPath, storeMetrics)
+		mux.Handle("/", app)
+		h = mux
+	}
 	if c.BasicAuth != "" {
 		parts := strings.SplitN(c.BasicAuth, ":", 2)
 		if len(parts) != 2 {
@@ -109,9 +159,7 @@ func (c *ServeCmd) Execute(args []string) error {
 			log.Fatalf("Basic auth user and passwd must both be nonempty.")
 		}
 		log.Printf("Requiring HTTP Basic auth")
-		h = newBasicAuthHandler(user, passwd, app)
-	} else {
-		h = app
+		h = newBasicAuthHandler(user, passwd, h)
 	}
 
 	if c.SampleData {
@@ -150,11 +198,32 @@ func (c *ServeCmd) Execute(args []string) error {
 		proto = "plaintext TCP (no security)"
 	}
 	log.Printf("appdash collector listening on %s (%s)", c.CollectorAddr, proto)
-	cs := appdash.NewServer(l, appdash.NewLocalCollector(Store))
+	cs := appdash.NewServer(l, collector)
 	cs.Debug = c.Debug
 	cs.Trace = c.Trace
+	cs.MaxMessageSize = c.MaxMessageSize
+	cs.Oversized = oversized
+	cs.ReorderWindow = c.ReorderWindow
+	if c.K8sEnrich {
+		e, err := k8s.InCluster()
+		if err != nil {
+			return err
+		}
+		log.Printf("Tagging spans with Kubernetes metadata from %s", e.Host)
+		cs.Enricher = e
+	}
 	go cs.Start()
 
+	if c.AdminAddr != "" {
+		expvar.Publish("appdash.collector", expvar.Func(func() interface{} { return cs.Stats() }))
+		log.Printf("appdash admin server listening on %s", c.AdminAddr)
+		go func() {
+			if err := http.ListenAndServe(c.AdminAddr, newAdminHandler(storeMetrics, cs)); err != nil {
+				log.Printf("admin server stopped: %s", err)
+			}
+		}()
+	}
+
 	if c.TLSCert != "" || c.TLSKey != "" {
 		log.Printf("appdash HTTPS server listening on %s (TLS cert %s, key %s)", c.HTTPAddr, c.TLSCert, c.TLSKey)
 		return http.ListenAndServeTLS(c.HTTPAddr, c.TLSCert, c.TLSKey, h)
@@ -164,6 +233,23 @@ func (c *ServeCmd) Execute(args []string) error {
 	return http.ListenAndServe(c.HTTPAddr, h)
 }
 
+// memoryStoreURL returns the URL of the memory store that is loaded from
+// and persisted to file every persist interval (see appdash.OpenStore).
+// A relative file is made absolute, since the path of a memory store URL
+// must be.
+func memoryStoreURL(file string, persist time.Duration) (string, error) {
+	u := url.URL{Scheme: "memory"}
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return "", err
+		}
+		u.Path = filepath.ToSlash(abs)
+		u.RawQuery = url.Values{"persist": {persist.String()}}.Encode()
+	}
+	return u.String(), nil
+}
+
 func newBasicAuthHandler(user, passwd string, h http.Handler) http.Handler {
 	want := "Basic " + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", user, passwd)))
 	return &basicAuthHandler{h, []byte(want)}
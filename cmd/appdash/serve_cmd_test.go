@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestMemoryStoreURL(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	dir, err = os.Getwd() // resolve symlinks in the temp dir
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"appdash.gob", "data/x.gob", filepath.Join(dir, "abs.gob")} {
+		rawurl, err := memoryStoreURL(file, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := file
+		if !filepath.IsAbs(want) {
+			want = filepath.Join(dir, file)
+		}
+		if u.Host != "" || filepath.FromSlash(u.Path) != want {
+			t.Errorf("%s: got URL %s, want path %s", file, rawurl, want)
+		}
+		if _, err := appdash.OpenStore(rawurl); err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+	}
+
+	if rawurl, err := memoryStoreURL("", time.Second); err != nil || rawurl != "memory:" {
+		t.Errorf("got %q, %v for no file, want an unpersisted store", rawurl, err)
+	}
+}
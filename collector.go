@@ -1,32 +1,87 @@
 package appdash
 
 import (
+	"bufio"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pio "github.com/gogo/protobuf/io"
+	"github.com/gogo/protobuf/proto"
 	"sourcegraph.com/sourcegraph/appdash/internal/wire"
 )
 
-// maxMessageSize is the maximum buffer size for delimited protobuf messages.
-// Effectively, the client may request the server to allocate a buffer of up to
-// maxMessageSize -- so choose carefully.
+// maxMessageSize is the default maximum buffer size for delimited protobuf
+// messages. Effectively, the client may request the server to allocate a
+// buffer of up to maxMessageSize -- so choose carefully.
 //
 // We use 32KiB here.
 const maxMessageSize = 32 * 1024
 
+// ProtocolVersion is the version of the collector wire protocol that
+// RemoteCollector speaks. Version 1 packets hold a span ID and
+// annotations; version 2 packets also carry the time at which the
+// client sent them.
+const ProtocolVersion = 2
+
 // A Collector collects events that occur in spans.
 type Collector interface {
 	Collect(SpanID, ...Annotation) error
 }
 
+var (
+	// ErrQueueFull is returned by ChunkedCollector.Collect when its
+	// queue of pending spans is full. The annotations that were being
+	// collected are dropped.
+	ErrQueueFull = errors.New("collector queue is full")
+
+	// ErrCollectorStopped is returned by ChunkedCollector.Collect after
+	// the collector has been stopped.
+	ErrCollectorStopped = errors.New("collector is stopped")
+
+	// ErrNotConnected is returned (wrapped together with the underlying
+	// error) by RemoteCollector.Collect when it fails to connect to the
+	// collector server.
+	ErrNotConnected = errors.New("not connected to collector server")
+
+	// ErrMalformedPacket is wrapped by the errors that a CollectorServer
+	// logs for packets that it can't decode.
+	ErrMalformedPacket = errors.New("malformed packet")
+)
+
+// CollectorStats receives statistics about the operation of a
+// RemoteCollector or ChunkedCollector, so that applications can track
+// the overhead of tracing in their own metrics systems. Its methods may
+// be called concurrently.
+type CollectorStats interface {
+	// OnSend is called after a RemoteCollector sends a packet of the
+	// given size (in bytes, including framing) to the server.
+	OnSend(bytes int, err error)
+
+	// OnRetry is called when a RemoteCollector failed to send a
+	// packet on its existing connection and retries on a new one.
+	OnRetry()
+
+	// OnConnect is called after a RemoteCollector connects to the
+	// server. Reconnect is whether it had connected before.
+	OnConnect(reconnect bool, err error)
+
+	// OnFlush is called after a ChunkedCollector flushes the given
+	// number of pending spans to its underlying collector.
+	OnFlush(spans int, d time.Duration, err error)
+}
+
 // NewLocalCollector returns a Collector that writes directly to a
 // Store.
 func NewLocalCollector(s Store) Collector {
@@ -53,6 +108,14 @@ type ChunkedCollector struct {
 	// underlying collector's Collect method.
 	MinInterval time.Duration
 
+	// MaxQueueSize, if non-zero, is the maximum number of distinct
+	// spans that may be pending. When it is reached, Collect returns
+	// ErrQueueFull for new spans until the pending spans are flushed.
+	MaxQueueSize int
+
+	// Stats, if non-nil, receives statistics about flushes.
+	Stats CollectorStats
+
 	// The last error from the underlying Collector's Collect method,
 	// if any. It will be returned to the next caller of Collect and
 	// this field will be set to nil.
@@ -71,13 +134,17 @@ type ChunkedCollector struct {
 
 // Collect adds the span and annotations to a local buffer until the
 // next call to Flush (or when MinInterval elapses), at which point
-// they are sent (grouped by span) to the underlying collector.
+// they are sent (grouped by span) to the underlying collector. It does
+// nothing while recording is disabled (see SetEnabled).
 func (cc *ChunkedCollector) Collect(span SpanID, anns ...Annotation) error {
+	if !Enabled() {
+		return nil
+	}
 	cc.mu.Lock()
 	defer cc.mu.Unlock()
 
 	if cc.stopped {
-		return errors.New("ChunkedCollector is stopped")
+		return ErrCollectorStopped
 	}
 	if !cc.started {
 		cc.start()
@@ -87,12 +154,17 @@ func (cc *ChunkedCollector) Collect(span SpanID, anns ...Annotation) error {
 		cc.pendingBySpanID = map[SpanID]*wire.CollectPacket{}
 	}
 
+	// The annotations are sent later, so copy them (the caller may
+	// reuse them).
 	if p, present := cc.pendingBySpanID[span]; present {
 		if len(anns) > 0 {
-			p.Annotation = append(p.Annotation, Annotations(anns).wire()...)
+			p.Annotation = append(p.Annotation, Annotations(anns).clone().wire()...)
 		}
 	} else {
-		cc.pendingBySpanID[span] = newCollectPacket(span, anns)
+		if cc.MaxQueueSize > 0 && len(cc.pending) >= cc.MaxQueueSize {
+			return ErrQueueFull
+		}
+		cc.pendingBySpanID[span] = newCollectPacket(span, Annotations(anns).clone())
 		cc.pending = append(cc.pending, span)
 	}
 
@@ -113,6 +185,15 @@ func (cc *ChunkedCollector) Flush() error {
 	cc.pending = nil
 	cc.mu.Unlock()
 
+	start := time.Now()
+	err := cc.flush(pending, pendingBySpanID)
+	if cc.Stats != nil {
+		cc.Stats.OnFlush(len(pending), time.Since(start), err)
+	}
+	return err
+}
+
+func (cc *ChunkedCollector) flush(pending []SpanID, pendingBySpanID map[SpanID]*wire.CollectPacket) error {
 	var errs []error
 	for _, spanID := range pending {
 		p := pendingBySpanID[spanID]
@@ -124,7 +205,7 @@ func (cc *ChunkedCollector) Flush() error {
 	if len(errs) == 1 {
 		return errs[0]
 	} else if len(errs) > 1 {
-		return fmt.Errorf("ChunkedCollector: multiple errors: %v", errs)
+		return fmt.Errorf("ChunkedCollector: multiple errors: %w", errors.Join(errs...))
 	}
 	return nil
 }
@@ -162,35 +243,91 @@ func (cc *ChunkedCollector) Stop() {
 // collector server (created with NewServer). It sends data
 // immediately when Collect is called. To send data in chunks, use a
 // ChunkedCollector.
+//
+// The addr may be a comma-separated list of addresses, and the host of
+// each may be a DNS name that resolves to several servers. The servers
+// are tried in order (see RemoteCollector.Balance) until a connection
+// is made, and servers that cannot be reached are skipped for a while
+// (see RemoteCollector.DownTime).
 func NewRemoteCollector(addr string) *RemoteCollector {
 	return &RemoteCollector{
-		addr: addr,
-		dial: func() (net.Conn, error) {
-			return net.Dial("tcp", addr)
+		addr:  addr,
+		addrs: splitAddrs(addr),
+		dial: func(addr, host string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, timeout)
 		},
 	}
 }
 
-// NewTLSRemoteCollector creates a RemoteCollector that uses TLS.
+// NewTLSRemoteCollector creates a RemoteCollector that uses TLS. The
+// addr is interpreted as for NewRemoteCollector. Unless tlsConfig
+// specifies a ServerName, the server certificates are verified against
+// the host names in addr (not the addresses they resolve to).
 func NewTLSRemoteCollector(addr string, tlsConfig *tls.Config) *RemoteCollector {
 	return &RemoteCollector{
-		addr: addr,
-		dial: func() (net.Conn, error) {
-			return tls.Dial("tcp", addr, tlsConfig)
+		addr:  addr,
+		addrs: splitAddrs(addr),
+		dial: func(addr, host string, timeout time.Duration) (net.Conn, error) {
+			c := tlsConfig
+			if c == nil || c.ServerName == "" {
+				if c == nil {
+					c = &tls.Config{}
+				} else {
+					c = c.Clone()
+				}
+				c.ServerName = host
+			}
+			return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, c)
 		},
 	}
 }
 
+// DefaultDownTime is how long a RemoteCollector skips a server that
+// could not be reached, if its DownTime is zero.
+const DefaultDownTime = 30 * time.Second
+
+// DefaultDialTimeout is how long a RemoteCollector waits to connect to
+// a server (including the TLS handshake), if its DialTimeout is zero.
+const DefaultDialTimeout = 5 * time.Second
+
 // A RemoteCollector sends data to a collector server (created with
 // NewServer).
 type RemoteCollector struct {
-	addr string
+	addr  string   // as given to the constructor
+	addrs []string // addr split on commas
 
-	dial func() (net.Conn, error)
+	// dial connects to addr, whose host name (before it was resolved)
+	// was host, giving up after timeout.
+	dial func(addr, host string, timeout time.Duration) (net.Conn, error)
 
-	mu    sync.Mutex      // guards pconn
+	mu    sync.Mutex      // guards pconn, current and down
 	pconn pio.WriteCloser // delimited-protobuf remote connection
 
+	// current is the address of the server that pconn is connected to.
+	current string
+
+	// down holds the times until which servers that could not be
+	// reached are skipped, by address.
+	down map[string]time.Time
+
+	// Balance, if true, makes the collector connect to a randomly
+	// chosen server (among those that are not down) instead of the
+	// first one, so that the load of many clients is spread across
+	// the servers.
+	Balance bool
+
+	// DownTime is how long a server that could not be reached (or
+	// whose connection failed) is skipped, unless all servers are
+	// down. If zero, DefaultDownTime is used.
+	DownTime time.Duration
+
+	// DialTimeout is how long to wait to connect to a server (including
+	// the TLS handshake) before trying the next one. Collect blocks for
+	// up to this long per server that doesn't respond (e.g., one behind
+	// a firewall that drops packets). If zero, DefaultDialTimeout is
+	// used.
+	DialTimeout time.Duration
+
 	// Log is the logger to use for errors and warnings. If nil, a new
 	// logger is created.
 	Log   *log.Logger
@@ -198,30 +335,147 @@ type RemoteCollector struct {
 
 	// Debug is whether to log debug messages.
 	Debug bool
+
+	// Stats, if non-nil, receives statistics about sent packets and
+	// connections.
+	Stats CollectorStats
+
+	connected bool // whether a connection was ever made; guarded by mu
 }
 
 // Collect implements the Collector interface by sending the events that
 // occured in the span to the remote collector server (see CollectorServer).
+// It does nothing while recording is disabled (see SetEnabled).
 func (rc *RemoteCollector) Collect(span SpanID, anns ...Annotation) error {
-	return rc.collectAndRetry(newCollectPacket(span, anns))
+	if !Enabled() {
+		return nil
+	}
+	p := newCollectPacket(span, anns)
+	p.Timestamp = proto.Int64(time.Now().UnixNano())
+	return rc.collectAndRetry(p)
 }
 
-// connect makes a connection to the collector server. It must be
-// called with rc.mu held.
-func (rc *RemoteCollector) connect() error {
+// connect makes a connection to a collector server, trying each of
+// the resolved servers (see resolve) in turn until one succeeds. It must
+// be called with rc.mu held.
+func (rc *RemoteCollector) connect(resolved []server) error {
 	if rc.pconn != nil {
 		rc.pconn.Close()
 		rc.pconn = nil
 	}
 
-	c, err := rc.dial()
-	if err == nil {
+	timeout := rc.DialTimeout
+	if timeout == 0 {
+		timeout = DefaultDialTimeout
+	}
+	var errs []error
+	for _, s := range rc.servers(resolved) {
+		c, err := rc.dial(s.addr, s.host, timeout)
+		if rc.Stats != nil {
+			rc.Stats.OnConnect(rc.connected, err)
+		}
+		if err != nil {
+			if rc.Debug {
+				rc.log().Printf("Failed to connect to %s: %s", s.addr, err)
+			}
+			rc.markDown(s.addr)
+			errs = append(errs, err)
+			continue
+		}
+		if rc.Debug {
+			rc.log().Printf("Connected to %s", s.addr)
+		}
+		rc.connected = true
+		rc.current = s.addr
+		delete(rc.down, s.addr)
 		// Create a protobuf delimited writer wrapping the connection. When the
 		// writer is closed, it also closes the underlying connection (see
 		// source code for details).
 		rc.pconn = pio.NewDelimitedWriter(c)
+		return nil
 	}
-	return err
+	if len(errs) == 0 {
+		errs = append(errs, fmt.Errorf("no collector server addresses in %q", rc.addr))
+	}
+	return fmt.Errorf("%w: %w", ErrNotConnected, errors.Join(errs...))
+}
+
+// A server is an address of a collector server.
+type server struct {
+	addr string // host:port, where host is usually an IP address
+	host string // the host name that addr was resolved from
+}
+
+// lookupHost is net.LookupHost, overridden in tests.
+var lookupHost = net.LookupHost
+
+// resolve returns the addresses of the collector servers, resolving
+// their host names. It does not need rc.mu, and must not be called with
+// it held, so that slow DNS lookups don't block other callers.
+func (rc *RemoteCollector) resolve() []server {
+	var servers []server
+	for _, addr := range rc.addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			// Let the dial fail with a descriptive error.
+			servers = append(servers, server{addr: addr, host: addr})
+			continue
+		}
+		ips := []string{host}
+		if net.ParseIP(host) == nil {
+			if resolved, err := lookupHost(host); err == nil && len(resolved) > 0 {
+				ips = resolved
+			}
+		}
+		for _, ip := range ips {
+			servers = append(servers, server{addr: net.JoinHostPort(ip, port), host: host})
+		}
+	}
+	return servers
+}
+
+// servers returns the resolved servers in the order in which they
+// should be tried: those that are not down first (in random order if
+// rc.Balance is set), then those that are down. It must be called with
+// rc.mu held.
+func (rc *RemoteCollector) servers(resolved []server) []server {
+	var up, down []server
+	now := time.Now()
+	for _, s := range resolved {
+		if until, ok := rc.down[s.addr]; ok && now.Before(until) {
+			down = append(down, s)
+		} else {
+			up = append(up, s)
+		}
+	}
+	if rc.Balance {
+		rand.Shuffle(len(up), func(i, j int) { up[i], up[j] = up[j], up[i] })
+	}
+	return append(up, down...)
+}
+
+// markDown records that the server at addr could not be reached. It
+// must be called with rc.mu held.
+func (rc *RemoteCollector) markDown(addr string) {
+	d := rc.DownTime
+	if d == 0 {
+		d = DefaultDownTime
+	}
+	if rc.down == nil {
+		rc.down = map[string]time.Time{}
+	}
+	rc.down[addr] = time.Now().Add(d)
+}
+
+// splitAddrs splits a comma-separated list of addresses.
+func splitAddrs(addr string) []string {
+	var addrs []string
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
 }
 
 // Close closes the connection to the server.
@@ -239,29 +493,59 @@ func (rc *RemoteCollector) Close() error {
 
 func (rc *RemoteCollector) collectAndRetry(p *wire.CollectPacket) error {
 	rc.mu.Lock()
-	defer rc.mu.Unlock()
-
 	if rc.pconn != nil {
 		if err := rc.collect(p); err == nil {
+			rc.mu.Unlock()
 			return nil
 		}
 		if rc.Debug {
 			rc.log().Printf("Reconnecting to send %v", spanIDFromWire(p.Spanid))
 		}
+		rc.disconnect()
+		if rc.Stats != nil {
+			rc.Stats.OnRetry()
+		}
+	}
+	rc.mu.Unlock()
+
+	resolved := rc.resolve()
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.pconn != nil {
+		// Another caller reconnected while the servers were resolved.
+		if err := rc.collect(p); err == nil {
+			return nil
+		}
+		rc.disconnect()
 	}
-	if err := rc.connect(); err != nil {
+	if err := rc.connect(resolved); err != nil {
 		return err
 	}
 	return rc.collect(p)
 }
 
+// disconnect closes the connection to the current server, whose
+// connection failed, and marks it down. It must be called with rc.mu
+// held.
+func (rc *RemoteCollector) disconnect() {
+	rc.pconn.Close()
+	rc.pconn = nil
+	rc.markDown(rc.current)
+}
+
 func (rc *RemoteCollector) collect(p *wire.CollectPacket) error {
 	if rc.Debug {
 		rc.log().Printf("Sending %v", spanIDFromWire(p.Spanid))
 	}
 
 	// Send our message, close writer.
-	if err := rc.pconn.WriteMsg(p); err != nil {
+	err := rc.pconn.WriteMsg(p)
+	if rc.Stats != nil {
+		n := proto.Size(p)
+		rc.Stats.OnSend(n+proto.SizeVarint(uint64(n)), err)
+	}
+	if err != nil {
 		return err
 	}
 
@@ -290,12 +574,110 @@ func NewServer(l net.Listener, c Collector) *CollectorServer {
 	return cs
 }
 
+// An OversizedPolicy determines what a CollectorServer does with a
+// packet that is larger than its MaxMessageSize.
+type OversizedPolicy int
+
+const (
+	// RejectOversized drops oversized packets (logging their span
+	// IDs) and continues reading from the connection.
+	RejectOversized OversizedPolicy = iota
+
+	// TruncateOversized collects the annotations of an oversized
+	// packet that fit within MaxMessageSize and drops the rest.
+	TruncateOversized
+)
+
+// CollectorServerStats holds counts of the packets received by a
+// CollectorServer.
+type CollectorServerStats struct {
+	// Packets is the number of packets that were collected in full.
+	Packets int64
+
+	// Rejected is the number of oversized packets that were dropped.
+	Rejected int64
+
+	// Truncated is the number of oversized packets whose annotations
+	// were partially collected.
+	Truncated int64
+
+	// Malformed is the number of packets that could not be decoded.
+	Malformed int64
+
+	// Duplicates is the number of packets that were dropped because
+	// they duplicated a packet received within the ReorderWindow.
+	Duplicates int64
+}
+
+// An Enricher returns annotations describing the client at a remote
+// address, such as the name of the host or workload it runs in. A
+// CollectorServer adds them to the spans it receives from that client.
+// Its methods may be called concurrently.
+type Enricher interface {
+	Enrich(addr net.Addr) (Annotations, error)
+}
+
+// DefaultMaxDecodeErrors is the number of malformed packets that a
+// client may send before a CollectorServer closes its connection, if
+// the server's MaxDecodeErrors is zero.
+const DefaultMaxDecodeErrors = 10
+
+// maxEnrichedSpans is the number of span IDs per connection that a
+// CollectorServer remembers having enriched, to avoid adding the same
+// annotations to a span each time more of its annotations are received.
+const maxEnrichedSpans = 10000
+
 // A CollectorServer listens for spans and annotations and adds them
 // to a local collector.
 type CollectorServer struct {
 	c Collector
 	l net.Listener
 
+	// MaxMessageSize is the maximum size, in bytes, of a packet that
+	// the server will read into memory. If zero, 32KiB is used.
+	MaxMessageSize int
+
+	// Oversized is what to do with packets larger than MaxMessageSize.
+	Oversized OversizedPolicy
+
+	// MaxDecodeErrors is the number of malformed packets that a client
+	// may send before its connection is closed. If zero,
+	// DefaultMaxDecodeErrors is used; if negative, the connection is
+	// closed after the first malformed packet.
+	MaxDecodeErrors int
+
+	// Enricher, if non-nil, is called once per client connection, and
+	// the annotations it returns are added to each span received on
+	// the connection. If it returns an error, spans are collected
+	// without them.
+	Enricher Enricher
+
+	// ReorderWindow, if positive, is how long the server holds each
+	// packet it receives before collecting it. Packets held at the
+	// same time are collected in the order in which their clients sent
+	// them (by the timestamps that RemoteCollector adds to packets),
+	// rather than the order in which they arrived, and a packet that
+	// is received again within the window (e.g., because the client
+	// retried it after a broken connection) is dropped. It must be set
+	// before Start is called.
+	//
+	// At most 4096 packets are held at once; when that many are held,
+	// they are all collected without waiting for the window to pass.
+	// Because held packets are collected after the client has moved on,
+	// an error collecting one is logged rather than closing the
+	// client's connection (as it is when the window is zero).
+	ReorderWindow time.Duration
+	reorderOnce   sync.Once
+	reorder       *reorderBuffer
+
+	// stats holds the counts returned by Stats. Its fields are
+	// accessed atomically.
+	stats CollectorServerStats
+
+	// conns holds the active client connections returned by Conns.
+	conns   map[*connState]struct{}
+	connsMu sync.Mutex
+
 	// Log is the logger to use for errors and warnings. If nil, a new
 	// logger is created.
 	Log   *log.Logger
@@ -332,18 +714,78 @@ func (cs *CollectorServer) handleConn(conn net.Conn) (err error) {
 		}
 	}()
 	defer conn.Close()
+	st := cs.trackConn(conn)
+	defer cs.untrackConn(st)
+	if err := st.handshake(); err != nil {
+		return fmt.Errorf("TLS handshake: %w", err)
+	}
 
-	rdr := pio.NewDelimitedReader(conn, maxMessageSize)
-	defer rdr.Close()
+	maxSize := cs.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = maxMessageSize
+	}
+	maxDecodeErrors := cs.MaxDecodeErrors
+	switch {
+	case maxDecodeErrors == 0:
+		maxDecodeErrors = DefaultMaxDecodeErrors
+	case maxDecodeErrors < 0:
+		maxDecodeErrors = 0
+	}
+	var decodeErrors int
+	var (
+		enrichment Annotations
+		enriched   map[SpanID]struct{}
+	)
+	if cs.Enricher != nil {
+		enrichment, err = cs.Enricher.Enrich(conn.RemoteAddr())
+		if err != nil {
+			cs.log().Printf("Client %s: Enrich: %s", conn.RemoteAddr(), err)
+			enrichment, err = nil, nil
+		}
+		st.setLabels(enrichment)
+		enriched = map[SpanID]struct{}{}
+	}
+
+	rdr := bufio.NewReader(conn)
 	for {
-		p := &wire.CollectPacket{}
-		if err = rdr.ReadMsg(p); err != nil {
+		var (
+			p    *wire.CollectPacket
+			size int
+		)
+		p, size, err = readPacket(rdr, maxSize)
+		if errors.Is(err, ErrMalformedPacket) {
+			atomic.AddInt64(&cs.stats.Malformed, 1)
+			st.error(time.Now(), err)
+			decodeErrors++
+			if decodeErrors > maxDecodeErrors {
+				return fmt.Errorf("ReadMsg: too many malformed packets, last: %w", err)
+			}
+			cs.log().Printf("Client %s: ReadMsg: %s", conn.RemoteAddr(), err)
+			err = nil
+			continue
+		}
+		if err != nil {
 			if err == io.EOF {
 				return nil
 			}
-			return fmt.Errorf("ReadMsg: %s", err)
+			return fmt.Errorf("ReadMsg: %w", err)
+		}
+		if size > maxSize {
+			st.error(time.Now(), fmt.Errorf("oversized packet for span %s (%d bytes, max %d)", oversizedSpan(p), size, maxSize))
+			if p == nil || cs.Oversized != TruncateOversized {
+				atomic.AddInt64(&cs.stats.Rejected, 1)
+				cs.log().Printf("Client %s: rejected oversized packet for span %s (%d bytes, max %d)", conn.RemoteAddr(), oversizedSpan(p), size, maxSize)
+				continue
+			}
+			atomic.AddInt64(&cs.stats.Truncated, 1)
+			if cs.Debug {
+				cs.log().Printf("Client %s: truncated oversized packet for span %s (%d bytes, max %d)", conn.RemoteAddr(), oversizedSpan(p), size, maxSize)
+			}
+		} else {
+			atomic.AddInt64(&cs.stats.Packets, 1)
 		}
 
+		st.packet(time.Now(), p.GetTimestamp())
 		spanID := spanIDFromWire(p.Spanid)
 		if cs.Debug || cs.Trace {
 			cs.log().Printf("Client %s: received span %v with %d annotations", conn.RemoteAddr(), spanID, len(p.Annotation))
@@ -354,12 +796,57 @@ func (cs *CollectorServer) handleConn(conn net.Conn) (err error) {
 			}
 		}
 
-		if err = cs.c.Collect(spanID, annotationsFromWire(p.Annotation)...); err != nil {
-			return fmt.Errorf("Collect %v: %s", spanID, err)
+		anns := annotationsFromWire(p.Annotation)
+		var sum uint64
+		if cs.ReorderWindow > 0 {
+			sum = hashAnnotations(anns) // before enrichment, which may differ between connections
+		}
+		if _, done := enriched[spanID]; len(enrichment) > 0 && !done {
+			if len(enriched) >= maxEnrichedSpans {
+				enriched = map[SpanID]struct{}{}
+			}
+			enriched[spanID] = struct{}{}
+			anns = append(anns, enrichment...)
+		}
+
+		if cs.ReorderWindow > 0 {
+			if !cs.reorderBuffer().add(spanID, anns, p.GetTimestamp(), sum, time.Now()) {
+				atomic.AddInt64(&cs.stats.Duplicates, 1)
+				if cs.Debug {
+					cs.log().Printf("Client %s: dropped duplicate packet for span %v", conn.RemoteAddr(), spanID)
+				}
+			}
+			continue
+		}
+		if err = cs.c.Collect(spanID, anns...); err != nil {
+			return fmt.Errorf("Collect %v: %w", spanID, err)
 		}
 	}
 }
 
+// reorderBuffer returns the buffer that holds packets for the
+// server's ReorderWindow, creating it on first use.
+func (cs *CollectorServer) reorderBuffer() *reorderBuffer {
+	cs.reorderOnce.Do(func() {
+		cs.reorder = newReorderBuffer(cs.c, cs.ReorderWindow)
+		cs.reorder.onCommitError = func(spanID SpanID, err error) {
+			cs.log().Printf("Collect %v: %s", spanID, err)
+		}
+	})
+	return cs.reorder
+}
+
+// Stats returns counts of the packets received by the server.
+func (cs *CollectorServer) Stats() CollectorServerStats {
+	return CollectorServerStats{
+		Packets:    atomic.LoadInt64(&cs.stats.Packets),
+		Rejected:   atomic.LoadInt64(&cs.stats.Rejected),
+		Truncated:  atomic.LoadInt64(&cs.stats.Truncated),
+		Malformed:  atomic.LoadInt64(&cs.stats.Malformed),
+		Duplicates: atomic.LoadInt64(&cs.stats.Duplicates),
+	}
+}
+
 func (cs *CollectorServer) log() *log.Logger {
 	cs.logMu.Lock()
 	defer cs.logMu.Unlock()
@@ -368,3 +855,172 @@ func (cs *CollectorServer) log() *log.Logger {
 	}
 	return cs.Log
 }
+
+// readPacket reads a length-delimited packet from r. It returns the size
+// of the packet, which may exceed maxSize. In that case, only the first
+// maxSize bytes are kept (the rest are discarded), and the packet holds
+// the fields that were complete within them, or nil if it lacks a span
+// ID.
+//
+// If the packet was read in full but could not be decoded, the error
+// wraps ErrMalformedPacket and the next packet may still be read from r.
+// Other errors leave r at an unknown position in the stream.
+func readPacket(r *bufio.Reader, maxSize int) (p *wire.CollectPacket, size int, err error) {
+	length, err := readLength(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	size = int(length)
+
+	n := size
+	if n > maxSize {
+		n = maxSize
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, 0, unexpectedEOF(err)
+	}
+	if size <= maxSize {
+		p, err := decodePacket(buf)
+		if err != nil {
+			return nil, size, err
+		}
+		return p, size, nil
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r, int64(size-maxSize)); err != nil {
+		return nil, 0, unexpectedEOF(err)
+	}
+	p, err = decodePacket(completeFields(buf))
+	if err != nil {
+		return nil, size, nil
+	}
+	return p, size, nil
+}
+
+// readLength reads the varint length prefix of a packet. Unlike
+// binary.ReadUvarint, it rejects lengths that are zero, larger than
+// math.MaxInt32, or not minimally encoded, all of which indicate a
+// corrupt or hostile stream.
+func readLength(r io.ByteReader) (uint64, error) {
+	var x uint64
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if i > 0 {
+				return 0, unexpectedEOF(err)
+			}
+			return 0, err
+		}
+		if i > 0 && b == 0 {
+			return 0, errors.New("packet length is not minimally encoded")
+		}
+		x |= uint64(b&0x7f) << (7 * uint(i))
+		if x > math.MaxInt32 {
+			return 0, fmt.Errorf("packet length %d is too large", x)
+		}
+		if b < 0x80 {
+			break
+		}
+	}
+	if x == 0 {
+		return 0, errors.New("packet length is zero")
+	}
+	return x, nil
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF if err is io.EOF (which
+// means the stream ended in the middle of a packet), and err otherwise.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// decodePacket decodes and validates a packet. It never panics: a panic
+// in the protobuf decoder is returned as an error.
+func decodePacket(buf []byte) (p *wire.CollectPacket, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, err = nil, fmt.Errorf("%w: decoder panic: %v", ErrMalformedPacket, r)
+		}
+	}()
+
+	p = &wire.CollectPacket{}
+	if err := proto.Unmarshal(buf, p); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedPacket, err)
+	}
+	if p.Spanid == nil || p.Spanid.Trace == nil || p.Spanid.Span == nil {
+		return nil, fmt.Errorf("%w: missing span ID", ErrMalformedPacket)
+	}
+	for _, a := range p.Annotation {
+		if a == nil || a.Key == nil {
+			return nil, fmt.Errorf("%w: annotation without key", ErrMalformedPacket)
+		}
+	}
+	return p, nil
+}
+
+// completeFields returns the longest prefix of the (truncated) protobuf
+// message buf that consists of complete fields.
+func completeFields(buf []byte) []byte {
+	var i int
+	for i < len(buf) {
+		tag, n := proto.DecodeVarint(buf[i:])
+		if n == 0 {
+			break
+		}
+		end, ok := skipField(buf, i+n, tag)
+		if !ok {
+			break
+		}
+		i = end
+	}
+	return buf[:i]
+}
+
+// skipField returns the offset in buf of the end of the field with the
+// given tag whose value starts at offset i. It returns false if the
+// field is incomplete or malformed.
+func skipField(buf []byte, i int, tag uint64) (int, bool) {
+	switch tag & 7 {
+	case proto.WireVarint:
+		_, n := proto.DecodeVarint(buf[i:])
+		return i + n, n > 0
+	case proto.WireFixed64:
+		return i + 8, i+8 <= len(buf)
+	case proto.WireBytes:
+		l, n := proto.DecodeVarint(buf[i:])
+		if n == 0 || l > uint64(len(buf)-i-n) {
+			return 0, false
+		}
+		return i + n + int(l), true
+	case proto.WireStartGroup:
+		for {
+			t, n := proto.DecodeVarint(buf[i:])
+			if n == 0 {
+				return 0, false
+			}
+			i += n
+			if t&7 == proto.WireEndGroup {
+				return i, t>>3 == tag>>3
+			}
+			var ok bool
+			if i, ok = skipField(buf, i, t); !ok {
+				return 0, false
+			}
+		}
+	case proto.WireFixed32:
+		return i + 4, i+4 <= len(buf)
+	}
+	return 0, false
+}
+
+// oversizedSpan describes the span of an oversized packet for logging.
+func oversizedSpan(p *wire.CollectPacket) string {
+	if p == nil || p.Spanid == nil {
+		return "(unknown)"
+	}
+	return spanIDFromWire(p.Spanid).String()
+}
@@ -1,16 +1,24 @@
 package appdash
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net"
 	"reflect"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	pio "github.com/gogo/protobuf/io"
+	"github.com/gogo/protobuf/proto"
 	"sourcegraph.com/sourcegraph/appdash/internal/wire"
 )
 
@@ -54,6 +62,542 @@ func TestCollectorServer(t *testing.T) {
 	}
 }
 
+func TestCollectorServer_oversized(t *testing.T) {
+	small := newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k1", []byte("v1")}})
+	big := newCollectPacket(SpanID{1, 3, 4}, Annotations{{"k2", []byte("v2")}, {"k3", bytes.Repeat([]byte("x"), 200)}})
+	tests := map[OversizedPolicy]struct {
+		want      []*wire.CollectPacket
+		wantStats CollectorServerStats
+	}{
+		RejectOversized: {
+			want:      []*wire.CollectPacket{small, small},
+			wantStats: CollectorServerStats{Packets: 2, Rejected: 1},
+		},
+		TruncateOversized: {
+			want: []*wire.CollectPacket{
+				small,
+				newCollectPacket(SpanID{1, 3, 4}, Annotations{{"k2", []byte("v2")}}),
+				small,
+			},
+			wantStats: CollectorServerStats{Packets: 2, Truncated: 1},
+		},
+	}
+	for policy, test := range tests {
+		var (
+			packets   []*wire.CollectPacket
+			packetsMu sync.Mutex
+		)
+		mc := collectorFunc(func(span SpanID, anns ...Annotation) error {
+			packetsMu.Lock()
+			defer packetsMu.Unlock()
+			packets = append(packets, newCollectPacket(span, anns))
+			return nil
+		})
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs := NewServer(l, mc)
+		cs.MaxMessageSize = 100
+		cs.Oversized = policy
+		cs.Log = log.New(ioutil.Discard, "", 0)
+		go cs.Start()
+
+		rc := NewRemoteCollector(l.Addr().String())
+		for _, p := range []*wire.CollectPacket{small, big, small} {
+			if err := rc.Collect(spanIDFromWire(p.Spanid), annotationsFromWire(p.Annotation)...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rc.Close()
+
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			packetsMu.Lock()
+			n := len(packets)
+			packetsMu.Unlock()
+			if n == len(test.want) {
+				break
+			}
+		}
+		if stats := cs.Stats(); stats != test.wantStats {
+			t.Errorf("policy %d: got stats %+v, want %+v", policy, stats, test.wantStats)
+		}
+		packetsMu.Lock()
+		if !reflect.DeepEqual(packets, test.want) {
+			t.Errorf("policy %d: server collected %v, want %v", policy, packets, test.want)
+		}
+		packetsMu.Unlock()
+	}
+}
+
+type enricherFunc func(net.Addr) (Annotations, error)
+
+func (f enricherFunc) Enrich(addr net.Addr) (Annotations, error) { return f(addr) }
+
+func TestCollectorServer_enricher(t *testing.T) {
+	var (
+		packets   []*wire.CollectPacket
+		packetsMu sync.Mutex
+	)
+	mc := collectorFunc(func(span SpanID, anns ...Annotation) error {
+		packetsMu.Lock()
+		defer packetsMu.Unlock()
+		packets = append(packets, newCollectPacket(span, anns))
+		return nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewServer(l, mc)
+	cs.Enricher = enricherFunc(func(addr net.Addr) (Annotations, error) {
+		return Annotations{{"Host", []byte("h")}}, nil
+	})
+	go cs.Start()
+
+	rc := NewRemoteCollector(l.Addr().String())
+	rc.Collect(SpanID{1, 2, 3}, Annotation{"k1", []byte("v1")})
+	rc.Collect(SpanID{1, 2, 3}, Annotation{"k2", []byte("v2")})
+	rc.Collect(SpanID{1, 3, 4}, Annotation{"k3", []byte("v3")})
+	rc.Close()
+
+	want := []*wire.CollectPacket{
+		newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k1", []byte("v1")}, {"Host", []byte("h")}}),
+		newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k2", []byte("v2")}}),
+		newCollectPacket(SpanID{1, 3, 4}, Annotations{{"k3", []byte("v3")}, {"Host", []byte("h")}}),
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		packetsMu.Lock()
+		n := len(packets)
+		packetsMu.Unlock()
+		if n == len(want) {
+			break
+		}
+	}
+	packetsMu.Lock()
+	defer packetsMu.Unlock()
+	if !reflect.DeepEqual(packets, want) {
+		t.Errorf("server collected %v, want %v", packets, want)
+	}
+}
+
+func TestCollectorServer_reorder(t *testing.T) {
+	var (
+		packets   []*wire.CollectPacket
+		packetsMu sync.Mutex
+	)
+	mc := collectorFunc(func(span SpanID, anns ...Annotation) error {
+		packetsMu.Lock()
+		defer packetsMu.Unlock()
+		packets = append(packets, newCollectPacket(span, anns))
+		return nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewServer(l, mc)
+	cs.ReorderWindow = 50 * time.Millisecond
+	go cs.Start()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w := pio.NewDelimitedWriter(conn)
+	send := func(ts int64, k string) {
+		p := newCollectPacket(SpanID{1, 2, 3}, Annotations{{k, []byte("v")}})
+		p.Timestamp = proto.Int64(ts)
+		if err := w.WriteMsg(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(30, "k3")
+	send(10, "k1")
+	send(30, "k3") // duplicate
+	send(20, "k2")
+
+	want := []*wire.CollectPacket{
+		newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k1", []byte("v")}}),
+		newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k2", []byte("v")}}),
+		newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k3", []byte("v")}}),
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		packetsMu.Lock()
+		n := len(packets)
+		packetsMu.Unlock()
+		if n == len(want) {
+			break
+		}
+	}
+	time.Sleep(2 * cs.ReorderWindow)
+	packetsMu.Lock()
+	defer packetsMu.Unlock()
+	if !reflect.DeepEqual(packets, want) {
+		t.Errorf("server collected %v, want %v", packets, want)
+	}
+	if got := cs.Stats(); got.Packets != 4 || got.Duplicates != 1 {
+		t.Errorf("got stats %+v, want 4 packets and 1 duplicate", got)
+	}
+}
+
+func TestReorderBuffer_limits(t *testing.T) {
+	var (
+		spans   []SpanID
+		spansMu sync.Mutex
+	)
+	b := newReorderBuffer(collectorFunc(func(span SpanID, anns ...Annotation) error {
+		spansMu.Lock()
+		defer spansMu.Unlock()
+		spans = append(spans, span)
+		return nil
+	}), time.Hour)
+	b.maxPending = 3
+
+	// Filling the buffer commits the pending packets at once, in the
+	// order in which they were sent.
+	now := time.Now()
+	for i, ts := range []int64{30, 10, 20} {
+		b.add(SpanID{1, ID(i + 1), 0}, nil, ts, 0, now)
+	}
+	spansMu.Lock()
+	if want := []SpanID{{1, 2, 0}, {1, 3, 0}, {1, 1, 0}}; !reflect.DeepEqual(spans, want) {
+		t.Errorf("got committed spans %v, want %v", spans, want)
+	}
+	spansMu.Unlock()
+
+	// Once nothing is pending or remembered, the ticker goroutine
+	// stops.
+	b = newReorderBuffer(collectorFunc(func(SpanID, ...Annotation) error { return nil }), time.Millisecond)
+	b.add(SpanID{1, 2, 3}, nil, 10, 0, time.Now())
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		b.mu.Lock()
+		started := b.started
+		b.mu.Unlock()
+		if !started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ticker goroutine is still running with an empty buffer")
+		}
+	}
+}
+
+func TestCollectorServer_Conns(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan SpanID, 2)
+	cs := NewServer(l, collectorFunc(func(span SpanID, anns ...Annotation) error {
+		received <- span
+		return nil
+	}))
+	cs.Log = log.New(ioutil.Discard, "", 0)
+	go cs.Start()
+
+	rc := NewRemoteCollector(l.Addr().String())
+	for _, span := range []SpanID{{1, 2, 3}, {1, 3, 4}} {
+		if err := rc.Collect(span); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for span")
+		}
+	}
+
+	conns := cs.Conns()
+	if len(conns) != 1 {
+		t.Fatalf("got %d connections, want 1", len(conns))
+	}
+	c := conns[0]
+	if !strings.HasPrefix(c.RemoteAddr, "127.0.0.1:") {
+		t.Errorf("got remote addr %q, want 127.0.0.1:*", c.RemoteAddr)
+	}
+	if c.Packets != 2 || c.SpansPerSec <= 0 {
+		t.Errorf("got %d packets at %v spans/sec, want 2 at > 0", c.Packets, c.SpansPerSec)
+	}
+	if c.ProtocolVersion != 2 || c.Lag <= 0 || c.LastPacket.IsZero() {
+		t.Errorf("got protocol version %d, lag %v and last packet %v, want version 2 with lag", c.ProtocolVersion, c.Lag, c.LastPacket)
+	}
+
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); len(cs.Conns()) > 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("closed connection is still listed")
+		}
+	}
+}
+
+func TestCollectorServer_Conns_tlsHandshake(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewServer(tls.NewListener(l, &tls.Config{}), collectorFunc(func(SpanID, ...Annotation) error { return nil }))
+	cs.Log = log.New(ioutil.Discard, "", 0)
+	go cs.Start()
+
+	// A client that connects but never sends a ClientHello must not
+	// block Conns.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		done := make(chan []ConnInfo, 1)
+		go func() { done <- cs.Conns() }()
+		select {
+		case conns := <-done:
+			if len(conns) == 1 {
+				if conns[0].Identity != "" {
+					t.Errorf("got identity %q before the handshake, want none", conns[0].Identity)
+				}
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Conns blocked on a connection in its TLS handshake")
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection was not listed")
+		}
+	}
+}
+
+func TestRemoteCollector_failover(t *testing.T) {
+	// An address that nothing listens on.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan SpanID, 1)
+	cs := NewServer(l, collectorFunc(func(span SpanID, anns ...Annotation) error {
+		received <- span
+		return nil
+	}))
+	go cs.Start()
+
+	rc := NewRemoteCollector(dead.Addr().String() + ", " + l.Addr().String())
+	defer rc.Close()
+	if err := rc.Collect(SpanID{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case span := <-received:
+		if want := (SpanID{1, 2, 3}); span != want {
+			t.Errorf("got span %v, want %v", span, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for span")
+	}
+	if _, down := rc.down[dead.Addr().String()]; !down {
+		t.Errorf("unreachable server %s is not marked down", dead.Addr())
+	}
+}
+
+func TestRemoteCollector_servers(t *testing.T) {
+	defer func(orig func(string) ([]string, error)) { lookupHost = orig }(lookupHost)
+	lookupHost = func(host string) ([]string, error) {
+		if host != "collectors.example.com" {
+			t.Errorf("unexpected lookup of %q", host)
+		}
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}
+
+	rc := NewRemoteCollector("collectors.example.com:7701,10.0.0.3:7701")
+	rc.markDown("10.0.0.1:7701")
+	want := []server{
+		{addr: "10.0.0.2:7701", host: "collectors.example.com"},
+		{addr: "10.0.0.3:7701", host: "10.0.0.3"},
+		{addr: "10.0.0.1:7701", host: "collectors.example.com"},
+	}
+	if got := rc.servers(rc.resolve()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got servers %+v, want %+v", got, want)
+	}
+}
+
+func TestRemoteCollector_resolveUnlocked(t *testing.T) {
+	rc := NewRemoteCollector("collectors.example.com:7701")
+	rc.DialTimeout = 100 * time.Millisecond
+	defer func(orig func(string) ([]string, error)) { lookupHost = orig }(lookupHost)
+	lookupHost = func(host string) ([]string, error) {
+		// Other callers must not be blocked by a slow DNS lookup.
+		if !rc.mu.TryLock() {
+			t.Error("DNS lookup was done with the collector locked")
+		} else {
+			rc.mu.Unlock()
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+	rc.Collect(SpanID{1, 2, 3})
+}
+
+func TestRemoteCollector_dialTimeout(t *testing.T) {
+	// A server that accepts connections but never completes the TLS
+	// handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	rc := NewTLSRemoteCollector(l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	rc.DialTimeout = 100 * time.Millisecond
+	done := make(chan error, 1)
+	go func() { done <- rc.Collect(SpanID{1, 2, 3}) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Collect succeeded, want a connection error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Collect did not give up after the dial timeout")
+	}
+}
+
+// framedPacket returns p as it is sent on the wire.
+func framedPacket(p *wire.CollectPacket) []byte {
+	var buf bytes.Buffer
+	pio.NewDelimitedWriter(&buf).WriteMsg(p)
+	return buf.Bytes()
+}
+
+func TestCollectorServer_malformed(t *testing.T) {
+	var (
+		spans   []SpanID
+		spansMu sync.Mutex
+	)
+	mc := collectorFunc(func(span SpanID, anns ...Annotation) error {
+		spansMu.Lock()
+		defer spansMu.Unlock()
+		spans = append(spans, span)
+		return nil
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewServer(l, mc)
+	cs.Log = log.New(ioutil.Discard, "", 0)
+	go cs.Start()
+
+	// A packet without a parent span ID (which is optional).
+	trace, span := uint64(1), uint64(2)
+	noParent := framedPacket(&wire.CollectPacket{Spanid: &wire.CollectPacket_SpanID{Trace: &trace, Span: &span}})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write(noParent)
+	conn.Write([]byte{3, 0xff, 0xff, 0xff}) // well-framed garbage
+	conn.Write(framedPacket(newCollectPacket(SpanID{3, 4, 5}, nil)))
+	conn.Close()
+
+	want := []SpanID{{1, 2, 0}, {3, 4, 5}}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		spansMu.Lock()
+		n := len(spans)
+		spansMu.Unlock()
+		if n == len(want) {
+			break
+		}
+	}
+	spansMu.Lock()
+	defer spansMu.Unlock()
+	if !reflect.DeepEqual(spans, want) {
+		t.Errorf("got spans %v, want %v", spans, want)
+	}
+	if got := cs.Stats().Malformed; got != 1 {
+		t.Errorf("got %d malformed packets, want 1", got)
+	}
+}
+
+func TestReadLength(t *testing.T) {
+	tests := map[string]struct {
+		want    uint64
+		wantErr bool
+	}{
+		"\x05":                 {want: 5},
+		"\xac\x02":             {want: 300},
+		"\x00":                 {wantErr: true}, // zero
+		"\x85\x00":             {wantErr: true}, // not minimally encoded
+		"\xff\xff\xff\xff\x7f": {wantErr: true}, // too large
+		"\x85":                 {wantErr: true}, // truncated
+	}
+	for in, test := range tests {
+		got, err := readLength(bufio.NewReader(strings.NewReader(in)))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error %v", in, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("%q: got %d, want %d", in, got, test.want)
+		}
+	}
+}
+
+func TestReadPacket_largeLength(t *testing.T) {
+	// A length prefix of 1 GiB with no body must not make the server
+	// allocate a buffer of that size.
+	const maxSize = 64
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	before := ms.TotalAlloc
+	r := bufio.NewReader(bytes.NewReader([]byte{0x80, 0x80, 0x80, 0x80, 0x04}))
+	if _, _, err := readPacket(r, maxSize); err == nil {
+		t.Fatal("got no error for a truncated packet")
+	}
+	runtime.ReadMemStats(&ms)
+	if n := ms.TotalAlloc - before; n > 1<<20 {
+		t.Errorf("allocated %d bytes to read a packet of at most %d bytes", n, maxSize)
+	}
+}
+
+func FuzzReadPacket(f *testing.F) {
+	f.Add(framedPacket(newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k", []byte("v")}})))
+	f.Add(framedPacket(newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k", bytes.Repeat([]byte("v"), 100)}})))
+	f.Add([]byte{3, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x80, 0x80, 0x80, 0x80, 0x08})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		const maxSize = 64
+		r := bufio.NewReader(bytes.NewReader(data))
+		for {
+			p, size, err := readPacket(r, maxSize)
+			if errors.Is(err, ErrMalformedPacket) {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if p == nil {
+				if size <= maxSize {
+					t.Fatalf("got nil packet of %d bytes without error", size)
+				}
+				continue
+			}
+			// These must not panic.
+			spanIDFromWire(p.Spanid)
+			annotationsFromWire(p.Annotation)
+		}
+	})
+}
+
 func TestCollectorServer_stress(t *testing.T) {
 	if testing.Short() {
 		t.Skip()
@@ -189,6 +733,149 @@ func TestChunkedCollector(t *testing.T) {
 	}
 }
 
+func TestChunkedCollector_errors(t *testing.T) {
+	errCollect := errors.New("collect")
+	mc := collectorFunc(func(span SpanID, anns ...Annotation) error {
+		return errCollect
+	})
+
+	cc := &ChunkedCollector{
+		Collector:    mc,
+		MinInterval:  time.Hour,
+		MaxQueueSize: 2,
+	}
+	defer cc.Stop()
+	cc.Collect(SpanID{1, 2, 3})
+	cc.Collect(SpanID{1, 3, 4})
+	if err := cc.Collect(SpanID{1, 4, 5}); err != ErrQueueFull {
+		t.Errorf("got err %v, want ErrQueueFull", err)
+	}
+	if err := cc.Collect(SpanID{1, 2, 3}); err != nil {
+		t.Errorf("got err %v for pending span, want nil", err)
+	}
+
+	if err := cc.Flush(); !errors.Is(err, errCollect) {
+		t.Errorf("got Flush err %v, want it to wrap the underlying collector's errors", err)
+	}
+
+	cc2 := &ChunkedCollector{Collector: mc, MinInterval: time.Hour}
+	cc2.Collect(SpanID{1, 2, 3})
+	cc2.Stop()
+	if err := cc2.Collect(SpanID{1, 2, 3}); err != ErrCollectorStopped {
+		t.Errorf("after Stop: got err %v, want ErrCollectorStopped", err)
+	}
+}
+
+func TestRemoteCollector_notConnected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	rc := NewRemoteCollector(addr)
+	err = rc.Collect(SpanID{1, 2, 3})
+	if !errors.Is(err, ErrNotConnected) {
+		t.Errorf("got err %v, want ErrNotConnected", err)
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("got err %v, want it to wrap the dial error", err)
+	}
+}
+
+type statCounts struct {
+	sent, bytes, sendErrors int
+	retries                 int
+	connects, reconnects    int
+	connectErrors           int
+	flushes, flushedSpans   int
+}
+
+type recordingStats struct {
+	mu sync.Mutex
+	statCounts
+}
+
+func (s *recordingStats) OnSend(bytes int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.sendErrors++
+		return
+	}
+	s.sent++
+	s.bytes += bytes
+}
+
+func (s *recordingStats) OnRetry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries++
+}
+
+func (s *recordingStats) OnConnect(reconnect bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil:
+		s.connectErrors++
+	case reconnect:
+		s.reconnects++
+	default:
+		s.connects++
+	}
+}
+
+func (s *recordingStats) OnFlush(spans int, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushes++
+	s.flushedSpans += spans
+}
+
+func TestCollectorStats(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewServer(l, collectorFunc(func(SpanID, ...Annotation) error { return nil }))
+	go cs.Start()
+
+	stats := &recordingStats{}
+	rc := NewRemoteCollector(l.Addr().String())
+	rc.Stats = stats
+	cc := &ChunkedCollector{Collector: rc, MinInterval: time.Hour, Stats: stats}
+	defer cc.Stop()
+
+	cc.Collect(SpanID{1, 2, 3}, Annotation{"k1", []byte("v1")})
+	cc.Collect(SpanID{1, 3, 4}, Annotation{"k2", []byte("v2")})
+	if err := cc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reported sizes are those of the framed packets, which carry
+	// fixed-size client timestamps.
+	var buf bytes.Buffer
+	w := pio.NewDelimitedWriter(&buf)
+	for _, p := range []*wire.CollectPacket{
+		newCollectPacket(SpanID{1, 2, 3}, Annotations{{"k1", []byte("v1")}}),
+		newCollectPacket(SpanID{1, 3, 4}, Annotations{{"k2", []byte("v2")}}),
+	} {
+		p.Timestamp = proto.Int64(0)
+		w.WriteMsg(p)
+	}
+
+	want := statCounts{sent: 2, bytes: buf.Len(), connects: 1, flushes: 1, flushedSpans: 2}
+	stats.mu.Lock()
+	got := stats.statCounts
+	stats.mu.Unlock()
+	if got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
 // collectorFunc implements the Collector interface by calling the function.
 type collectorFunc func(SpanID, ...Annotation) error
 
@@ -0,0 +1,116 @@
+package appdash
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A ConflictPolicy determines how a MemoryStore merges annotations
+// collected for a span that already has annotations with the same
+// keys, as happens when several reporters (e.g., a client and a proxy,
+// or a client retrying a packet) report on the same span.
+type ConflictPolicy int
+
+const (
+	// KeepAll keeps every annotation, in the order collected. It is
+	// the default.
+	KeepAll ConflictPolicy = iota
+
+	// MultiValued keeps each distinct value of a key once, in the
+	// order first collected, so that a span that is reported
+	// identically by several reporters has no duplicate annotations.
+	MultiValued
+
+	// LastWriterWins keeps only the most recently collected value of
+	// each key, in the position of the key's first value. Repeated
+	// events of the same schema (e.g., several Log events) on a span
+	// have the same keys, so only the last of them is kept; it suits
+	// reporters that send the complete state of a span each time.
+	//
+	// Keys that are multi-valued by design, the event schemas
+	// ("_schema:*") and span kinds ("Span.Kind"), are merged as with
+	// MultiValued, so that a span reported by both a client and a
+	// server keeps both of its events.
+	LastWriterWins
+)
+
+var conflictPolicyNames = map[ConflictPolicy]string{
+	KeepAll:        "keep-all",
+	MultiValued:    "multi-valued",
+	LastWriterWins: "last-writer-wins",
+}
+
+// String returns the name of the policy, as accepted by
+// ParseConflictPolicy.
+func (p ConflictPolicy) String() string {
+	if name, ok := conflictPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+}
+
+// ParseConflictPolicy returns the policy with the given name:
+// "keep-all", "multi-valued" or "last-writer-wins".
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	for p, name := range conflictPolicyNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("appdash: unknown conflict policy %q (want %q, %q or %q)", s, KeepAll, MultiValued, LastWriterWins)
+}
+
+// multiValuedKey reports whether a span may have several values for
+// the key by design, which LastWriterWins must not overwrite.
+func multiValuedKey(key string) bool {
+	return key == spanKindKey || strings.HasPrefix(key, schemaPrefix)
+}
+
+// merge returns the annotations of a span that had the annotations dst
+// once src are collected for it. It may modify dst and retain src.
+func (p ConflictPolicy) merge(dst, src Annotations) Annotations {
+	switch p {
+	case MultiValued:
+		seen := make(map[string]struct{}, len(dst)+len(src))
+		for _, a := range dst {
+			seen[a.Key+"\x00"+string(a.Value)] = struct{}{}
+		}
+		for _, a := range src {
+			k := a.Key + "\x00" + string(a.Value)
+			if _, dup := seen[k]; !dup {
+				seen[k] = struct{}{}
+				dst = append(dst, a)
+			}
+		}
+		return dst
+	case LastWriterWins:
+		// Multi-valued keys are indexed by value too, so that only
+		// duplicates of their values are overwritten.
+		indexKey := func(a Annotation) string {
+			if multiValuedKey(a.Key) {
+				return a.Key + "\x00" + string(a.Value)
+			}
+			return a.Key
+		}
+		index := make(map[string]int, len(dst)+len(src))
+		for i, a := range dst {
+			if _, present := index[indexKey(a)]; !present {
+				index[indexKey(a)] = i
+			}
+		}
+		for _, a := range src {
+			k := indexKey(a)
+			if i, present := index[k]; present {
+				dst[i].Value = a.Value
+				continue
+			}
+			index[k] = len(dst)
+			dst = append(dst, a)
+		}
+		return dst
+	}
+	if len(dst) == 0 {
+		return src
+	}
+	return append(dst, src...)
+}
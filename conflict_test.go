@@ -0,0 +1,81 @@
+package appdash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemoryStore_Conflicts(t *testing.T) {
+	collects := []Annotations{
+		{{"Name", []byte("a")}, {"k", []byte("1")}},
+		{{"Name", []byte("a")}, {"k", []byte("2")}},
+		{{"k", []byte("1")}, {"k2", []byte("x")}},
+	}
+	tests := map[ConflictPolicy]Annotations{
+		KeepAll: {
+			{"Name", []byte("a")}, {"k", []byte("1")},
+			{"Name", []byte("a")}, {"k", []byte("2")},
+			{"k", []byte("1")}, {"k2", []byte("x")},
+		},
+		MultiValued: {
+			{"Name", []byte("a")}, {"k", []byte("1")}, {"k", []byte("2")}, {"k2", []byte("x")},
+		},
+		LastWriterWins: {
+			{"Name", []byte("a")}, {"k", []byte("1")}, {"k2", []byte("x")},
+		},
+	}
+	for policy, want := range tests {
+		ms := NewMemoryStore()
+		ms.Conflicts = policy
+		for _, as := range collects {
+			if err := ms.Collect(SpanID{1, 1, 0}, as...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tr, err := ms.Trace(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tr.Span.Annotations, want) {
+			t.Errorf("%v: got annotations\n%s\nwant\n%s", policy, tr.Span.Annotations, want)
+		}
+	}
+}
+
+func TestMemoryStore_Conflicts_multiValuedKeys(t *testing.T) {
+	ms := NewMemoryStore()
+	ms.Conflicts = LastWriterWins
+	collects := []Annotations{
+		{{"Span.Kind", []byte("client")}, {"_schema:HTTPClient", nil}, {"k", []byte("1")}},
+		{{"Span.Kind", []byte("server")}, {"_schema:HTTPServer", nil}, {"k", []byte("2")}},
+		{{"Span.Kind", []byte("client")}, {"_schema:HTTPClient", nil}},
+	}
+	for _, as := range collects {
+		if err := ms.Collect(SpanID{1, 1, 0}, as...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tr, err := ms.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Annotations{
+		{"Span.Kind", []byte("client")}, {"_schema:HTTPClient", nil}, {"k", []byte("2")},
+		{"Span.Kind", []byte("server")}, {"_schema:HTTPServer", nil},
+	}
+	if !reflect.DeepEqual(tr.Span.Annotations, want) {
+		t.Errorf("got annotations\n%s\nwant\n%s", tr.Span.Annotations, want)
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, p := range []ConflictPolicy{KeepAll, MultiValued, LastWriterWins} {
+		got, err := ParseConflictPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseConflictPolicy(%q): got %v, %v, want %v", p, got, err, p)
+		}
+	}
+	if _, err := ParseConflictPolicy("first-writer-wins"); err == nil {
+		t.Error("got no error for unknown policy")
+	}
+}
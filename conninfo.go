@@ -0,0 +1,230 @@
+package appdash
+
+import (
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ConnInfo describes a client connection to a CollectorServer.
+type ConnInfo struct {
+	// RemoteAddr is the client's address.
+	RemoteAddr string
+
+	// Identity is the subject common name of the client's TLS
+	// certificate, if it presented one. It is empty until the TLS
+	// handshake completes.
+	Identity string
+
+	// Labels are the annotations that the server's Enricher returned
+	// for the client, if any (e.g., its Kubernetes pod).
+	Labels map[string]string
+
+	// Connected is when the client connected.
+	Connected time.Time
+
+	// Packets is the number of packets received on the connection,
+	// and SpansPerSec is the rate at which they were received over the
+	// last ConnRateWindow. Each packet holds annotations on one span.
+	Packets     int64
+	SpansPerSec float64
+
+	// LastPacket is when the last packet was received, or zero if none
+	// was.
+	LastPacket time.Time
+
+	// LastError and LastErrorTime describe the last problem with a
+	// packet received on the connection (e.g., a malformed or
+	// oversized packet).
+	LastError     string
+	LastErrorTime time.Time
+
+	// ProtocolVersion is the version of the wire protocol that the
+	// client speaks (see the ProtocolVersion constant), as far as the
+	// server can tell from its last packet: 1 for packets without
+	// client timestamps and 2 for packets with them. It is zero until
+	// a packet is received.
+	ProtocolVersion int
+
+	// Lag is how long before it was received the last packet was sent,
+	// according to the client's timestamp (so it includes any clock
+	// skew between the client and the server). It is zero if the
+	// client does not send timestamps.
+	Lag time.Duration
+}
+
+// ConnRateWindow is the period over which ConnInfo.SpansPerSec is
+// measured.
+const ConnRateWindow = connRateSlots * time.Second
+
+// connRateSlots is the number of seconds in ConnRateWindow.
+const connRateSlots = 10
+
+// connState is the state of a client connection that is tracked for
+// ConnInfo.
+type connState struct {
+	conn      net.Conn
+	connected time.Time
+
+	mu         sync.Mutex // guards the fields below
+	identity   string
+	labels     map[string]string
+	packets    int64
+	rate       [connRateSlots]int64 // packets received in each second of the rate window, indexed by Unix second
+	rateSecond int64                // the Unix second of the most recent slot of rate
+	lastPacket time.Time
+	lastErr    string
+	lastErrAt  time.Time
+	version    int
+	lag        time.Duration
+}
+
+// trackConn starts tracking the client connection conn.
+func (cs *CollectorServer) trackConn(conn net.Conn) *connState {
+	st := &connState{conn: conn, connected: time.Now()}
+	cs.connsMu.Lock()
+	defer cs.connsMu.Unlock()
+	if cs.conns == nil {
+		cs.conns = map[*connState]struct{}{}
+	}
+	cs.conns[st] = struct{}{}
+	return st
+}
+
+// untrackConn stops tracking a client connection once it is closed.
+func (cs *CollectorServer) untrackConn(st *connState) {
+	cs.connsMu.Lock()
+	defer cs.connsMu.Unlock()
+	delete(cs.conns, st)
+}
+
+// Conns returns information about the server's active client
+// connections, in descending order of SpansPerSec, so that the clients
+// sending the most spans come first.
+func (cs *CollectorServer) Conns() []ConnInfo {
+	cs.connsMu.Lock()
+	states := make([]*connState, 0, len(cs.conns))
+	for st := range cs.conns {
+		states = append(states, st)
+	}
+	cs.connsMu.Unlock()
+
+	now := time.Now()
+	infos := make([]ConnInfo, len(states))
+	for i, st := range states {
+		infos[i] = st.info(now)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].SpansPerSec != infos[j].SpansPerSec {
+			return infos[i].SpansPerSec > infos[j].SpansPerSec
+		}
+		return infos[i].Connected.Before(infos[j].Connected)
+	})
+	return infos
+}
+
+// handshake completes the TLS handshake of st.conn, if it is a TLS
+// connection, and records the identity of the client. ConnectionState
+// blocks while a handshake is in progress, so the identity is recorded
+// here rather than looked up by Conns, which would otherwise hang on a
+// client that never sends a ClientHello.
+func (st *connState) handshake() error {
+	tc, ok := st.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+		st.mu.Lock()
+		st.identity = certs[0].Subject.CommonName
+		st.mu.Unlock()
+	}
+	return nil
+}
+
+// setLabels records the annotations that the Enricher returned for
+// the client.
+func (st *connState) setLabels(anns Annotations) {
+	if len(anns) == 0 {
+		return
+	}
+	st.mu.Lock()
+	st.labels = anns.StringMap()
+	st.mu.Unlock()
+}
+
+// packet records a packet received at now, which the client sent at
+// ts (in Unix nanoseconds), or zero if unknown.
+func (st *connState) packet(now time.Time, ts int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.advance(now)
+	st.rate[now.Unix()%int64(len(st.rate))]++
+	st.packets++
+	st.lastPacket = now
+	if ts != 0 {
+		st.version = 2
+		st.lag = now.Sub(time.Unix(0, ts))
+	} else {
+		st.version = 1
+		st.lag = 0
+	}
+}
+
+// error records a problem with a packet received on the connection.
+func (st *connState) error(now time.Time, err error) {
+	st.mu.Lock()
+	st.lastErr = err.Error()
+	st.lastErrAt = now
+	st.mu.Unlock()
+}
+
+// advance clears the slots of st.rate for the seconds that have
+// passed since the last packet. The st.mu lock must be held while
+// calling advance.
+func (st *connState) advance(now time.Time) {
+	sec := now.Unix()
+	if sec <= st.rateSecond {
+		return
+	}
+	for s := st.rateSecond + 1; s <= sec && s <= st.rateSecond+int64(len(st.rate)); s++ {
+		st.rate[s%int64(len(st.rate))] = 0
+	}
+	st.rateSecond = sec
+}
+
+func (st *connState) info(now time.Time) ConnInfo {
+	info := ConnInfo{
+		RemoteAddr: st.conn.RemoteAddr().String(),
+		Connected:  st.connected,
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.advance(now)
+	var n int64
+	for _, c := range st.rate {
+		n += c
+	}
+	window := ConnRateWindow
+	if age := now.Sub(st.connected); age < window {
+		window = age
+	}
+	if window < time.Second {
+		window = time.Second
+	}
+	info.SpansPerSec = float64(n) / window.Seconds()
+	info.Identity = st.identity
+	info.Labels = st.labels
+	info.Packets = st.packets
+	info.LastPacket = st.lastPacket
+	info.LastError = st.lastErr
+	info.LastErrorTime = st.lastErrAt
+	info.ProtocolVersion = st.version
+	info.Lag = st.lag
+	return info
+}
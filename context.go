@@ -0,0 +1,70 @@
+package appdash
+
+import "context"
+
+// A ContextCollector is a Collector that can also collect annotations
+// subject to a context's deadline and cancellation.
+type ContextCollector interface {
+	Collector
+
+	// CollectContext is like Collect, but returns ctx.Err() if ctx
+	// is done before the annotations are collected.
+	CollectContext(ctx context.Context, id SpanID, as ...Annotation) error
+}
+
+// A ContextStore is a Store whose trace lookups can be subject to a
+// context's deadline and cancellation.
+type ContextStore interface {
+	Store
+
+	// TraceContext is like Trace, but returns ctx.Err() if ctx is
+	// done before the lookup completes.
+	TraceContext(ctx context.Context, id ID) (*Trace, error)
+}
+
+// A ContextQueryer is a Queryer whose queries can be subject to a
+// context's deadline and cancellation.
+type ContextQueryer interface {
+	Queryer
+
+	// TracesContext is like Traces, but returns ctx.Err() if ctx is
+	// done before the query completes.
+	TracesContext(ctx context.Context) ([]*Trace, error)
+}
+
+// CollectContext collects the annotations using c's CollectContext method
+// if c is a ContextCollector. Otherwise, it calls c.Collect if ctx is not
+// yet done.
+func CollectContext(ctx context.Context, c Collector, id SpanID, as ...Annotation) error {
+	if cc, ok := c.(ContextCollector); ok {
+		return cc.CollectContext(ctx, id, as...)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Collect(id, as...)
+}
+
+// TraceContext looks up a trace using s's TraceContext method if s is a
+// ContextStore. Otherwise, it calls s.Trace if ctx is not yet done.
+func TraceContext(ctx context.Context, s Store, id ID) (*Trace, error) {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.TraceContext(ctx, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Trace(id)
+}
+
+// TracesContext queries traces using q's TracesContext method if q is a
+// ContextQueryer. Otherwise, it calls q.Traces if ctx is not yet done.
+func TracesContext(ctx context.Context, q Queryer) ([]*Trace, error) {
+	if cq, ok := q.(ContextQueryer); ok {
+		return cq.TracesContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return q.Traces()
+}
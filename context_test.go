@@ -0,0 +1,53 @@
+package appdash
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextHelpers_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A store that implements the context interfaces.
+	ms := NewMemoryStore()
+	// A store that does not.
+	plain := struct{ Store }{ms}
+
+	for _, s := range []Store{ms, plain} {
+		if err := CollectContext(ctx, s, SpanID{1, 2, 0}); err != context.Canceled {
+			t.Errorf("%T: CollectContext: got err %v, want context.Canceled", s, err)
+		}
+		if _, err := TraceContext(ctx, s, 1); err != context.Canceled {
+			t.Errorf("%T: TraceContext: got err %v, want context.Canceled", s, err)
+		}
+	}
+	if _, err := TracesContext(ctx, ms); err != context.Canceled {
+		t.Errorf("TracesContext: got err %v, want context.Canceled", err)
+	}
+
+	// Nothing was collected.
+	if _, err := ms.Trace(1); err != ErrTraceNotFound {
+		t.Errorf("got err %v, want ErrTraceNotFound", err)
+	}
+}
+
+func TestContextHelpers(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryStore()
+	rs := &RecentStore{MinEvictAge: 1 << 62, DeleteStore: ms}
+
+	if err := CollectContext(ctx, rs, SpanID{1, 2, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TraceContext(ctx, ms, 1); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := TracesContext(ctx, ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 1 {
+		t.Errorf("got %d traces, want 1", len(ts))
+	}
+}
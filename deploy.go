@@ -0,0 +1,43 @@
+package appdash
+
+import "time"
+
+func init() { RegisterEvent(DeployEvent{}) }
+
+// DeployEvent marks the deployment of a version of a service, so that
+// changes in latency or errors can be correlated with releases. Deploy
+// markers are recorded as their own single-span traces (see
+// RecordDeploy).
+type DeployEvent struct {
+	Service string    `trace:"Deploy.Service"`
+	Version string    `trace:"Deploy.Version"`
+	Time    time.Time `trace:"Deploy.Time"`
+}
+
+// Schema implements the Event interface.
+func (DeployEvent) Schema() string { return "Deploy" }
+
+// Important implements the ImportantEvent interface.
+func (DeployEvent) Important() []string {
+	return []string{"Deploy.Service", "Deploy.Version"}
+}
+
+// Timestamp implements the TimestampedEvent interface.
+func (e DeployEvent) Timestamp() time.Time { return e.Time }
+
+// RecordDeploy records a deploy marker for the given version of a
+// service, deployed at t, as a new trace in c. If t is zero, the current
+// time is used.
+func RecordDeploy(c Collector, service, version string, t time.Time) (SpanID, error) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	id := NewRootSpanID()
+	rec := NewRecorder(id, c)
+	rec.Name("Deploy " + service + " " + version)
+	rec.Event(DeployEvent{Service: service, Version: version, Time: t})
+	if errs := rec.Errors(); len(errs) > 0 {
+		return id, errs[0]
+	}
+	return id, nil
+}
@@ -0,0 +1,30 @@
+package appdash
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordDeploy(t *testing.T) {
+	ms := NewMemoryStore()
+	at := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	id, err := RecordDeploy(ms, "web", "v1.2.3", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := ms.Trace(id.Trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := trace.Span.Name(); name != "Deploy web v1.2.3" {
+		t.Errorf("got name %q, want %q", name, "Deploy web v1.2.3")
+	}
+	var e DeployEvent
+	if err := UnmarshalEvent(trace.Span.Annotations, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Service != "web" || e.Version != "v1.2.3" || !e.Time.Equal(at) {
+		t.Errorf("got %+v, want web v1.2.3 at %s", e, at)
+	}
+}
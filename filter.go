@@ -0,0 +1,122 @@
+package appdash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+)
+
+// A TraceFilter selects the traces that contain a span with an
+// annotation matching it.
+type TraceFilter struct {
+	// Key, if non-empty, is the key that a matching annotation must
+	// have. Otherwise, annotations with any key match.
+	Key string
+
+	// Value, if non-empty, is a substring that a matching annotation's
+	// value must contain.
+	Value string
+
+	// ValueRegexp, if non-nil, is a regular expression that a matching
+	// annotation's value must match.
+	ValueRegexp *regexp.Regexp
+
+	// MaxScan is the maximum number of spans that FilterTraces examines
+	// when the Queryer cannot filter traces itself (see FilterQueryer).
+	// If zero, DefaultMaxScan is used; if negative, there is no limit.
+	MaxScan int
+}
+
+// DefaultMaxScan is the number of spans that FilterTraces examines if a
+// TraceFilter's MaxScan is zero.
+const DefaultMaxScan = 100000
+
+// ErrScanLimit is returned by FilterTraces, along with the traces that
+// matched so far, when it examined the maximum number of spans allowed
+// by the filter (see TraceFilter.MaxScan) without finishing.
+var ErrScanLimit = errors.New("appdash: trace filter scanned too many spans")
+
+// A FilterQueryer is a Queryer that can filter traces itself, e.g.
+// using an index of annotation values.
+type FilterQueryer interface {
+	Queryer
+
+	// FilterTraces returns the traces that match the filter. It need
+	// not honor the filter's MaxScan.
+	FilterTraces(ctx context.Context, f TraceFilter) ([]*Trace, error)
+}
+
+// FilterTraces returns the traces that match the filter, using q's
+// FilterTraces method if q is a FilterQueryer. Otherwise, it examines
+// the spans of the traces returned by q (see TracesContext), up to the
+// filter's MaxScan, and returns ErrScanLimit with the traces that
+// matched so far if there were more.
+func FilterTraces(ctx context.Context, q Queryer, f TraceFilter) ([]*Trace, error) {
+	if fq, ok := q.(FilterQueryer); ok {
+		return fq.FilterTraces(ctx, f)
+	}
+	traces, err := TracesContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	budget := f.MaxScan
+	if budget == 0 {
+		budget = DefaultMaxScan
+	}
+	var matched []*Trace
+	for i, t := range traces {
+		if i%1000 == 999 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		ok, err := f.matchTrace(t, &budget)
+		if ok {
+			matched = append(matched, t)
+		}
+		if err != nil {
+			return matched, err
+		}
+	}
+	return matched, nil
+}
+
+// Match reports whether the annotation matches the filter.
+func (f TraceFilter) Match(a Annotation) bool {
+	if f.Key != "" && a.Key != f.Key {
+		return false
+	}
+	if f.Value != "" && !bytes.Contains(a.Value, []byte(f.Value)) {
+		return false
+	}
+	if f.ValueRegexp != nil && !f.ValueRegexp.Match(a.Value) {
+		return false
+	}
+	return true
+}
+
+// matchTrace reports whether any span in the trace t has an annotation
+// that matches the filter. It decrements *budget for each span it
+// examines, unless it is negative, and returns ErrScanLimit once it
+// reaches zero.
+func (f TraceFilter) matchTrace(t *Trace, budget *int) (bool, error) {
+	if *budget == 0 {
+		return false, ErrScanLimit
+	}
+	if *budget > 0 {
+		*budget--
+	}
+	for _, a := range t.Span.Annotations {
+		if f.Match(a) {
+			return true, nil
+		}
+	}
+	for _, sub := range t.Sub {
+		if ok, err := f.matchTrace(sub, budget); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
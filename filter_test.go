@@ -0,0 +1,56 @@
+package appdash
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestFilterTraces(t *testing.T) {
+	ms := NewMemoryStore()
+	for _, c := range []struct {
+		id  SpanID
+		key string
+		val string
+	}{
+		{SpanID{1, 1, 0}, "Name", "checkout"},
+		{SpanID{1, 2, 1}, "Customer", "cus_1234"},
+		{SpanID{2, 3, 0}, "Name", "cus_1234 lookup"},
+		{SpanID{3, 4, 0}, "Customer", "cus_9999"},
+	} {
+		if err := ms.Collect(c.id, Annotation{c.key, []byte(c.val)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		filter TraceFilter
+		want   []ID
+		err    error
+	}{
+		{filter: TraceFilter{Value: "cus_1234"}, want: []ID{1, 2}},
+		{filter: TraceFilter{Key: "Customer", Value: "cus_1234"}, want: []ID{1}},
+		{filter: TraceFilter{ValueRegexp: regexp.MustCompile(`^cus_\d+$`)}, want: []ID{1, 3}},
+		{filter: TraceFilter{Key: "Customer", ValueRegexp: regexp.MustCompile(`9+`)}, want: []ID{3}},
+		{filter: TraceFilter{Value: "nope"}},
+		{filter: TraceFilter{Value: "nope", MaxScan: 2}, err: ErrScanLimit},
+		{filter: TraceFilter{Value: "nope", MaxScan: -1}},
+	}
+	for _, test := range tests {
+		traces, err := FilterTraces(context.Background(), ms, test.filter)
+		if err != test.err {
+			t.Errorf("%+v: got error %v, want %v", test.filter, err, test.err)
+			continue
+		}
+		var got []ID
+		for _, tr := range traces {
+			got = append(got, tr.Span.ID.Trace)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: got traces %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package grpctrace
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+)
+
+// A Method describes a gRPC method.
+type Method struct {
+	Service      string // fully qualified service name, e.g. "helloworld.Greeter"
+	Name         string // method name, e.g. "SayHello"
+	RequestType  string // fully qualified request message type
+	ResponseType string // fully qualified response message type
+
+	ClientStreaming, ServerStreaming bool
+}
+
+// Streaming returns "client", "server", "bidi" or "" (for unary methods).
+func (m *Method) Streaming() string {
+	switch {
+	case m.ClientStreaming && m.ServerStreaming:
+		return "bidi"
+	case m.ClientStreaming:
+		return "client"
+	case m.ServerStreaming:
+		return "server"
+	}
+	return ""
+}
+
+// Descriptors holds the descriptors of gRPC services, by which a Proxy
+// describes the calls it traces. It is safe for concurrent use.
+type Descriptors struct {
+	mu       sync.Mutex
+	methods  map[string]*Method // by "service/method"
+	services map[string]bool
+}
+
+// NewDescriptors returns an empty set of descriptors.
+func NewDescriptors() *Descriptors {
+	return &Descriptors{methods: map[string]*Method{}, services: map[string]bool{}}
+}
+
+// LoadDescriptorSet reads a serialized FileDescriptorSet, such as one
+// written by protoc's --descriptor_set_out flag.
+func LoadDescriptorSet(r io.Reader) (*Descriptors, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptor.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	d := NewDescriptors()
+	for _, f := range set.File {
+		d.AddFile(f)
+	}
+	return d, nil
+}
+
+// AddFile adds the services defined in f.
+func (d *Descriptors) AddFile(f *descriptor.FileDescriptorProto) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range f.Service {
+		service := qualify(f.GetPackage(), s.GetName())
+		d.services[service] = true
+		for _, m := range s.Method {
+			d.methods[service+"/"+m.GetName()] = &Method{
+				Service:         service,
+				Name:            m.GetName(),
+				RequestType:     strings.TrimPrefix(m.GetInputType(), "."),
+				ResponseType:    strings.TrimPrefix(m.GetOutputType(), "."),
+				ClientStreaming: m.GetClientStreaming(),
+				ServerStreaming: m.GetServerStreaming(),
+			}
+		}
+	}
+}
+
+// Method returns the named method of the named service, or nil if it is
+// not known.
+func (d *Descriptors) Method(service, method string) *Method {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.methods[service+"/"+method]
+}
+
+// HasService reports whether the descriptors of the named service are
+// known.
+func (d *Descriptors) HasService(service string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.services[service]
+}
+
+// qualify returns the fully qualified name of name in the package pkg.
+func qualify(pkg, name string) string {
+	if pkg == "" {
+		return name
+	}
+	return pkg + "." + name
+}
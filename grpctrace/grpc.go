@@ -0,0 +1,87 @@
+// Package grpctrace implements tracing of gRPC calls at a proxy.
+//
+// A Proxy sits in front of a gRPC server and records a span for each call
+// that passes through it, named after the call's fully qualified service
+// and method (e.g. "helloworld.Greeter/SayHello"), with the number and
+// size of the messages sent each way and the call's gRPC status. This
+// lets calls to services that are not instrumented in-process be traced.
+//
+// The message types and streaming kind of each method are added to the
+// span if the Proxy has the service's descriptors, either loaded from a
+// descriptor set file (see LoadDescriptorSet) or fetched from the server
+// via gRPC server reflection (see Proxy.Reflect).
+//
+// The package speaks the gRPC wire protocol directly over net/http, so it
+// does not depend on the grpc-go module.
+package grpctrace
+
+import (
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func init() { appdash.RegisterEvent(CallEvent{}) }
+
+// CallEvent is a gRPC call observed by a Proxy.
+type CallEvent struct {
+	// Service is the fully qualified name of the service, such as
+	// "helloworld.Greeter".
+	Service string `trace:"GRPC.Service"`
+
+	// Method is the name of the method, such as "SayHello".
+	Method string `trace:"GRPC.Method"`
+
+	// RequestType and ResponseType are the fully qualified names of the
+	// method's message types, and Streaming is "client", "server",
+	// "bidi" or "" (unary). They are only set if the Proxy has the
+	// service's descriptors.
+	RequestType  string `trace:"GRPC.RequestType"`
+	ResponseType string `trace:"GRPC.ResponseType"`
+	Streaming    string `trace:"GRPC.Streaming"`
+
+	// RequestMessages and RequestBytes are the number and total size of
+	// the messages sent by the client (excluding gRPC framing), and
+	// ResponseMessages and ResponseBytes those sent by the server.
+	RequestMessages  int   `trace:"GRPC.RequestMessages"`
+	RequestBytes     int64 `trace:"GRPC.RequestBytes"`
+	ResponseMessages int   `trace:"GRPC.ResponseMessages"`
+	ResponseBytes    int64 `trace:"GRPC.ResponseBytes"`
+
+	// Code is the gRPC status code (0 is OK) and Message its message.
+	Code    int    `trace:"GRPC.Code"`
+	Message string `trace:"GRPC.Message"`
+
+	ProxyRecv time.Time `trace:"GRPC.ProxyRecv"`
+	ProxySend time.Time `trace:"GRPC.ProxySend"`
+}
+
+// Schema implements the appdash Event interface.
+func (CallEvent) Schema() string { return "GRPC" }
+
+// Important implements the appdash ImportantEvent interface.
+func (CallEvent) Important() []string {
+	return []string{"GRPC.Service", "GRPC.Method", "GRPC.Code"}
+}
+
+// Start implements the appdash TimespanEvent interface.
+func (e CallEvent) Start() time.Time { return e.ProxyRecv }
+
+// End implements the appdash TimespanEvent interface.
+func (e CallEvent) End() time.Time { return e.ProxySend }
+
+// splitMethod splits a gRPC request path, such as
+// "/helloworld.Greeter/SayHello", into its service and method names. It
+// returns ok == false if the path is not of that form.
+func splitMethod(path string) (service, method string, ok bool) {
+	if !strings.HasPrefix(path, "/") {
+		return "", "", false
+	}
+	i := strings.LastIndex(path, "/")
+	service, method = path[1:i], path[i+1:]
+	if service == "" || method == "" {
+		return "", "", false
+	}
+	return service, method, true
+}
@@ -0,0 +1,266 @@
+package grpctrace
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/httptrace"
+)
+
+// codeUnavailable is the gRPC status code of calls that the proxy could
+// not forward.
+const codeUnavailable = 14
+
+// DefaultReflectRetry is the default for how long a Proxy waits before
+// retrying server reflection for a service whose descriptors it could
+// not fetch.
+const DefaultReflectRetry = time.Minute
+
+// A Proxy is an HTTP/2 reverse proxy for a gRPC server that records a
+// span for each call that passes through it (see CallEvent). Requests
+// that are not gRPC calls are forwarded without being traced.
+//
+// Clients must connect to it over HTTP/2; to accept unencrypted
+// connections, enable unencrypted HTTP/2 in the http.Server's Protocols.
+type Proxy struct {
+	// Target is the URL of the gRPC server, such as
+	// "http://localhost:50051" (or "https://..." for TLS).
+	Target *url.URL
+
+	// Collector is where spans are recorded.
+	Collector appdash.Collector
+
+	// Descriptors, if set, describe the services behind the proxy. If
+	// Reflect is set and Descriptors is nil, a new set is created.
+	Descriptors *Descriptors
+
+	// Reflect is whether to fetch the descriptors of services that are
+	// not in Descriptors from the gRPC server's reflection service.
+	Reflect bool
+
+	// ReflectRetry is how long to wait before retrying server reflection
+	// for a service after it failed. If zero, DefaultReflectRetry is
+	// used.
+	ReflectRetry time.Duration
+
+	// Transport is used to forward calls. If nil, a transport that
+	// speaks HTTP/2 to Target (over TLS if Target's scheme is https, and
+	// unencrypted otherwise) is used.
+	Transport http.RoundTripper
+
+	once        sync.Once
+	rp          *httputil.ReverseProxy
+	rt          http.RoundTripper
+	mu          sync.Mutex
+	reflectedAt map[string]time.Time // last reflection attempt, by service
+}
+
+func (p *Proxy) init() {
+	p.rt = p.Transport
+	if p.rt == nil {
+		var protocols http.Protocols
+		if p.Target.Scheme == "https" {
+			protocols.SetHTTP2(true)
+		} else {
+			protocols.SetUnencryptedHTTP2(true)
+		}
+		p.rt = &http.Transport{
+			Protocols:       &protocols,
+			TLSClientConfig: &tls.Config{},
+		}
+	}
+	if p.Reflect && p.Descriptors == nil {
+		p.Descriptors = NewDescriptors()
+	}
+	p.reflectedAt = map[string]time.Time{}
+	p.rp = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(p.Target)
+			r.Out.Host = r.In.Host
+		},
+		Transport:     p.rt,
+		FlushInterval: -1, // gRPC streams must not be buffered
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("grpctrace: proxying %s: %s", r.URL.Path, err)
+			if c, ok := r.Context().Value(callKey{}).(*call); ok {
+				c.err = err
+			}
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Grpc-Status", "14")
+			w.Header().Set("Grpc-Message", url.PathEscape(err.Error()))
+			w.WriteHeader(http.StatusOK)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if c, ok := resp.Request.Context().Value(callKey{}).(*call); ok {
+				c.resp = resp
+				resp.Body = &frameCounter{ReadCloser: resp.Body, c: &c.response}
+			}
+			return nil
+		},
+	}
+}
+
+// callKey is the context key of the *call being proxied.
+type callKey struct{}
+
+// call is the state of a call being proxied.
+type call struct {
+	request, response frameCount
+	resp              *http.Response // nil if the call was not forwarded
+	err               error          // why the call was not forwarded
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.once.Do(p.init)
+
+	service, method, ok := splitMethod(r.URL.Path)
+	if !ok || !isGRPC(r.Header.Get("Content-Type")) || !appdash.Enabled() {
+		p.rp.ServeHTTP(w, r)
+		return
+	}
+
+	spanID, err := httptrace.GetSpanID(r.Header)
+	if err != nil {
+		log.Printf("grpctrace: invalid span ID header: %s", err)
+		newID := appdash.NewRootSpanID()
+		spanID = &newID
+	}
+	e := &CallEvent{Service: service, Method: method, ProxyRecv: time.Now()}
+
+	// Calls made by the server are children of this span.
+	r.Header.Del(httptrace.HeaderSpanID)
+	r.Header.Set(httptrace.HeaderParentSpanID, spanID.String())
+
+	c := &call{}
+	r = r.WithContext(context.WithValue(r.Context(), callKey{}, c))
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &frameCounter{ReadCloser: r.Body, c: &c.request}
+	}
+	p.rp.ServeHTTP(w, r)
+	e.ProxySend = time.Now()
+
+	switch {
+	case c.resp != nil:
+		e.Code, e.Message = callStatus(c.resp)
+	case c.err != nil:
+		e.Code, e.Message = codeUnavailable, c.err.Error()
+	}
+	e.RequestMessages, e.RequestBytes = c.request.load()
+	e.ResponseMessages, e.ResponseBytes = c.response.load()
+	if m := p.method(r, service, method); m != nil {
+		e.RequestType, e.ResponseType, e.Streaming = m.RequestType, m.ResponseType, m.Streaming()
+	}
+
+	rec := appdash.NewRecorder(*spanID, p.Collector)
+	rec.Name(service + "/" + method)
+	rec.Kind(appdash.SpanKindServer)
+	rec.Event(e)
+}
+
+// method returns the descriptor of the method, fetching the service's
+// descriptors via server reflection if needed, or nil if it is not
+// known.
+func (p *Proxy) method(r *http.Request, service, method string) *Method {
+	if p.Descriptors == nil {
+		return nil
+	}
+	if m := p.Descriptors.Method(service, method); m != nil || !p.Reflect || p.Descriptors.HasService(service) {
+		return m
+	}
+
+	retry := p.ReflectRetry
+	if retry == 0 {
+		retry = DefaultReflectRetry
+	}
+	p.mu.Lock()
+	last, tried := p.reflectedAt[service]
+	if tried && time.Since(last) < retry {
+		p.mu.Unlock()
+		return nil
+	}
+	p.reflectedAt[service] = time.Now()
+	p.mu.Unlock()
+
+	if err := p.Descriptors.reflect(r.Context(), p.rt, p.Target, service); err != nil {
+		log.Printf("grpctrace: fetching descriptors of %s via server reflection: %s", service, err)
+		return nil
+	}
+	return p.Descriptors.Method(service, method)
+}
+
+// isGRPC reports whether contentType is that of a gRPC call, such as
+// "application/grpc" or "application/grpc+proto".
+func isGRPC(contentType string) bool {
+	return contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// frameHeaderLen is the length of the header that precedes each gRPC
+// message: a compression flag byte and a 4-byte big-endian length.
+const frameHeaderLen = 5
+
+// frameHeader returns the header of an uncompressed message of n bytes.
+func frameHeader(n int) []byte {
+	h := make([]byte, frameHeaderLen)
+	binary.BigEndian.PutUint32(h[1:], uint32(n))
+	return h
+}
+
+// frameCount is the number and total size of the messages in a gRPC
+// stream.
+type frameCount struct {
+	messages, bytes int64 // accessed atomically
+}
+
+func (c *frameCount) load() (messages int, bytes int64) {
+	return int(atomic.LoadInt64(&c.messages)), atomic.LoadInt64(&c.bytes)
+}
+
+// frameCounter counts the gRPC messages in the stream read through it.
+type frameCounter struct {
+	io.ReadCloser
+	c *frameCount
+
+	hdr     [frameHeaderLen]byte
+	hdrN    int    // bytes of hdr read so far
+	payload uint32 // bytes of the current message not yet read
+}
+
+func (f *frameCounter) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	b := p[:n]
+	for len(b) > 0 {
+		if f.payload > 0 {
+			skip := uint32(len(b))
+			if skip > f.payload {
+				skip = f.payload
+			}
+			f.payload -= skip
+			b = b[skip:]
+			continue
+		}
+		k := copy(f.hdr[f.hdrN:], b)
+		f.hdrN += k
+		b = b[k:]
+		if f.hdrN == frameHeaderLen {
+			f.hdrN = 0
+			f.payload = binary.BigEndian.Uint32(f.hdr[1:])
+			atomic.AddInt64(&f.c.messages, 1)
+			atomic.AddInt64(&f.c.bytes, int64(f.payload))
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,180 @@
+package grpctrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// echoFile describes the test.Echo service served by newBackend.
+var echoFile = &descriptor.FileDescriptorProto{
+	Name:    proto.String("echo.proto"),
+	Package: proto.String("test"),
+	Service: []*descriptor.ServiceDescriptorProto{{
+		Name: proto.String("Echo"),
+		Method: []*descriptor.MethodDescriptorProto{{
+			Name:            proto.String("Repeat"),
+			InputType:       proto.String(".test.RepeatRequest"),
+			OutputType:      proto.String(".test.RepeatResponse"),
+			ServerStreaming: proto.Bool(true),
+		}},
+	}},
+}
+
+func h2c() *http.Protocols {
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	return &p
+}
+
+func frame(msg []byte) []byte {
+	return append(frameHeader(len(msg)), msg...)
+}
+
+// newBackend starts a gRPC server whose test.Echo/Repeat method responds
+// to each request message with two copies of it, and which supports only
+// the v1alpha server reflection service.
+func newBackend(t *testing.T) *httptest.Server {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		switch r.URL.Path {
+		case "/test.Echo/Repeat":
+			for len(body) >= frameHeaderLen {
+				n := int(binary.BigEndian.Uint32(body[1:frameHeaderLen]))
+				msg := body[frameHeaderLen : frameHeaderLen+n]
+				w.Write(frame(msg))
+				w.Write(frame(msg))
+				body = body[frameHeaderLen+n:]
+			}
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		case "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo":
+			if !bytes.Contains(body, []byte("test.Echo")) {
+				msg := "symbol not found"
+				er := append(proto.EncodeVarint(reflErrorMessage<<3|proto.WireBytes), proto.EncodeVarint(uint64(len(msg)))...)
+				er = append(er, msg...)
+				resp := append(proto.EncodeVarint(reflErrorResponse<<3|proto.WireBytes), proto.EncodeVarint(uint64(len(er)))...)
+				w.Write(frame(append(resp, er...)))
+				w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+				return
+			}
+			fd, err := proto.Marshal(echoFile)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			fdr := append(proto.EncodeVarint(reflFileDescriptorProto<<3|proto.WireBytes), proto.EncodeVarint(uint64(len(fd)))...)
+			fdr = append(fdr, fd...)
+			resp := append(proto.EncodeVarint(reflFileDescriptorResponse<<3|proto.WireBytes), proto.EncodeVarint(uint64(len(fdr)))...)
+			resp = append(resp, fdr...)
+			w.Write(frame(resp))
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		default:
+			// A trailers-only response.
+			w.Header().Set("Grpc-Status", "12")
+			w.Header().Set("Grpc-Message", "unknown method")
+		}
+	}))
+	s.Config.Protocols = h2c()
+	s.Start()
+	return s
+}
+
+func TestProxy(t *testing.T) {
+	backend := newBackend(t)
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	ms := appdash.NewMemoryStore()
+	p := &Proxy{Target: target, Collector: ms, Reflect: true}
+	front := httptest.NewUnstartedServer(p)
+	front.Config.Protocols = h2c()
+	front.Start()
+	defer front.Close()
+
+	client := &http.Client{Transport: &http.Transport{Protocols: h2c()}}
+	call := func(path string, msgs ...string) *http.Response {
+		var body bytes.Buffer
+		for _, m := range msgs {
+			body.Write(frame([]byte(m)))
+		}
+		req, _ := http.NewRequest("POST", front.URL+path, &body)
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := call("/test.Echo/Repeat", "hello", "world!")
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("got grpc-status %q through the proxy, want 0", got)
+	}
+	call("/test.Other/Method", "x")
+
+	traces, err := ms.Traces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := map[string]CallEvent{}
+	for _, tr := range traces {
+		var e CallEvent
+		if err := appdash.UnmarshalEvent(tr.Span.Annotations, &e); err != nil {
+			t.Fatal(err)
+		}
+		events[tr.Span.Name()] = e
+	}
+	if len(events) != 2 {
+		t.Fatalf("got spans %v, want 2", events)
+	}
+
+	e, ok := events["test.Echo/Repeat"]
+	if !ok {
+		t.Fatalf("no test.Echo/Repeat span in %v", events)
+	}
+	if e.Service != "test.Echo" || e.Method != "Repeat" || e.Code != 0 {
+		t.Errorf("got %+v, want a successful test.Echo/Repeat call", e)
+	}
+	if e.RequestMessages != 2 || e.RequestBytes != 11 || e.ResponseMessages != 4 || e.ResponseBytes != 22 {
+		t.Errorf("got %d/%d request and %d/%d response messages/bytes, want 2/11 and 4/22",
+			e.RequestMessages, e.RequestBytes, e.ResponseMessages, e.ResponseBytes)
+	}
+	if e.RequestType != "test.RepeatRequest" || e.ResponseType != "test.RepeatResponse" || e.Streaming != "server" {
+		t.Errorf("got types %q -> %q (%q streaming), want descriptors fetched via reflection", e.RequestType, e.ResponseType, e.Streaming)
+	}
+
+	e = events["test.Other/Method"]
+	if e.Code != codeUnimplemented || e.Message != "unknown method" || e.RequestType != "" {
+		t.Errorf("got %+v, want an unimplemented call to an unknown service", e)
+	}
+}
+
+func TestLoadDescriptorSet(t *testing.T) {
+	data, err := proto.Marshal(&descriptor.FileDescriptorSet{File: []*descriptor.FileDescriptorProto{echoFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := LoadDescriptorSet(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := d.Method("test.Echo", "Repeat")
+	if m == nil || m.RequestType != "test.RepeatRequest" || m.Streaming() != "server" {
+		t.Errorf("got method %+v, want test.Echo/Repeat", m)
+	}
+	if d.Method("test.Echo", "Missing") != nil {
+		t.Error("got a method that is not in the descriptor set")
+	}
+}
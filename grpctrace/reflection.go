@@ -0,0 +1,195 @@
+package grpctrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+)
+
+// reflectionServices are the names of the gRPC server reflection
+// services, newest first.
+var reflectionServices = []string{
+	"grpc.reflection.v1.ServerReflection",
+	"grpc.reflection.v1alpha.ServerReflection",
+}
+
+// Field numbers in the server reflection messages.
+const (
+	reflFileContainingSymbol   = 4 // ServerReflectionRequest.file_containing_symbol
+	reflFileDescriptorResponse = 4 // ServerReflectionResponse.file_descriptor_response
+	reflErrorResponse          = 7 // ServerReflectionResponse.error_response
+	reflFileDescriptorProto    = 1 // FileDescriptorResponse.file_descriptor_proto
+	reflErrorMessage           = 2 // ErrorResponse.error_message
+)
+
+// codeUnimplemented is the gRPC status code of calls to unknown methods.
+const codeUnimplemented = 12
+
+// reflect fetches the descriptors of the named service from the gRPC
+// server at target via server reflection, and adds them to d.
+func (d *Descriptors) reflect(ctx context.Context, rt http.RoundTripper, target *url.URL, service string) error {
+	var req []byte
+	req = append(req, proto.EncodeVarint(reflFileContainingSymbol<<3|proto.WireBytes)...)
+	req = append(req, proto.EncodeVarint(uint64(len(service)))...)
+	req = append(req, service...)
+
+	var err error
+	for _, rs := range reflectionServices {
+		var resp []byte
+		resp, err = unaryCall(ctx, rt, target, "/"+rs+"/ServerReflectionInfo", req)
+		if se, ok := err.(*statusError); ok && se.code == codeUnimplemented {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if e := bytesFields(resp, reflErrorResponse); len(e) > 0 {
+			msg := bytesFields(e[0], reflErrorMessage)
+			if len(msg) > 0 {
+				return fmt.Errorf("server reflection: %s", msg[0])
+			}
+			return errors.New("server reflection failed")
+		}
+		fdr := bytesFields(resp, reflFileDescriptorResponse)
+		if len(fdr) == 0 {
+			return errors.New("server reflection returned no file descriptors")
+		}
+		for _, b := range bytesFields(fdr[0], reflFileDescriptorProto) {
+			var f descriptor.FileDescriptorProto
+			if err := proto.Unmarshal(b, &f); err != nil {
+				return err
+			}
+			d.AddFile(&f)
+		}
+		return nil
+	}
+	return err
+}
+
+// statusError is a non-OK gRPC status returned by a call.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.msg)
+}
+
+// unaryCall calls the gRPC method at path on target with the serialized
+// request message req, and returns the first serialized response
+// message.
+func unaryCall(ctx context.Context, rt http.RoundTripper, target *url.URL, path string, req []byte) ([]byte, error) {
+	u := *target
+	u.Path = path
+	var body bytes.Buffer
+	body.Write(frameHeader(len(req)))
+	body.Write(req)
+	hreq, err := http.NewRequestWithContext(ctx, "POST", u.String(), &body)
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/grpc")
+	hreq.Header.Set("TE", "trailers")
+
+	resp, err := rt.RoundTrip(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if code, msg := callStatus(resp); code != 0 {
+		return nil, &statusError{code: code, msg: msg}
+	}
+	if len(data) < frameHeaderLen {
+		return nil, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint32(data[1:frameHeaderLen]))
+	if data[0] != 0 {
+		return nil, errors.New("compressed response messages are not supported")
+	}
+	if len(data)-frameHeaderLen < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data[frameHeaderLen : frameHeaderLen+n], nil
+}
+
+// callStatus returns the gRPC status of a call whose response body has
+// been read. The status is in the trailers, or in the headers if the
+// response has no messages.
+func callStatus(resp *http.Response) (code int, msg string) {
+	s, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if s == "" {
+		s, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if s == "" {
+		return codeUnknown, "no grpc-status"
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return codeUnknown, "invalid grpc-status " + s
+	}
+	if m, err := url.PathUnescape(msg); err == nil {
+		msg = m
+	}
+	return code, msg
+}
+
+// codeUnknown is the gRPC status code of calls whose status is unknown.
+const codeUnknown = 2
+
+// bytesFields returns the values of the length-delimited fields numbered
+// num in the serialized protobuf message b. Other fields are skipped; if
+// b is malformed, the fields up to the malformed one are returned.
+func bytesFields(b []byte, num int) [][]byte {
+	var vals [][]byte
+	for len(b) > 0 {
+		key, n := proto.DecodeVarint(b)
+		if n == 0 {
+			break
+		}
+		b = b[n:]
+		var v []byte
+		switch key & 7 {
+		case proto.WireVarint:
+			_, n = proto.DecodeVarint(b)
+		case proto.WireFixed64:
+			n = 8
+		case proto.WireFixed32:
+			n = 4
+		case proto.WireBytes:
+			l, ln := proto.DecodeVarint(b)
+			if ln == 0 || l > uint64(len(b)-ln) {
+				return vals
+			}
+			v = b[ln : ln+int(l)]
+			n = ln + int(l)
+		default:
+			return vals
+		}
+		if n == 0 || n > len(b) {
+			return vals
+		}
+		if int(key>>3) == num && key&7 == proto.WireBytes {
+			vals = append(vals, v)
+		}
+		b = b[n:]
+	}
+	return vals
+}
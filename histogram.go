@@ -0,0 +1,109 @@
+package appdash
+
+import (
+	"sort"
+	"time"
+)
+
+func init() { RegisterEvent(HistogramEvent{}) }
+
+// DefaultHistogramBounds are the bucket upper bounds used by NewHistogram when
+// none are given.
+var DefaultHistogramBounds = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// HistogramEvent is a bucketed distribution of the durations of many
+// homogeneous sub-operations performed during a span (for example, 10k cache
+// lookups). Recording a single HistogramEvent is much cheaper than recording
+// a child span for each sub-operation, and the web UI displays the
+// distribution inline.
+//
+// A span may have at most one HistogramEvent.
+type HistogramEvent struct {
+	// Name describes the sub-operations, e.g. "cache lookups".
+	Name string `trace:"Histogram.Name"`
+
+	// Bounds are the inclusive upper bounds of the buckets, in ascending
+	// order. Durations greater than the last bound are counted in an
+	// additional, final overflow bucket.
+	Bounds []time.Duration `trace:"Histogram.Bounds"`
+
+	// Counts are the number of observations in each bucket. It has one more
+	// element than Bounds (the overflow bucket).
+	Counts []int64 `trace:"Histogram.Counts"`
+
+	// Count is the total number of observations.
+	Count int64 `trace:"Histogram.Count"`
+
+	// Sum is the sum of all observed durations.
+	Sum time.Duration `trace:"Histogram.Sum"`
+
+	// Min and Max are the smallest and largest observed durations.
+	Min time.Duration `trace:"Histogram.Min"`
+	Max time.Duration `trace:"Histogram.Max"`
+}
+
+// NewHistogram returns a new, empty HistogramEvent with the given bucket
+// upper bounds. If no bounds are given, DefaultHistogramBounds is used.
+func NewHistogram(name string, bounds ...time.Duration) *HistogramEvent {
+	if len(bounds) == 0 {
+		bounds = DefaultHistogramBounds
+	}
+	b := make([]time.Duration, len(bounds))
+	copy(b, bounds)
+	sort.Sort(durationsAsc(b))
+	return &HistogramEvent{
+		Name:   name,
+		Bounds: b,
+		Counts: make([]int64, len(b)+1),
+	}
+}
+
+// Observe adds the duration d of a single sub-operation to the histogram.
+// It is not safe for concurrent use.
+func (h *HistogramEvent) Observe(d time.Duration) {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		counts := make([]int64, len(h.Bounds)+1)
+		copy(counts, h.Counts)
+		h.Counts = counts
+	}
+	i := sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] })
+	h.Counts[i]++
+	if h.Count == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+	h.Count++
+	h.Sum += d
+}
+
+// Mean returns the mean observed duration.
+func (h HistogramEvent) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Schema returns the constant "histogram".
+func (HistogramEvent) Schema() string { return "histogram" }
+
+// Important implements the ImportantEvent interface.
+func (HistogramEvent) Important() []string { return []string{"Histogram.Name", "Histogram.Count"} }
+
+type durationsAsc []time.Duration
+
+func (d durationsAsc) Len() int           { return len(d) }
+func (d durationsAsc) Less(i, j int) bool { return d[i] < d[j] }
+func (d durationsAsc) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
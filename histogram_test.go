@@ -0,0 +1,54 @@
+package appdash
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogramEvent_Observe(t *testing.T) {
+	h := NewHistogram("lookups", 10*time.Millisecond, time.Millisecond)
+	for _, d := range []time.Duration{
+		500 * time.Microsecond,
+		time.Millisecond,
+		2 * time.Millisecond,
+		20 * time.Millisecond,
+	} {
+		h.Observe(d)
+	}
+
+	want := &HistogramEvent{
+		Name:   "lookups",
+		Bounds: []time.Duration{time.Millisecond, 10 * time.Millisecond},
+		Counts: []int64{2, 1, 1},
+		Count:  4,
+		Sum:    23500 * time.Microsecond,
+		Min:    500 * time.Microsecond,
+		Max:    20 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("got histogram %+v, want %+v", h, want)
+	}
+	if want := 5875 * time.Microsecond; h.Mean() != want {
+		t.Errorf("got mean %s, want %s", h.Mean(), want)
+	}
+}
+
+func TestHistogramEvent_marshalRoundTrip(t *testing.T) {
+	h := NewHistogram("lookups")
+	for i := 0; i < 100; i++ {
+		h.Observe(time.Duration(i) * 250 * time.Microsecond)
+	}
+
+	anns, err := MarshalEvent(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h2 HistogramEvent
+	if err := UnmarshalEvent(anns, &h2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&h2, h) {
+		t.Errorf("got unmarshaled histogram %+v, want %+v", h2, *h)
+	}
+}
@@ -0,0 +1,112 @@
+package httptrace
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Response classes, recorded in the Class field of ServerEvent and
+// ClientEvent.
+const (
+	// ClassSuccess is the class of requests that succeeded.
+	ClassSuccess = "success"
+
+	// ClassFailure is the class of requests that failed.
+	ClassFailure = "failure"
+
+	// ClassIgnore is the class of requests that should count as
+	// neither successes nor failures (e.g., health check probes). The
+	// metrics package leaves them out of its aggregates.
+	ClassIgnore = "ignore"
+)
+
+// ClassifyRule maps HTTP responses (or client request errors) that
+// match all of its non-zero criteria to a class.
+type ClassifyRule struct {
+	// Method, if non-empty, is the HTTP method that requests must
+	// have.
+	Method string
+
+	// PathPrefix, if non-empty, is the prefix that request URL paths
+	// must have.
+	PathPrefix string
+
+	// StatusCodes, if non-empty, are the response status codes that
+	// match.
+	StatusCodes []int
+
+	// MinStatus and MaxStatus, if non-zero, are the inclusive bounds
+	// of the response status codes that match.
+	MinStatus, MaxStatus int
+
+	// Error, if non-nil, makes the rule match only client requests
+	// that failed with an error for which Error returns true. Rules
+	// with a nil Error never match failed requests.
+	Error func(error) bool
+
+	// Class is the class of matching responses (ClassSuccess,
+	// ClassFailure or ClassIgnore).
+	Class string
+}
+
+func (c *ClassifyRule) match(r *http.Request, statusCode int, err error) bool {
+	if c.Method != "" && !strings.EqualFold(c.Method, r.Method) {
+		return false
+	}
+	if c.PathPrefix != "" && (r.URL == nil || !strings.HasPrefix(r.URL.Path, c.PathPrefix)) {
+		return false
+	}
+	if (err != nil) != (c.Error != nil) {
+		return false
+	}
+	if err != nil {
+		return c.Error(err)
+	}
+	if len(c.StatusCodes) > 0 {
+		found := false
+		for _, code := range c.StatusCodes {
+			if code == statusCode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if c.MinStatus != 0 && statusCode < c.MinStatus {
+		return false
+	}
+	if c.MaxStatus != 0 && statusCode > c.MaxStatus {
+		return false
+	}
+	return true
+}
+
+// Classifier classifies HTTP responses as successes, failures or
+// ignored. The first rule that matches a response determines its
+// class. If no rule matches, a response is a failure if the request
+// failed entirely or the status code is 5xx, and a success otherwise.
+//
+// For example, the following Classifier ignores 404s for a health
+// check path and treats 429s as failures:
+//
+//	httptrace.Classifier{
+//		{PathPrefix: "/healthz", StatusCodes: []int{404}, Class: httptrace.ClassIgnore},
+//		{StatusCodes: []int{429}, Class: httptrace.ClassFailure},
+//	}
+type Classifier []ClassifyRule
+
+// Classify returns the class of the response to r with the given
+// status code, or of the error err if the request failed entirely.
+func (c Classifier) Classify(r *http.Request, statusCode int, err error) string {
+	for i := range c {
+		if c[i].match(r, statusCode, err) {
+			return c[i].Class
+		}
+	}
+	if err != nil || statusCode >= 500 {
+		return ClassFailure
+	}
+	return ClassSuccess
+}
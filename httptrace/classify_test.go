@@ -0,0 +1,46 @@
+package httptrace
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestClassifier_Classify(t *testing.T) {
+	c := Classifier{
+		{PathPrefix: "/healthz", StatusCodes: []int{404}, Class: ClassIgnore},
+		{Method: "POST", MinStatus: 400, MaxStatus: 499, Class: ClassFailure},
+		{StatusCodes: []int{503}, Class: ClassIgnore},
+		{Error: func(err error) bool { _, ok := err.(net.Error); return ok }, Class: ClassIgnore},
+	}
+
+	get, _ := http.NewRequest("GET", "http://example.com/healthz/db", nil)
+	post, _ := http.NewRequest("POST", "http://example.com/foo", nil)
+
+	tests := []struct {
+		r          *http.Request
+		statusCode int
+		err        error
+		want       string
+	}{
+		{get, 200, nil, ClassSuccess},
+		{get, 404, nil, ClassIgnore},
+		{get, 500, nil, ClassFailure},
+		{get, 503, nil, ClassIgnore},
+		{post, 404, nil, ClassFailure},
+		{post, 302, nil, ClassSuccess},
+		{get, -1, &net.OpError{Op: "dial", Err: errors.New("refused")}, ClassIgnore},
+		{get, -1, errors.New("other"), ClassFailure},
+	}
+	for _, test := range tests {
+		got := c.Classify(test.r, test.statusCode, test.err)
+		if got != test.want {
+			t.Errorf("%s %s status %d err %v: got class %q, want %q", test.r.Method, test.r.URL.Path, test.statusCode, test.err, got, test.want)
+		}
+	}
+
+	if got := Classifier(nil).Classify(get, 404, nil); got != ClassSuccess {
+		t.Errorf("nil Classifier: got class %q for 404, want %q", got, ClassSuccess)
+	}
+}
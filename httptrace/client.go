@@ -1,8 +1,11 @@
 package httptrace
 
 import (
+	"io"
 	"net/http"
-	"strings"
+	nethttptrace "net/http/httptrace"
+	"strconv"
+	"sync"
 	"time"
 
 	"sourcegraph.com/sourcegraph/appdash"
@@ -11,7 +14,7 @@ import (
 var (
 	// RedactedHeaders is a slice of header names whose values should be
 	// entirely redacted from logs.
-	RedactedHeaders = []string{"Authorization"}
+	RedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
 )
 
 func init() { appdash.RegisterEvent(ClientEvent{}) }
@@ -21,7 +24,7 @@ func init() { appdash.RegisterEvent(ClientEvent{}) }
 // the response status, size, and the ClientSend/ClientRecv times set
 // before being logged.
 func NewClientEvent(r *http.Request) *ClientEvent {
-	return &ClientEvent{Request: requestInfo(r)}
+	return &ClientEvent{Request: requestInfo(r, nil)}
 }
 
 // RequestInfo describes an HTTP request.
@@ -35,12 +38,12 @@ type RequestInfo struct {
 	ContentLength int64
 }
 
-func requestInfo(r *http.Request) RequestInfo {
+func requestInfo(r *http.Request, f *Filter) RequestInfo {
 	return RequestInfo{
 		Method:        r.Method,
-		URI:           r.URL.RequestURI(),
+		URI:           f.requestURI(r.URL),
 		Proto:         r.Proto,
-		Headers:       redactHeaders(r.Header, r.Trailer),
+		Headers:       f.headers(r.Header, r.Trailer),
 		Host:          r.Host,
 		RemoteAddr:    r.RemoteAddr,
 		ContentLength: r.ContentLength,
@@ -48,11 +51,19 @@ func requestInfo(r *http.Request) RequestInfo {
 }
 
 // ClientEvent records an HTTP client request event.
+//
+// ClientRecv is the time that the response headers were received.
+// For streaming (e.g., chunked) responses, the time to first byte
+// and the time that the response body was completely read are
+// recorded separately in ClientFirstByte and ClientBodyRecv.
 type ClientEvent struct {
-	Request    RequestInfo  `trace:"Client.Request"`
-	Response   ResponseInfo `trace:"Client.Response"`
-	ClientSend time.Time    `trace:"Client.Send"`
-	ClientRecv time.Time    `trace:"Client.Recv"`
+	Request         RequestInfo  `trace:"Client.Request"`
+	Response        ResponseInfo `trace:"Client.Response"`
+	Class           string       `trace:"Client.Class"` // see Classifier
+	ClientSend      time.Time    `trace:"Client.Send"`
+	ClientFirstByte time.Time    `trace:"Client.FirstByte"` // zero if unknown
+	ClientRecv      time.Time    `trace:"Client.Recv"`
+	ClientBodyRecv  time.Time    `trace:"Client.BodyRecv"` // zero if there is no body
 }
 
 // Schema returns the constant "HTTPClient".
@@ -64,49 +75,20 @@ func (ClientEvent) Important() []string {
 		"Client.Request.Headers.If-Modified-Since",
 		"Client.Request.Headers.If-None-Match",
 		"Client.Response.StatusCode",
+		"Client.Class",
 	}
 }
 
 // Start implements the appdash TimespanEvent interface.
 func (e ClientEvent) Start() time.Time { return e.ClientSend }
 
-// End implements the appdash TimespanEvent interface.
-func (e ClientEvent) End() time.Time { return e.ClientRecv }
-
-var (
-	redacted = []string{"REDACTED"}
-)
-
-func redactHeaders(header, trailer http.Header) map[string]string {
-	h := make(http.Header, len(header)+len(trailer))
-	for k, v := range header {
-		if isRedacted(k) {
-			h[k] = redacted
-		} else {
-			h[k] = v
-		}
-	}
-	for k, v := range trailer {
-		if isRedacted(k) {
-			h[k] = redacted
-		} else {
-			h[k] = append(h[k], v...)
-		}
+// End implements the appdash TimespanEvent interface. It is the time
+// the response body was completely read, if known.
+func (e ClientEvent) End() time.Time {
+	if !e.ClientBodyRecv.IsZero() {
+		return e.ClientBodyRecv
 	}
-	m := make(map[string]string, len(h))
-	for k, v := range h {
-		m[http.CanonicalHeaderKey(k)] = strings.Join(v, ",")
-	}
-	return m
-}
-
-func isRedacted(name string) bool {
-	for _, v := range RedactedHeaders {
-		if strings.EqualFold(name, v) {
-			return true
-		}
-	}
-	return false
+	return e.ClientRecv
 }
 
 // Transport is an HTTP transport that adds appdash span ID headers
@@ -122,9 +104,26 @@ type Transport struct {
 	Transport http.RoundTripper
 
 	SetName bool
+
+	// Classifier classifies responses as successes, failures or
+	// ignored. If nil, failed requests and 5xx responses are failures
+	// and all others are successes.
+	Classifier Classifier
+
+	// Filter controls which request and response headers and query
+	// parameters are recorded. If nil, the defaults described in the
+	// Filter documentation are used.
+	Filter *Filter
 }
 
 // RoundTrip implements the RoundTripper interface.
+//
+// The event for the request is recorded once the response headers are
+// received. If the response has a body, the time that it was
+// completely read (ClientBodyRecv) and, if it was not known in
+// advance, its length are added to the span once the body has been
+// read to EOF or closed (whichever happens first). Callers that never
+// do either still get the event, without the body timing.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	var transport http.RoundTripper
 	if t.Transport != nil {
@@ -132,6 +131,9 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	} else {
 		transport = http.DefaultTransport
 	}
+	if !t.Recorder.Enabled() {
+		return transport.RoundTrip(req)
+	}
 
 	// To set extra querystring params, we must make a copy of the Request so
 	// that we don't modify the Request we were given. This is required by the
@@ -144,21 +146,74 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	SetSpanIDHeader(req.Header, child.SpanID)
 
-	e := NewClientEvent(req)
+	e := &ClientEvent{Request: requestInfo(req, t.Filter)}
+	req = req.WithContext(nethttptrace.WithClientTrace(req.Context(), &nethttptrace.ClientTrace{
+		GotFirstResponseByte: func() { e.ClientFirstByte = time.Now() },
+	}))
 	e.ClientSend = time.Now()
+	record := func() {
+		child.Kind(appdash.SpanKindClient)
+		child.Event(e)
+	}
 
 	// Make the HTTP request.
 	resp, err := transport.RoundTrip(req)
 
 	e.ClientRecv = time.Now()
-	if err == nil {
-		e.Response = responseInfo(resp)
-	} else {
+	if err != nil {
 		e.Response.StatusCode = -1
+		e.Class = t.Classifier.Classify(req, -1, err)
+		record()
+		return resp, err
 	}
-	child.Event(e)
+	e.Response = responseInfo(resp, t.Filter)
+	e.Class = t.Classifier.Classify(req, resp.StatusCode, nil)
+	record()
+	if resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		// The body of a 101 response is the connection, which must
+		// stay writable (an io.ReadWriteCloser) for the caller to use
+		// the new protocol, and is never read to EOF.
+		return resp, nil
+	}
+	unknownLength := e.Response.ContentLength < 0
+	resp.Body = &bodyRecorder{ReadCloser: resp.Body, done: func(n int64) {
+		// These annotations follow the event's, so they supersede the
+		// zero values recorded for the same keys (see
+		// Annotations.StringMap).
+		anns := appdash.Annotations{{Key: "Client.BodyRecv", Value: []byte(time.Now().Format(time.RFC3339Nano))}}
+		if unknownLength {
+			// chunked or otherwise unknown length
+			anns = append(anns, appdash.Annotation{Key: "Client.Response.ContentLength", Value: []byte(strconv.FormatInt(n, 10))})
+		}
+		child.Annotation(anns...)
+	}}
+	return resp, nil
+}
+
+// bodyRecorder is an HTTP response body that calls done, with the
+// number of bytes read, the first time that it is read to EOF or
+// closed.
+type bodyRecorder struct {
+	io.ReadCloser
+	done func(n int64)
+
+	n    int64
+	once sync.Once
+}
+
+func (b *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.n) })
+	}
+	return n, err
+}
 
-	return resp, err
+func (b *bodyRecorder) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
 }
 
 // cloneRequest returns a clone of the provided *http.Request. The clone is a
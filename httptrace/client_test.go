@@ -1,9 +1,11 @@
 package httptrace
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -113,6 +115,7 @@ func TestTransport(t *testing.T) {
 			ContentLength: 123,
 			Headers:       map[string]string{"X-Resp-Header": "b"},
 		},
+		Class: ClassSuccess,
 	}
 	delete(e.Request.Headers, "Span-Id")
 	e.ClientSend = time.Time{}
@@ -122,6 +125,67 @@ func TestTransport(t *testing.T) {
 	}
 }
 
+func TestTransport_bodyRecv(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	rec := appdash.NewRecorder(appdash.SpanID{1, 2, 3}, appdash.NewLocalCollector(ms))
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	mt := &mockTransport{
+		resp: &http.Response{
+			StatusCode:    200,
+			ContentLength: -1,
+			Body:          ioutil.NopCloser(strings.NewReader("hello")),
+		},
+	}
+	transport := &Transport{
+		Recorder:  rec,
+		Transport: mt,
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The event is recorded before the body is read, without the body
+	// timing, so that callers that never close the body still get it.
+	trace, err := ms.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e ClientEvent
+	if err := appdash.UnmarshalEvent(trace.Span.Annotations, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ClientRecv.IsZero() || !e.ClientBodyRecv.IsZero() {
+		t.Errorf("got ClientRecv %v and ClientBodyRecv %v before reading body, want only ClientRecv", e.ClientRecv, e.ClientBodyRecv)
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	trace, err = ms.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = ClientEvent{}
+	if err := appdash.UnmarshalEvent(trace.Span.Annotations, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Response.ContentLength != 5 {
+		t.Errorf("got ContentLength %d, want 5", e.Response.ContentLength)
+	}
+	if !e.ClientBodyRecv.After(e.ClientRecv) {
+		t.Errorf("got ClientBodyRecv %v, want after ClientRecv %v", e.ClientBodyRecv, e.ClientRecv)
+	}
+	if e.End() != e.ClientBodyRecv {
+		t.Errorf("got End %v, want ClientBodyRecv %v", e.End(), e.ClientBodyRecv)
+	}
+}
+
 type mockTransport struct {
 	req  *http.Request
 	resp *http.Response
@@ -88,6 +88,15 @@
 //      tracemw(w, r, appHandler)
 //  })
 //
+// Reverse Proxies
+//
+// Proxies and gateways built on httputil.ReverseProxy can be traced hop by
+// hop by wrapping the proxy, which records a server span for each incoming
+// request and a child client span for the upstream request:
+//
+//  proxy := httputil.NewSingleHostReverseProxy(upstreamURL)
+//  http.Handle("/", httptrace.ReverseProxy(collector, proxy, &httptrace.MiddlewareConfig{}))
+//
 // Other details such as outbound client requests, displaying the trace ID in
 // the webpage e.g. to let users give you their trace ID for troubleshooting,
 // and much more are covered in the example application provided at
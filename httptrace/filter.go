@@ -0,0 +1,190 @@
+package httptrace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A Filter controls which HTTP request and response headers and URL
+// query parameters are recorded in events, and how.
+//
+// Denied values are recorded as "REDACTED", hashed values are
+// recorded as "sha256:" followed by the hex-encoded SHA-256 hash of
+// HashSalt and the value (so that requests can be correlated by
+// value without recording the value itself), and values that are not
+// allowed are omitted. A name that is both allowed (or hashed) and
+// denied is denied.
+//
+// The headers in RedactedHeaders and the query parameters in
+// RedactedQueryParams are always denied, in addition to DenyHeaders
+// and DenyQuery, unless RecordSensitive is set. A nil *Filter denies
+// them and records everything else.
+type Filter struct {
+	// AllowHeaders, if non-empty, are the only headers that are
+	// recorded (in addition to HashHeaders).
+	AllowHeaders []string
+
+	// DenyHeaders are the headers whose values are redacted, in
+	// addition to RedactedHeaders.
+	DenyHeaders []string
+
+	// HashHeaders are the headers whose values are hashed.
+	HashHeaders []string
+
+	// AllowQuery, if non-empty, are the only query parameters that
+	// are recorded (in addition to HashQuery).
+	AllowQuery []string
+
+	// DenyQuery are the query parameters whose values are redacted,
+	// in addition to RedactedQueryParams.
+	DenyQuery []string
+
+	// HashQuery are the query parameters whose values are hashed.
+	HashQuery []string
+
+	// HashSalt is prepended to values before they are hashed. It
+	// should be kept secret if the hashed values are guessable.
+	HashSalt string
+
+	// RecordSensitive, if true, stops the headers in RedactedHeaders
+	// and the query parameters in RedactedQueryParams from being
+	// denied by default, so that they are recorded (or hashed) like
+	// any other unless they are listed in DenyHeaders or DenyQuery.
+	RecordSensitive bool
+}
+
+// RedactedQueryParams is a slice of URL query parameter names whose
+// values should be entirely redacted from logs.
+var RedactedQueryParams = []string{"access_token", "password"}
+
+// filterAction is what a Filter does with a header or query
+// parameter.
+type filterAction int
+
+const (
+	filterRecord filterAction = iota
+	filterRedact
+	filterHash
+	filterOmit
+)
+
+func (f *Filter) headerAction(name string) filterAction {
+	allow, deny, hash := []string(nil), RedactedHeaders, []string(nil)
+	if f != nil {
+		allow, hash = f.AllowHeaders, f.HashHeaders
+		deny = f.deny(RedactedHeaders, f.DenyHeaders)
+	}
+	return action(name, strings.EqualFold, allow, deny, hash)
+}
+
+func (f *Filter) queryAction(name string) filterAction {
+	allow, deny, hash := []string(nil), RedactedQueryParams, []string(nil)
+	if f != nil {
+		allow, hash = f.AllowQuery, f.HashQuery
+		deny = f.deny(RedactedQueryParams, f.DenyQuery)
+	}
+	return action(name, func(a, b string) bool { return a == b }, allow, deny, hash)
+}
+
+// deny returns the names that f denies, given the names denied by
+// default and those that f lists.
+func (f *Filter) deny(defaults, names []string) []string {
+	if f.RecordSensitive {
+		return names
+	}
+	if len(names) == 0 {
+		return defaults
+	}
+	return append(append(make([]string, 0, len(defaults)+len(names)), defaults...), names...)
+}
+
+func action(name string, eq func(a, b string) bool, allow, deny, hash []string) filterAction {
+	contains := func(names []string) bool {
+		for _, n := range names {
+			if eq(n, name) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case len(allow) > 0 && !contains(allow) && !contains(hash):
+		return filterOmit
+	case contains(deny):
+		return filterRedact
+	case contains(hash):
+		return filterHash
+	}
+	return filterRecord
+}
+
+func (f *Filter) hash(vs []string) []string {
+	var salt string
+	if f != nil {
+		salt = f.HashSalt
+	}
+	hashed := make([]string, len(vs))
+	for i, v := range vs {
+		sum := sha256.Sum256([]byte(salt + v))
+		hashed[i] = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return hashed
+}
+
+var (
+	redacted = []string{"REDACTED"}
+)
+
+// headers returns the filtered headers and trailers, keyed by
+// canonical header name.
+func (f *Filter) headers(header, trailer http.Header) map[string]string {
+	h := make(http.Header, len(header)+len(trailer))
+	for _, hh := range []http.Header{header, trailer} {
+		for k, v := range hh {
+			switch f.headerAction(k) {
+			case filterRedact:
+				h[k] = redacted
+			case filterHash:
+				h[k] = append(h[k], f.hash(v)...)
+			case filterRecord:
+				h[k] = append(h[k], v...)
+			}
+		}
+	}
+	m := make(map[string]string, len(h))
+	for k, v := range h {
+		m[http.CanonicalHeaderKey(k)] = strings.Join(v, ",")
+	}
+	return m
+}
+
+// requestURI returns the filtered request URI of u.
+func (f *Filter) requestURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	q := u.Query()
+	changed := false
+	for k, v := range q {
+		switch f.queryAction(k) {
+		case filterRedact:
+			q[k] = redacted
+		case filterHash:
+			q[k] = f.hash(v)
+		case filterOmit:
+			delete(q, k)
+		default:
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return u.RequestURI() // preserve the original parameter order
+	}
+	u2 := *u
+	u2.RawQuery = q.Encode()
+	return u2.RequestURI()
+}
@@ -0,0 +1,107 @@
+package httptrace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFilter_headers(t *testing.T) {
+	header := http.Header{
+		"Authorization": []string{"Basic seeecret"},
+		"Cookie":        []string{"a=b"},
+		"Accept":        []string{"application/json"},
+		"X-Request-Id":  []string{"123"},
+		"X-Other":       []string{"x"},
+	}
+	trailer := http.Header{"X-Request-Id": []string{"456"}}
+
+	tests := []struct {
+		f    *Filter
+		want map[string]string
+	}{
+		{
+			f: nil,
+			want: map[string]string{
+				"Authorization": "REDACTED",
+				"Cookie":        "REDACTED",
+				"Accept":        "application/json",
+				"X-Request-Id":  "123,456",
+				"X-Other":       "x",
+			},
+		},
+		{
+			f: &Filter{
+				AllowHeaders: []string{"accept", "Authorization"},
+				HashHeaders:  []string{"X-Request-ID"},
+				HashSalt:     "s",
+			},
+			want: map[string]string{
+				"Authorization": "REDACTED",
+				"Accept":        "application/json",
+				"X-Request-Id":  hashOf("s", "123") + "," + hashOf("s", "456"),
+			},
+		},
+		{
+			f: &Filter{DenyHeaders: []string{"X-Other"}},
+			want: map[string]string{
+				"Authorization": "REDACTED",
+				"Cookie":        "REDACTED",
+				"Accept":        "application/json",
+				"X-Request-Id":  "123,456",
+				"X-Other":       "REDACTED",
+			},
+		},
+		{
+			f: &Filter{RecordSensitive: true, HashHeaders: []string{"Cookie"}},
+			want: map[string]string{
+				"Authorization": "Basic seeecret",
+				"Cookie":        hashOf("", "a=b"),
+				"Accept":        "application/json",
+				"X-Request-Id":  "123,456",
+				"X-Other":       "x",
+			},
+		},
+	}
+
+	for i, test := range tests {
+		got := test.f.headers(header, trailer)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("#%d: got headers %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestFilter_requestURI(t *testing.T) {
+	tests := []struct {
+		f    *Filter
+		uri  string
+		want string
+	}{
+		{nil, "/foo", "/foo"},
+		{nil, "/foo?b=1&a=2", "/foo?b=1&a=2"},
+		{nil, "/foo?access_token=x&a=2", "/foo?a=2&access_token=REDACTED"},
+		{&Filter{AllowQuery: []string{"a"}}, "/foo?a=1&b=2", "/foo?a=1"},
+		{&Filter{DenyQuery: []string{"b"}}, "/foo?access_token=x&b=2", "/foo?access_token=REDACTED&b=REDACTED"},
+		{&Filter{DenyQuery: []string{"b"}, RecordSensitive: true}, "/foo?access_token=x&b=2", "/foo?access_token=x&b=REDACTED"},
+		{&Filter{HashQuery: []string{"password"}}, "/foo?password=x", "/foo?password=REDACTED"},
+		{&Filter{HashQuery: []string{"id"}}, "/foo?id=7", "/foo?id=" + url.QueryEscape(hashOf("", "7"))},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := test.f.requestURI(u); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.uri, got, test.want)
+		}
+	}
+}
+
+func hashOf(salt, v string) string {
+	sum := sha256.Sum256([]byte(salt + v))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package httptrace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// ReverseProxy returns a handler that serves requests with the reverse
+// proxy p, tracing each hop: it records the incoming request as a server
+// span (as Middleware does, configured by conf) and the request that p
+// makes upstream as a child client span (as Transport does), whose span ID
+// is passed to the upstream server in the Span-ID header.
+//
+// p is not modified; its Transport (or http.DefaultTransport, if nil) is
+// used to make the upstream requests.
+func ReverseProxy(c appdash.Collector, p *httputil.ReverseProxy, conf *MiddlewareConfig) http.Handler {
+	tp := *p
+	tp.Transport = &proxyTransport{c: c, conf: conf, transport: p.Transport}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		serve(c, conf, rw, r, func(rw http.ResponseWriter, r *http.Request, spanID appdash.SpanID) {
+			ctx := context.WithValue(r.Context(), proxySpanKey{}, spanID)
+			tp.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	})
+}
+
+// proxySpanKey is the context key of the span ID of a request being
+// served by a ReverseProxy handler.
+type proxySpanKey struct{}
+
+// proxyTransport records the upstream requests of a ReverseProxy handler
+// as children of the span of the request being served.
+type proxyTransport struct {
+	c         appdash.Collector
+	conf      *MiddlewareConfig
+	transport http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	spanID, ok := req.Context().Value(proxySpanKey{}).(appdash.SpanID)
+	if !ok || !appdash.Enabled() {
+		transport := t.transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		return transport.RoundTrip(req)
+	}
+
+	// The incoming request's Parent-Span-ID, if any, refers to the
+	// proxy's parent, not to the upstream server's.
+	if req.Header.Get(HeaderParentSpanID) != "" {
+		req = cloneRequest(req)
+		req.Header.Del(HeaderParentSpanID)
+	}
+
+	ct := &Transport{
+		Recorder:   appdash.NewRecorder(spanID, t.c),
+		Transport:  t.transport,
+		SetName:    true,
+		Classifier: t.conf.Classifier,
+		Filter:     t.conf.Filter,
+	}
+	return ct.RoundTrip(req)
+}
@@ -0,0 +1,157 @@
+package httptrace
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestReverseProxy(t *testing.T) {
+	var upstreamSpan string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamSpan = r.Header.Get(HeaderSpanID)
+		if r.Header.Get(HeaderParentSpanID) != "" {
+			t.Errorf("upstream got a %s header", HeaderParentSpanID)
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	ms := appdash.NewMemoryStore()
+	proxy := httptest.NewServer(ReverseProxy(ms, httputil.NewSingleHostReverseProxy(u), &MiddlewareConfig{}))
+	defer proxy.Close()
+
+	parent := appdash.NewRootSpanID()
+	req, _ := http.NewRequest("GET", proxy.URL+"/foo", nil)
+	req.Header.Set(HeaderParentSpanID, parent.String())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("got body %q, want hello", body)
+	}
+
+	// The proxy's span is a child of the caller's, and the upstream
+	// request's span is a child of the proxy's.
+	clientSpan, err := appdash.ParseSpanID(upstreamSpan)
+	if err != nil {
+		t.Fatalf("upstream got %s header %q: %s", HeaderSpanID, upstreamSpan, err)
+	}
+	if clientSpan.Trace != parent.Trace {
+		t.Errorf("got upstream span %v, want it in trace %v", clientSpan, parent.Trace)
+	}
+	serverSpan := appdash.SpanID{Trace: parent.Trace, Span: clientSpan.Parent, Parent: parent.Span}
+
+	traces, err := ms.Traces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := map[appdash.SpanID]*appdash.Span{}
+	var walk func(*appdash.Trace)
+	walk = func(tr *appdash.Trace) {
+		spans[tr.Span.ID] = &tr.Span
+		for _, sub := range tr.Sub {
+			walk(sub)
+		}
+	}
+	for _, tr := range traces {
+		walk(tr)
+	}
+
+	s, ok := spans[serverSpan]
+	if !ok {
+		t.Fatalf("no server span %v in %v", serverSpan, spans)
+	}
+	var se ServerEvent
+	if err := appdash.UnmarshalEvent(s.Annotations, &se); err != nil {
+		t.Fatal(err)
+	}
+	if se.Request.URI != "/foo" || se.Response.StatusCode != http.StatusOK {
+		t.Errorf("got server event %+v, want a 200 response to /foo", se)
+	}
+
+	s, ok = spans[*clientSpan]
+	if !ok {
+		t.Fatalf("no client span %v in %v", clientSpan, spans)
+	}
+	var ce ClientEvent
+	if err := appdash.UnmarshalEvent(s.Annotations, &ce); err != nil {
+		t.Fatal(err)
+	}
+	if ce.Response.StatusCode != http.StatusOK {
+		t.Errorf("got client event %+v, want a 200 response", ce)
+	}
+	if name := s.Name(); name != u.Host {
+		t.Errorf("got client span name %q, want %q", name, u.Host)
+	}
+}
+
+func TestReverseProxy_upgrade(t *testing.T) {
+	// The upstream server switches to a protocol that echoes what the
+	// client sends.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "want Upgrade: echo", http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	ms := appdash.NewMemoryStore()
+	proxy := httptest.NewServer(ReverseProxy(ms, httputil.NewSingleHostReverseProxy(u), &MiddlewareConfig{}))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req, _ := http.NewRequest("GET", proxy.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("got status %d (%q), want %d", resp.StatusCode, body, http.StatusSwitchingProtocols)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("got %q through the upgraded connection, want ping", buf)
+	}
+}
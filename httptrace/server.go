@@ -1,7 +1,9 @@
 package httptrace
 
 import (
+	"bufio"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -20,7 +22,7 @@ func init() { appdash.RegisterEvent(ServerEvent{}) }
 // The returned value is incomplete and should have its Response and
 // ServerRecv/ServerSend values set before being logged.
 func NewServerEvent(r *http.Request) *ServerEvent {
-	return &ServerEvent{Request: requestInfo(r)}
+	return &ServerEvent{Request: requestInfo(r, nil)}
 }
 
 // ResponseInfo describes an HTTP response.
@@ -30,22 +32,31 @@ type ResponseInfo struct {
 	StatusCode    int
 }
 
-func responseInfo(r *http.Response) ResponseInfo {
+func responseInfo(r *http.Response, f *Filter) ResponseInfo {
 	return ResponseInfo{
-		Headers:       redactHeaders(r.Header, r.Trailer),
+		Headers:       f.headers(r.Header, r.Trailer),
 		ContentLength: r.ContentLength,
 		StatusCode:    r.StatusCode,
 	}
 }
 
 // ServerEvent records an HTTP server request handling event.
+//
+// In addition to the overall ServerRecv-ServerSend span, it records
+// when the response headers and the first byte of the response body
+// were written, so that streaming (e.g., chunked) responses can be
+// broken down into phases. ServerSend is the time that the handler
+// finished writing the response body.
 type ServerEvent struct {
-	Request    RequestInfo  `trace:"Server.Request"`
-	Response   ResponseInfo `trace:"Server.Response"`
-	Route      string       `trace:"Server.Route"`
-	User       string       `trace:"Server.User"`
-	ServerRecv time.Time    `trace:"Server.Recv"`
-	ServerSend time.Time    `trace:"Server.Send"`
+	Request            RequestInfo  `trace:"Server.Request"`
+	Response           ResponseInfo `trace:"Server.Response"`
+	Route              string       `trace:"Server.Route"`
+	User               string       `trace:"Server.User"`
+	Class              string       `trace:"Server.Class"` // see Classifier
+	ServerRecv         time.Time    `trace:"Server.Recv"`
+	ServerWroteHeaders time.Time    `trace:"Server.WroteHeaders"` // zero if the handler wrote no headers or body
+	ServerFirstByte    time.Time    `trace:"Server.FirstByte"`    // zero if the handler wrote no body
+	ServerSend         time.Time    `trace:"Server.Send"`
 }
 
 // Schema returns the constant "HTTPServer".
@@ -53,7 +64,7 @@ func (ServerEvent) Schema() string { return "HTTPServer" }
 
 // Important implements the appdash ImportantEvent.
 func (ServerEvent) Important() []string {
-	return []string{"Server.Response.StatusCode"}
+	return []string{"Server.Response.StatusCode", "Server.Class"}
 }
 
 // Start implements the appdash TimespanEvent interface.
@@ -67,45 +78,61 @@ func (e ServerEvent) End() time.Time { return e.ServerSend }
 // collector c as "HTTPServer"-schema events.
 func Middleware(c appdash.Collector, conf *MiddlewareConfig) func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-		spanID, spanFromHeader, err := getSpanID(r.Header)
-		if err != nil {
-			log.Printf("Warning: invalid %s header: %s. (Continuing with request handling.)", spanFromHeader, err)
-		}
-		usingProvidedSpanID := (spanFromHeader == HeaderSpanID)
-
-		if conf.SetContextSpan != nil {
-			conf.SetContextSpan(r, *spanID)
-		}
-
-		e := NewServerEvent(r)
-		e.ServerRecv = time.Now()
-		if conf.RouteName != nil {
-			e.Route = conf.RouteName(r)
-		}
-		if conf.CurrentUser != nil {
-			e.User = conf.CurrentUser(r)
-		}
-
-		rr := &responseInfoRecorder{ResponseWriter: rw}
-		next(rr, r)
-		SetSpanIDHeader(rr.Header(), *spanID)
-
-		if !usingProvidedSpanID {
-			e.Request = requestInfo(r)
-		}
-		e.Response = responseInfo(rr.partialResponse())
-		e.ServerSend = time.Now()
-
-		rec := appdash.NewRecorder(*spanID, c)
-		if e.Route != "" {
-			rec.Name(e.Route)
-		} else {
-			rec.Name(e.Request.Host)
-		}
-		rec.Event(e)
+		serve(c, conf, rw, r, func(rw http.ResponseWriter, r *http.Request, _ appdash.SpanID) {
+			next(rw, r)
+		})
 	}
 }
 
+// serve records the request r to c, as Middleware does, calling next
+// with the request's span ID to handle it.
+func serve(c appdash.Collector, conf *MiddlewareConfig, rw http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request, appdash.SpanID)) {
+	spanID, spanFromHeader, err := getSpanID(r.Header)
+	if err != nil {
+		log.Printf("Warning: invalid %s header: %s. (Continuing with request handling.)", spanFromHeader, err)
+	}
+	usingProvidedSpanID := (spanFromHeader == HeaderSpanID)
+
+	if conf.SetContextSpan != nil {
+		conf.SetContextSpan(r, *spanID)
+	}
+	if !appdash.Enabled() {
+		next(rw, r, *spanID)
+		return
+	}
+
+	e := &ServerEvent{Request: requestInfo(r, conf.Filter)}
+	e.ServerRecv = time.Now()
+	if conf.RouteName != nil {
+		e.Route = conf.RouteName(r)
+	}
+	if conf.CurrentUser != nil {
+		e.User = conf.CurrentUser(r)
+	}
+
+	rr := &responseInfoRecorder{ResponseWriter: rw}
+	next(rr, r, *spanID)
+	SetSpanIDHeader(rr.Header(), *spanID)
+
+	if !usingProvidedSpanID {
+		e.Request = requestInfo(r, conf.Filter)
+	}
+	e.Response = responseInfo(rr.partialResponse(), conf.Filter)
+	e.Class = conf.Classifier.Classify(r, e.Response.StatusCode, nil)
+	e.ServerWroteHeaders = rr.wroteHeaders
+	e.ServerFirstByte = rr.firstByte
+	e.ServerSend = time.Now()
+
+	rec := appdash.NewRecorder(*spanID, c)
+	if e.Route != "" {
+		rec.Name(e.Route)
+	} else {
+		rec.Name(e.Request.Host)
+	}
+	rec.Kind(appdash.SpanKindServer)
+	rec.Event(e)
+}
+
 // MiddlewareConfig configures the HTTP tracing middleware.
 type MiddlewareConfig struct {
 	// RouteName, if non-nil, is called to get the current route's
@@ -121,15 +148,27 @@ type MiddlewareConfig struct {
 	// the HTTP request context, so it may be used by other parts of
 	// the handling process.
 	SetContextSpan func(*http.Request, appdash.SpanID)
+
+	// Classifier classifies responses as successes, failures or
+	// ignored. If nil, 5xx responses are failures and all others are
+	// successes.
+	Classifier Classifier
+
+	// Filter controls which request and response headers and query
+	// parameters are recorded. If nil, the defaults described in the
+	// Filter documentation are used.
+	Filter *Filter
 }
 
 // responseInfoRecorder is an http.ResponseWriter that records a
-// response's HTTP status code and body length and forwards all
-// operations onto an underlying http.ResponseWriter, without
-// buffering the response body.
+// response's HTTP status code, body length and write times and
+// forwards all operations onto an underlying http.ResponseWriter,
+// without buffering the response body.
 type responseInfoRecorder struct {
-	statusCode    int   // HTTP response status code
-	ContentLength int64 // number of bytes written using the Write method
+	statusCode    int       // HTTP response status code
+	ContentLength int64     // number of bytes written using the Write method
+	wroteHeaders  time.Time // time the headers were written
+	firstByte     time.Time // time the first body byte was written
 
 	http.ResponseWriter // underlying ResponseWriter to pass-thru to
 }
@@ -140,9 +179,43 @@ func (r *responseInfoRecorder) Write(b []byte) (int, error) {
 	if r.statusCode == 0 {
 		r.statusCode = http.StatusOK
 	}
+	if r.wroteHeaders.IsZero() {
+		r.wroteHeaders = time.Now()
+	}
+	if r.firstByte.IsZero() && len(b) > 0 {
+		r.firstByte = time.Now()
+	}
 	return r.ResponseWriter.Write(b)
 }
 
+// Flush implements http.Flusher, so that handlers can stream
+// responses through the middleware. It is a no-op if the underlying
+// ResponseWriter does not implement http.Flusher.
+func (r *responseInfoRecorder) Flush() {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	if r.wroteHeaders.IsZero() {
+		r.wroteHeaders = time.Now()
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, so that handlers can take over the
+// connection (e.g., to switch protocols). It fails if the underlying
+// ResponseWriter cannot be hijacked.
+func (r *responseInfoRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter, so that an
+// http.ResponseController can reach its other optional methods.
+func (r *responseInfoRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func (r *responseInfoRecorder) StatusCode() int {
 	if r.statusCode == 0 {
 		return http.StatusOK
@@ -153,6 +226,9 @@ func (r *responseInfoRecorder) StatusCode() int {
 // WriteHeader sets r.Code.
 func (r *responseInfoRecorder) WriteHeader(code int) {
 	r.statusCode = code
+	if r.wroteHeaders.IsZero() {
+		r.wroteHeaders = time.Now()
+	}
 	r.ResponseWriter.WriteHeader(code)
 }
 
@@ -110,6 +110,7 @@ func TestMiddleware_useSpanFromHeaders(t *testing.T) {
 		},
 		User:  "u",
 		Route: "r",
+		Class: ClassSuccess,
 	}
 	delete(e.Request.Headers, "Span-Id")
 	e.ServerRecv = time.Time{}
@@ -158,6 +159,7 @@ func TestMiddleware_createNewSpan(t *testing.T) {
 			StatusCode: 200,
 			Headers:    map[string]string{"Span-Id": setContextSpan.String()},
 		},
+		Class: ClassSuccess,
 	}
 	delete(e.Request.Headers, "Span-Id")
 	e.ServerRecv = time.Time{}
@@ -220,3 +222,45 @@ func mapToAnnotations(m map[string]string) appdash.Annotations {
 	}
 	return anns
 }
+
+func TestMiddleware_streamingPhases(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	c := appdash.NewLocalCollector(ms)
+
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+
+	var spanID appdash.SpanID
+	mw := Middleware(c, &MiddlewareConfig{
+		SetContextSpan: func(r *http.Request, id appdash.SpanID) { spanID = id },
+	})
+
+	w := httptest.NewRecorder()
+	mw(w, req, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.(http.Flusher).Flush()
+		time.Sleep(time.Millisecond)
+		w.Write([]byte("a"))
+		time.Sleep(time.Millisecond)
+		w.Write([]byte("b"))
+	})
+
+	if !w.Flushed {
+		t.Error("response was not flushed")
+	}
+
+	trace, err := ms.Trace(spanID.Trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e ServerEvent
+	if err := appdash.UnmarshalEvent(trace.Span.Annotations, &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Response.StatusCode != http.StatusAccepted || e.Response.ContentLength != 2 {
+		t.Errorf("got response %+v, want status 202 and length 2", e.Response)
+	}
+	if e.ServerWroteHeaders.Before(e.ServerRecv) || !e.ServerFirstByte.After(e.ServerWroteHeaders) || !e.ServerSend.After(e.ServerFirstByte) {
+		t.Errorf("got out-of-order phases recv=%v wroteHeaders=%v firstByte=%v send=%v", e.ServerRecv, e.ServerWroteHeaders, e.ServerFirstByte, e.ServerSend)
+	}
+}
@@ -0,0 +1,163 @@
+package appdash
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// StoreInstrumentation receives measurements of the operations performed
+// on a store. Its methods may be called concurrently.
+type StoreInstrumentation interface {
+	// OnWrite is called after annotations are collected by the store.
+	OnWrite(d time.Duration, err error)
+
+	// OnQuery is called after the store is queried. The op is the name
+	// of the method that was called (e.g., "Trace" or "Traces").
+	OnQuery(op string, d time.Duration, err error)
+
+	// OnEvict is called after n traces are deleted from the store.
+	OnEvict(n int, d time.Duration, err error)
+}
+
+// An InstrumentedStore wraps another store and reports the durations and
+// errors of its operations to a StoreInstrumentation. Any Store can be
+// instrumented this way.
+//
+// To also instrument evictions from a RecentStore, use an InstrumentedStore
+// whose underlying store is a DeleteStore as the RecentStore's DeleteStore.
+type InstrumentedStore struct {
+	// Store is the underlying store.
+	Store
+
+	// Instrumentation receives the measurements.
+	Instrumentation StoreInstrumentation
+}
+
+// Compile-time "implements" check.
+var _ interface {
+	DeleteStore
+	Queryer
+	ContextCollector
+	ContextStore
+	ContextQueryer
+	FilterQueryer
+	BulkImporter
+} = (*InstrumentedStore)(nil)
+
+var (
+	errNotQueryer     = errors.New("appdash: underlying store is not a Queryer")
+	errNotDeleteStore = errors.New("appdash: underlying store is not a DeleteStore")
+)
+
+// Collect implements the Collector interface.
+func (s *InstrumentedStore) Collect(id SpanID, anns ...Annotation) error {
+	return s.CollectContext(context.Background(), id, anns...)
+}
+
+// CollectContext implements the ContextCollector interface.
+func (s *InstrumentedStore) CollectContext(ctx context.Context, id SpanID, anns ...Annotation) error {
+	start := time.Now()
+	err := CollectContext(ctx, s.Store, id, anns...)
+	s.Instrumentation.OnWrite(time.Since(start), err)
+	return err
+}
+
+// Trace implements the Store interface.
+func (s *InstrumentedStore) Trace(id ID) (*Trace, error) {
+	return s.TraceContext(context.Background(), id)
+}
+
+// TraceContext implements the ContextStore interface.
+func (s *InstrumentedStore) TraceContext(ctx context.Context, id ID) (*Trace, error) {
+	start := time.Now()
+	t, err := TraceContext(ctx, s.Store, id)
+	if errors.Is(err, ErrTraceNotFound) {
+		// Not finding a trace is a normal outcome of a query.
+		s.Instrumentation.OnQuery("Trace", time.Since(start), nil)
+	} else {
+		s.Instrumentation.OnQuery("Trace", time.Since(start), err)
+	}
+	return t, err
+}
+
+// Traces implements the Queryer interface. It returns an error if the
+// underlying store is not a Queryer.
+func (s *InstrumentedStore) Traces() ([]*Trace, error) {
+	return s.TracesContext(context.Background())
+}
+
+// TracesContext implements the ContextQueryer interface. It returns an
+// error if the underlying store is not a Queryer.
+func (s *InstrumentedStore) TracesContext(ctx context.Context) ([]*Trace, error) {
+	q, ok := s.Store.(Queryer)
+	if !ok {
+		return nil, errNotQueryer
+	}
+	start := time.Now()
+	ts, err := TracesContext(ctx, q)
+	s.Instrumentation.OnQuery("Traces", time.Since(start), err)
+	return ts, err
+}
+
+// FilterTraces implements the FilterQueryer interface (see the
+// FilterTraces function). It returns an error if the underlying store is
+// not a Queryer.
+func (s *InstrumentedStore) FilterTraces(ctx context.Context, f TraceFilter) ([]*Trace, error) {
+	q, ok := s.Store.(Queryer)
+	if !ok {
+		return nil, errNotQueryer
+	}
+	start := time.Now()
+	ts, err := FilterTraces(ctx, q, f)
+	if errors.Is(err, ErrScanLimit) {
+		// Reaching the scan limit is a normal outcome of a query.
+		s.Instrumentation.OnQuery("FilterTraces", time.Since(start), nil)
+	} else {
+		s.Instrumentation.OnQuery("FilterTraces", time.Since(start), err)
+	}
+	return ts, err
+}
+
+// Delete implements the DeleteStore interface. It returns an error if the
+// underlying store is not a DeleteStore.
+func (s *InstrumentedStore) Delete(traces ...ID) error {
+	ds, ok := s.Store.(DeleteStore)
+	if !ok {
+		return errNotDeleteStore
+	}
+	start := time.Now()
+	err := ds.Delete(traces...)
+	s.Instrumentation.OnEvict(len(traces), time.Since(start), err)
+	return err
+}
+
+// BeginImport implements the BulkImporter interface. If the underlying
+// store is a BulkImporter, its import is used, and committing it is
+// reported as a single write; otherwise each span is collected (and
+// reported) separately.
+func (s *InstrumentedStore) BeginImport() (Import, error) {
+	bi, ok := s.Store.(BulkImporter)
+	if !ok {
+		return &collectImport{ctx: context.Background(), c: s}, nil
+	}
+	imp, err := bi.BeginImport()
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedImport{Import: imp, s: s}, nil
+}
+
+// instrumentedImport is an Import into the store underlying an
+// InstrumentedStore.
+type instrumentedImport struct {
+	Import
+	s *InstrumentedStore
+}
+
+func (ii *instrumentedImport) Commit() error {
+	start := time.Now()
+	err := ii.Import.Commit()
+	ii.s.Instrumentation.OnWrite(time.Since(start), err)
+	return err
+}
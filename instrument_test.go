@@ -0,0 +1,129 @@
+package appdash
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingInstrumentation struct {
+	mu  sync.Mutex
+	ops []string
+}
+
+func (ri *recordingInstrumentation) add(op string, err error) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	if err != nil {
+		op += " error"
+	}
+	ri.ops = append(ri.ops, op)
+}
+
+func (ri *recordingInstrumentation) OnWrite(d time.Duration, err error) { ri.add("write", err) }
+func (ri *recordingInstrumentation) OnQuery(op string, d time.Duration, err error) {
+	ri.add("query "+op, err)
+}
+func (ri *recordingInstrumentation) OnEvict(n int, d time.Duration, err error) {
+	ri.add(fmt.Sprintf("evict %d", n), err)
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	ri := &recordingInstrumentation{}
+	s := &InstrumentedStore{Store: NewMemoryStore(), Instrumentation: ri}
+
+	if err := s.Collect(SpanID{1, 2, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Trace(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Trace(2); err != ErrTraceNotFound {
+		t.Fatalf("got err %v, want ErrTraceNotFound", err)
+	}
+	if _, err := s.Traces(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"write", "query Trace", "query Trace", "query Traces", "evict 2"}
+	if !reflect.DeepEqual(ri.ops, want) {
+		t.Errorf("got ops %q, want %q", ri.ops, want)
+	}
+}
+
+func TestInstrumentedStore_recentStoreEvictions(t *testing.T) {
+	ri := &recordingInstrumentation{}
+	rs := &RecentStore{
+		MinEvictAge: time.Millisecond,
+		DeleteStore: &InstrumentedStore{Store: NewMemoryStore(), Instrumentation: ri},
+	}
+	if err := rs.Collect(SpanID{1, 2, 0}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := rs.Collect(SpanID{3, 4, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Evictions happen in the background.
+	for i := 0; i < 100; i++ {
+		ri.mu.Lock()
+		n := len(ri.ops)
+		ri.mu.Unlock()
+		if n == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	want := map[string]bool{"write": true, "evict 1": true}
+	for _, op := range ri.ops {
+		if !want[op] {
+			t.Errorf("unexpected op %q", op)
+		}
+	}
+	if len(ri.ops) != 3 {
+		t.Errorf("got ops %q, want 2 writes and 1 eviction", ri.ops)
+	}
+}
+
+func TestInstrumentedStore_BeginImport(t *testing.T) {
+	// The stores are wired as by "appdash serve" with --delete-after.
+	ri := &recordingInstrumentation{}
+	rs := &RecentStore{
+		MinEvictAge: time.Hour,
+		DeleteStore: &InstrumentedStore{Store: NewMemoryStore(), Instrumentation: ri},
+	}
+
+	imp, err := rs.BeginImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := imp.Add(Span{ID: SpanID{1, 1, 0}}, Span{ID: SpanID{1, 2, 1}}, Span{ID: SpanID{2, 1, 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.Trace(1); err != ErrTraceNotFound {
+		t.Fatalf("got err %v before commit, want ErrTraceNotFound (spans collected one at a time)", err)
+	}
+	if err := imp.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := rs.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.Sub) != 1 {
+		t.Errorf("got %d subtraces, want 1", len(tr.Sub))
+	}
+	want := []string{"query Trace", "write", "query Trace"}
+	if !reflect.DeepEqual(ri.ops, want) {
+		t.Errorf("got ops %q, want %q", ri.ops, want)
+	}
+}
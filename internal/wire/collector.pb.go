@@ -23,9 +23,13 @@ var _ = math.Inf
 // CollectPacket is the message sent to a remote collector server by one of
 // it's clients.
 type CollectPacket struct {
-	Spanid           *CollectPacket_SpanID       `protobuf:"group,1,req,name=SpanID" json:"spanid,omitempty"`
-	Annotation       []*CollectPacket_Annotation `protobuf:"group,5,rep" json:"annotation,omitempty"`
-	XXX_unrecognized []byte                      `json:"-"`
+	Spanid     *CollectPacket_SpanID       `protobuf:"group,1,req,name=SpanID" json:"spanid,omitempty"`
+	Annotation []*CollectPacket_Annotation `protobuf:"group,5,rep" json:"annotation,omitempty"`
+	// timestamp is the time at which the client sent the packet, in
+	// nanoseconds since the Unix epoch. Servers may use it to order the
+	// packets they receive.
+	Timestamp        *int64 `protobuf:"fixed64,8,opt,name=timestamp" json:"timestamp,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
 }
 
 func (m *CollectPacket) Reset()         { *m = CollectPacket{} }
@@ -46,6 +50,13 @@ func (m *CollectPacket) GetAnnotation() []*CollectPacket_Annotation {
 	return nil
 }
 
+func (m *CollectPacket) GetTimestamp() int64 {
+	if m != nil && m.Timestamp != nil {
+		return *m.Timestamp
+	}
+	return 0
+}
+
 // SpanID is the group of information which can uniquely identify the exact
 // span being collected.
 type CollectPacket_SpanID struct {
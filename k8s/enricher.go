@@ -0,0 +1,243 @@
+// Package k8s enriches the spans received by an appdash collector server
+// with the Kubernetes pod, namespace, deployment and node of the client
+// that sent them, so that traces can be navigated by workload.
+//
+// Run the collector server in the cluster (e.g., as a sidecar or its own
+// deployment) with a service account that may list pods, and set its
+// Enricher:
+//
+//	e, err := k8s.InCluster()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	cs := appdash.NewServer(l, appdash.NewLocalCollector(store))
+//	cs.Enricher = e
+//
+// Clients must connect to the collector server directly (not through a
+// proxy or NAT), because pods are found by the connection's source IP.
+package k8s
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func init() { appdash.RegisterEvent(PodEvent{}) }
+
+// PodEvent describes the Kubernetes pod that a span was sent from.
+type PodEvent struct {
+	Pod        string `trace:"Kubernetes.Pod"`
+	Namespace  string `trace:"Kubernetes.Namespace"`
+	Deployment string `trace:"Kubernetes.Deployment"`
+	Node       string `trace:"Kubernetes.Node"`
+}
+
+// Schema implements the appdash.Event interface.
+func (PodEvent) Schema() string { return "Kubernetes" }
+
+// Important implements the appdash.ImportantEvent interface.
+func (PodEvent) Important() []string {
+	return []string{"Kubernetes.Namespace", "Kubernetes.Deployment", "Kubernetes.Pod"}
+}
+
+// serviceAccountDir is where Kubernetes mounts the service account
+// credentials in each container.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// DefaultTTL is how long an Enricher caches the pod found for an IP
+// address (or the absence of one) if its TTL is zero.
+const DefaultTTL = 5 * time.Minute
+
+// An Enricher is an appdash.Enricher that looks up the pod whose IP
+// address is that of the client in the Kubernetes API server.
+type Enricher struct {
+	// Host is the base URL of the API server, e.g.
+	// "https://10.0.0.1:443".
+	Host string
+
+	// Token is the bearer token used to authenticate to the API
+	// server.
+	Token string
+
+	// Client is the HTTP client used to talk to the API server. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+
+	// TTL is how long the result of a lookup is cached. If zero,
+	// DefaultTTL is used. Expired results are removed from the cache
+	// about once per TTL, so that it doesn't grow as pods come and go.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry // by IP address
+	swept time.Time             // when expired entries were last removed
+}
+
+var _ appdash.Enricher = (*Enricher)(nil)
+
+type cacheEntry struct {
+	anns    appdash.Annotations
+	expires time.Time
+}
+
+// InCluster returns an Enricher that uses the API server and service
+// account credentials that Kubernetes provides to containers running
+// in the cluster.
+func InCluster() (*Enricher, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("k8s: not running in a Kubernetes cluster (KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set)")
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("k8s: no certificates found in service account CA file")
+	}
+	return &Enricher{
+		Host:  "https://" + net.JoinHostPort(host, port),
+		Token: strings.TrimSpace(string(token)),
+		Client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			Timeout:   10 * time.Second,
+		},
+	}, nil
+}
+
+// Enrich implements the appdash.Enricher interface. It returns no
+// annotations if no pod (or more than one pod, as with pods using the
+// host's network) has the client's IP address.
+func (e *Enricher) Enrich(addr net.Addr) (appdash.Annotations, error) {
+	ip, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	c, ok := e.cache[ip]
+	if ok && !time.Now().Before(c.expires) {
+		delete(e.cache, ip)
+		ok = false
+	}
+	e.mu.Unlock()
+	if ok {
+		return c.anns, nil
+	}
+
+	anns, err := e.lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+	ttl := e.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	now := time.Now()
+	e.mu.Lock()
+	if e.cache == nil {
+		e.cache = map[string]cacheEntry{}
+	}
+	if now.Sub(e.swept) >= ttl {
+		for ip, c := range e.cache {
+			if !now.Before(c.expires) {
+				delete(e.cache, ip)
+			}
+		}
+		e.swept = now
+	}
+	e.cache[ip] = cacheEntry{anns: anns, expires: now.Add(ttl)}
+	e.mu.Unlock()
+	return anns, nil
+}
+
+// pod is the subset of a Kubernetes Pod object that is used.
+type pod struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		Labels          map[string]string `json:"labels"`
+		OwnerReferences []struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+}
+
+// lookup finds the pod with the given IP address in the API server.
+func (e *Enricher) lookup(ip string) (appdash.Annotations, error) {
+	u := strings.TrimSuffix(e.Host, "/") + "/api/v1/pods?fieldSelector=" + url.QueryEscape("status.podIP="+ip)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if e.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.Token)
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("k8s: listing pods with IP %s: %s", ip, resp.Status)
+	}
+	var list struct {
+		Items []pod `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	if len(list.Items) != 1 {
+		return nil, nil
+	}
+	p := list.Items[0]
+	return appdash.MarshalEvent(PodEvent{
+		Pod:        p.Metadata.Name,
+		Namespace:  p.Metadata.Namespace,
+		Deployment: deployment(p),
+		Node:       p.Spec.NodeName,
+	})
+}
+
+// deployment returns the name of the deployment that the pod belongs to,
+// or "" if it doesn't belong to one. Pods of a deployment are owned by a
+// ReplicaSet whose name is the deployment's name followed by the pod
+// template hash, so no further API requests are needed.
+func deployment(p pod) string {
+	hash := p.Metadata.Labels["pod-template-hash"]
+	if hash == "" {
+		return ""
+	}
+	for _, o := range p.Metadata.OwnerReferences {
+		if o.Kind == "ReplicaSet" && strings.HasSuffix(o.Name, "-"+hash) {
+			return strings.TrimSuffix(o.Name, "-"+hash)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package k8s
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func TestEnricher(t *testing.T) {
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if got, want := r.Header.Get("Authorization"), "Bearer t"; got != want {
+			t.Errorf("got Authorization %q, want %q", got, want)
+		}
+		switch r.URL.Query().Get("fieldSelector") {
+		case "status.podIP=10.1.2.3":
+			w.Write([]byte(`{"items": [{
+				"metadata": {
+					"name": "web-7d4b9c-x2x9z",
+					"namespace": "prod",
+					"labels": {"pod-template-hash": "7d4b9c"},
+					"ownerReferences": [{"kind": "ReplicaSet", "name": "web-7d4b9c"}]
+				},
+				"spec": {"nodeName": "node-1"}
+			}]}`))
+		default:
+			w.Write([]byte(`{"items": []}`))
+		}
+	}))
+	defer s.Close()
+
+	e := &Enricher{Host: s.URL, Token: "t"}
+	for i := 0; i < 2; i++ {
+		anns, err := e.Enrich(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1234})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ev PodEvent
+		if err := appdash.UnmarshalEvent(anns, &ev); err != nil {
+			t.Fatal(err)
+		}
+		want := PodEvent{Pod: "web-7d4b9c-x2x9z", Namespace: "prod", Deployment: "web", Node: "node-1"}
+		if ev != want {
+			t.Errorf("got %+v, want %+v", ev, want)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1 (cached)", requests)
+	}
+
+	anns, err := e.Enrich(&net.TCPAddr{IP: net.ParseIP("10.9.9.9"), Port: 1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anns != nil {
+		t.Errorf("got annotations %v for unknown IP, want none", anns)
+	}
+}
+
+func TestEnricher_expire(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items": []}`))
+	}))
+	defer s.Close()
+
+	e := &Enricher{Host: s.URL, TTL: 10 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if _, err := e.Enrich(&net.TCPAddr{IP: net.IPv4(10, 1, 0, byte(i)), Port: 1234}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// Pods that are gone must not stay in the cache.
+	if _, err := e.Enrich(&net.TCPAddr{IP: net.IPv4(10, 2, 0, 1), Port: 1234}); err != nil {
+		t.Fatal(err)
+	}
+	e.mu.Lock()
+	n := len(e.cache)
+	e.mu.Unlock()
+	if n != 1 {
+		t.Errorf("got %d cached entries, want 1 (expired ones removed)", n)
+	}
+}
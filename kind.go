@@ -0,0 +1,87 @@
+package appdash
+
+func init() { RegisterEvent(spanKind{}) }
+
+// A SpanKind describes the role of a span in an operation that spans
+// processes, so that the two sides of a remote call can be paired.
+type SpanKind string
+
+const (
+	// SpanKindClient is a span that makes a synchronous request to a
+	// remote service (e.g., an outgoing HTTP request or SQL query).
+	SpanKindClient SpanKind = "client"
+
+	// SpanKindServer is a span that handles a synchronous request
+	// from a remote client.
+	SpanKindServer SpanKind = "server"
+
+	// SpanKindProducer is a span that sends a message to be handled
+	// asynchronously (e.g., by enqueueing it).
+	SpanKindProducer SpanKind = "producer"
+
+	// SpanKindConsumer is a span that handles a message sent by a
+	// producer.
+	SpanKindConsumer SpanKind = "consumer"
+
+	// SpanKindInternal is a span that does not cross a process
+	// boundary.
+	SpanKindInternal SpanKind = "internal"
+)
+
+// spanKindKey is the key of the annotations that record a span's
+// kinds.
+const spanKindKey = "Span.Kind"
+
+// A spanKind event records one of a span's kinds.
+type spanKind struct {
+	Kind string `trace:"Span.Kind"`
+}
+
+func (spanKind) Schema() string { return "kind" }
+
+func (spanKind) Important() []string { return []string{spanKindKey} }
+
+// Kind records that the span has the given kind. A span may have more
+// than one kind: in particular, the HTTP client and server
+// instrumentation of the httptrace package record both sides of a
+// request on the same span, which thus has both SpanKindClient and
+// SpanKindServer (once both sides have reported).
+func (r *Recorder) Kind(k SpanKind) {
+	if !r.Enabled() {
+		return
+	}
+	r.Event(spanKind{string(k)})
+}
+
+// Kinds returns the kinds recorded for the span (see Recorder.Kind),
+// in the order they were first recorded, without duplicates.
+func (s *Span) Kinds() []SpanKind {
+	var kinds []SpanKind
+	for _, a := range s.Annotations {
+		if a.Key != spanKindKey {
+			continue
+		}
+		k := SpanKind(a.Value)
+		dup := false
+		for _, k2 := range kinds {
+			if k2 == k {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+// HasKind reports whether the span has the kind k.
+func (s *Span) HasKind(k SpanKind) bool {
+	for _, k2 := range s.Kinds() {
+		if k2 == k {
+			return true
+		}
+	}
+	return false
+}
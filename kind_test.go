@@ -0,0 +1,34 @@
+package appdash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecorder_Kind(t *testing.T) {
+	ms := NewMemoryStore()
+	client := NewRecorder(SpanID{1, 2, 0}, ms)
+	server := NewRecorder(SpanID{1, 2, 0}, ms)
+	client.Kind(SpanKindClient)
+	server.Kind(SpanKindServer)
+	client.Kind(SpanKindClient)
+
+	tr, err := ms.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []SpanKind{SpanKindClient, SpanKindServer}; !reflect.DeepEqual(tr.Span.Kinds(), want) {
+		t.Errorf("got kinds %v, want %v", tr.Span.Kinds(), want)
+	}
+	if !tr.Span.HasKind(SpanKindServer) || tr.Span.HasKind(SpanKindConsumer) {
+		t.Errorf("got HasKind(server) %v, HasKind(consumer) %v, want true, false", tr.Span.HasKind(SpanKindServer), tr.Span.HasKind(SpanKindConsumer))
+	}
+
+	var e spanKind
+	if err := UnmarshalEvent(tr.Span.Annotations, &e); err != nil {
+		t.Fatal(err)
+	}
+	if SpanKind(e.Kind) != SpanKindClient {
+		t.Errorf("got unmarshaled kind %q, want %q", e.Kind, SpanKindClient)
+	}
+}
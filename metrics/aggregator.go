@@ -0,0 +1,542 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Defaults for the Aggregator's fields.
+const (
+	DefaultAggregatorWindow     = time.Hour
+	DefaultAggregatorResolution = time.Minute
+	DefaultAggregatorMaxPending = 10000
+)
+
+// An Aggregator is an appdash.Collector that incrementally aggregates the
+// latencies and errors of the spans it collects, by span name, in time
+// buckets, before passing the annotations on to its underlying
+// collector. It answers queries such as "the 10 span names with the
+// highest 99th-percentile latency in the last 15 minutes" (see Top)
+// without scanning all stored traces.
+//
+// A span's name, duration and error status may be collected in separate
+// Collect calls (as a Recorder does). The Aggregator counts a span once
+// it knows both its name and its duration. Ignored spans (see IsIgnored)
+// are not counted.
+type Aggregator struct {
+	// Collector is the underlying collector.
+	appdash.Collector
+
+	// Window is how far back the aggregates are kept. If zero,
+	// DefaultAggregatorWindow is used.
+	Window time.Duration
+
+	// Resolution is the duration of each time bucket. If zero,
+	// DefaultAggregatorResolution is used.
+	Resolution time.Duration
+
+	// MaxPending is the maximum number of spans whose name or duration
+	// is not yet known that are remembered. When it is reached, the
+	// oldest such span is counted (as "unknown" if it has a duration)
+	// or dropped. If zero, DefaultAggregatorMaxPending is used.
+	MaxPending int
+
+	// IsError reports whether a span's events describe a failed
+	// operation. If nil, IsError (the package-level function) is used.
+	IsError func([]appdash.Event) bool
+
+	mu           sync.Mutex
+	since        time.Time             // when the first annotations were collected
+	buckets      []*aggBucket          // oldest first
+	deploys      []appdash.DeployEvent // in the order collected
+	pending      map[appdash.SpanID]*pendingSpan
+	pendingOrder []appdash.SpanID // FIFO of the keys of pending
+}
+
+// pendingSpan is a span whose name or duration is not yet known.
+type pendingSpan struct {
+	name     string
+	duration time.Duration
+	hasDur   bool
+	err      bool
+	ignored  bool
+}
+
+// aggBucket holds the aggregates for the spans counted during one
+// time bucket.
+type aggBucket struct {
+	start  time.Time
+	byName map[string]*latencyHistogram
+}
+
+// Compile-time "implements" check.
+var _ appdash.Collector = (*Aggregator)(nil)
+
+// Collect implements the appdash.Collector interface.
+func (a *Aggregator) Collect(id appdash.SpanID, anns ...appdash.Annotation) error {
+	a.mu.Lock()
+	if a.since.IsZero() {
+		a.since = time.Now()
+	}
+	a.mu.Unlock()
+	a.observe(id, anns)
+	return a.Collector.Collect(id, anns...)
+}
+
+func (a *Aggregator) observe(id appdash.SpanID, anns appdash.Annotations) {
+	var events []appdash.Event
+	if err := appdash.UnmarshalEvents(anns, &events); err != nil {
+		return
+	}
+	var (
+		name     string
+		duration time.Duration
+		hasDur   bool
+	)
+	for _, ann := range anns {
+		if ann.Key == "Name" {
+			name = string(ann.Value)
+		}
+	}
+	for _, ev := range events {
+		if d, ok := ev.(appdash.DeployEvent); ok {
+			a.addDeploy(d)
+		}
+		if ts, ok := ev.(appdash.TimespanEvent); ok {
+			if d := ts.End().Sub(ts.Start()); !hasDur || d > duration {
+				duration, hasDur = d, true
+			}
+		}
+	}
+	isError := a.IsError
+	if isError == nil {
+		isError = IsError
+	}
+	failed := isError(events)
+	ignored := IsIgnored(events)
+	if name == "" && !hasDur && !failed && !ignored {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending == nil {
+		a.pending = map[appdash.SpanID]*pendingSpan{}
+	}
+	p, ok := a.pending[id]
+	if !ok {
+		p = &pendingSpan{}
+		a.pending[id] = p
+		a.pendingOrder = append(a.pendingOrder, id)
+	}
+	if name != "" {
+		p.name = name
+	}
+	if hasDur && (!p.hasDur || duration > p.duration) {
+		p.duration, p.hasDur = duration, true
+	}
+	p.err = p.err || failed
+	p.ignored = p.ignored || ignored
+
+	now := time.Now()
+	if p.name != "" && p.hasDur {
+		if !p.ignored {
+			a.count(now, p)
+		}
+		delete(a.pending, id)
+	}
+
+	maxPending := a.MaxPending
+	if maxPending == 0 {
+		maxPending = DefaultAggregatorMaxPending
+	}
+	for len(a.pending) > maxPending || len(a.pendingOrder) > 2*maxPending {
+		oldest := a.pendingOrder[0]
+		a.pendingOrder = a.pendingOrder[1:]
+		if p, ok := a.pending[oldest]; ok {
+			if p.hasDur && !p.ignored {
+				p.name = "unknown"
+				a.count(now, p)
+			}
+			delete(a.pending, oldest)
+		}
+	}
+}
+
+// count adds a span to the current bucket. It must be called with a.mu
+// held.
+func (a *Aggregator) count(now time.Time, p *pendingSpan) {
+	res := a.resolution()
+	start := now.Truncate(res)
+	if n := len(a.buckets); n == 0 || a.buckets[n-1].start.Before(start) {
+		a.buckets = append(a.buckets, &aggBucket{start: start, byName: map[string]*latencyHistogram{}})
+	}
+	b := a.buckets[len(a.buckets)-1]
+	h, ok := b.byName[p.name]
+	if !ok {
+		h = &latencyHistogram{}
+		b.byName[p.name] = h
+	}
+	h.add(p.duration, p.err)
+
+	// Drop buckets that are older than the window.
+	cutoff := now.Add(-a.window() - res)
+	for len(a.buckets) > 0 && a.buckets[0].start.Before(cutoff) {
+		a.buckets[0] = nil
+		a.buckets = a.buckets[1:]
+	}
+}
+
+// addDeploy remembers a deploy marker, forgetting those that are older
+// than the window.
+func (a *Aggregator) addDeploy(d appdash.DeployEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cutoff := time.Now().Add(-a.window())
+	deploys := a.deploys[:0]
+	for _, old := range a.deploys {
+		if !old.Time.Before(cutoff) {
+			deploys = append(deploys, old)
+		}
+	}
+	a.deploys = append(deploys, d)
+}
+
+func (a *Aggregator) window() time.Duration {
+	if a.Window == 0 {
+		return DefaultAggregatorWindow
+	}
+	return a.Window
+}
+
+func (a *Aggregator) resolution() time.Duration {
+	if a.Resolution == 0 {
+		return DefaultAggregatorResolution
+	}
+	return a.Resolution
+}
+
+// TopOrder is the order in which Top ranks span names.
+type TopOrder string
+
+const (
+	// ByP99 ranks span names by their 99th-percentile latency.
+	ByP99 TopOrder = "p99"
+
+	// ByErrorRate ranks span names by the fraction of their spans that
+	// failed.
+	ByErrorRate TopOrder = "error-rate"
+)
+
+// NameStats are the aggregated metrics of the spans with a name.
+type NameStats struct {
+	Name      string        `json:"name"`
+	Count     int64         `json:"count"`
+	Errors    int64         `json:"errors"`
+	ErrorRate float64       `json:"errorRate"`
+	P50       time.Duration `json:"p50"`
+	P99       time.Duration `json:"p99"`
+}
+
+// Top returns the metrics of the n span names that rank highest in the
+// given order, over the spans counted in the last window (which is
+// rounded up to a multiple of the Aggregator's Resolution, and limited to
+// its Window). If n is zero or negative, all span names are returned.
+func (a *Aggregator) Top(n int, window time.Duration, by TopOrder) []NameStats {
+	stats := a.Between(time.Now().Add(-window), time.Time{})
+	sort.Slice(stats, func(i, j int) bool {
+		si, sj := stats[i], stats[j]
+		switch {
+		case by == ByErrorRate && si.ErrorRate != sj.ErrorRate:
+			return si.ErrorRate > sj.ErrorRate
+		case by != ByErrorRate && si.P99 != sj.P99:
+			return si.P99 > sj.P99
+		case si.Count != sj.Count:
+			return si.Count > sj.Count
+		}
+		return si.Name < sj.Name
+	})
+	if n > 0 && len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// Oldest returns the earliest time that the Aggregator's metrics cover:
+// the later of when it first collected annotations and the start of its
+// Window, rounded down to a multiple of its Resolution. Metrics of
+// earlier times are incomplete or missing. If nothing has been collected
+// yet, Oldest returns the current time.
+func (a *Aggregator) Oldest() time.Time {
+	now := time.Now()
+	a.mu.Lock()
+	oldest := a.since
+	a.mu.Unlock()
+	if oldest.IsZero() {
+		return now
+	}
+	if start := now.Add(-a.window()); oldest.Before(start) {
+		oldest = start
+	}
+	return oldest.Truncate(a.resolution())
+}
+
+// Between returns the metrics of the spans counted from start until end,
+// sorted by name. Both are rounded down to a multiple of the Aggregator's
+// Resolution; a zero end means now. Spans counted longer ago than the
+// Aggregator's Window are not included (see Oldest).
+func (a *Aggregator) Between(start, end time.Time) []NameStats {
+	res := a.resolution()
+	start = start.Truncate(res)
+	end = end.Truncate(res)
+
+	merged := map[string]*latencyHistogram{}
+	a.mu.Lock()
+	for _, b := range a.buckets {
+		if b.start.Before(start) || (!end.IsZero() && !b.start.Before(end)) {
+			continue
+		}
+		for name, h := range b.byName {
+			m, ok := merged[name]
+			if !ok {
+				m = &latencyHistogram{}
+				merged[name] = m
+			}
+			m.merge(h)
+		}
+	}
+	a.mu.Unlock()
+
+	stats := make([]NameStats, 0, len(merged))
+	for name, h := range merged {
+		stats = append(stats, h.stats(name))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Deploys returns the deploy markers (see appdash.DeployEvent) collected
+// by the Aggregator whose time is from start until end, in time order. A
+// zero end means no limit. Markers older than the Aggregator's Window are
+// not included.
+func (a *Aggregator) Deploys(start, end time.Time) []appdash.DeployEvent {
+	var deploys []appdash.DeployEvent
+	a.mu.Lock()
+	for _, d := range a.deploys {
+		if !d.Time.Before(start) && (end.IsZero() || d.Time.Before(end)) {
+			deploys = append(deploys, d)
+		}
+	}
+	a.mu.Unlock()
+	sort.SliceStable(deploys, func(i, j int) bool { return deploys[i].Time.Before(deploys[j].Time) })
+	return deploys
+}
+
+// HeatBands are the upper bounds of the latency bands that Point.Heat
+// counts spans in. The last band counts the spans slower than the last
+// bound.
+var HeatBands = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// A Point holds the metrics of all spans counted during one time bucket
+// of an Aggregator.
+type Point struct {
+	Time   time.Time     `json:"time"`
+	Count  int64         `json:"count"`
+	Errors int64         `json:"errors"`
+	P50    time.Duration `json:"p50"`
+	P99    time.Duration `json:"p99"`
+
+	// Heat is the number of spans in each latency band (see HeatBands),
+	// for rendering latency heatmaps. It has len(HeatBands)+1 elements.
+	Heat []int64 `json:"heat"`
+}
+
+// Series returns the metrics of all spans, per time bucket, from start
+// until end (rounded down to a multiple of the Aggregator's Resolution;
+// a zero end means now). Buckets in which no spans were counted are
+// omitted.
+func (a *Aggregator) Series(start, end time.Time) []Point {
+	res := a.resolution()
+	start = start.Truncate(res)
+	end = end.Truncate(res)
+
+	var points []Point
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, b := range a.buckets {
+		if b.start.Before(start) || (!end.IsZero() && !b.start.Before(end)) {
+			continue
+		}
+		var h latencyHistogram
+		for _, nh := range b.byName {
+			h.merge(nh)
+		}
+		points = append(points, Point{
+			Time:   b.start,
+			Count:  h.count,
+			Errors: h.errors,
+			P50:    h.percentile(0.5),
+			P99:    h.percentile(0.99),
+			Heat:   h.heat(),
+		})
+	}
+	return points
+}
+
+// Delta is the change in the metrics of the spans with a name between
+// two time windows.
+type Delta struct {
+	Name   string    `json:"name"`
+	Before NameStats `json:"before"`
+	After  NameStats `json:"after"`
+
+	// P99 is After.P99 - Before.P99.
+	P99 time.Duration `json:"p99"`
+
+	// ErrorRate is After.ErrorRate - Before.ErrorRate.
+	ErrorRate float64 `json:"errorRate"`
+}
+
+// Compare returns the change in the metrics of each span name from the
+// before window to the after window (as returned by Between), sorted by
+// regression magnitude: the largest increase in p99 latency or error
+// rate, depending on by, comes first. Span names that are only in one
+// window are compared against zero metrics.
+func Compare(before, after []NameStats, by TopOrder) []Delta {
+	byName := map[string]*Delta{}
+	get := func(name string) *Delta {
+		d, ok := byName[name]
+		if !ok {
+			d = &Delta{Name: name}
+			byName[name] = d
+		}
+		return d
+	}
+	for _, s := range before {
+		get(s.Name).Before = s
+	}
+	for _, s := range after {
+		get(s.Name).After = s
+	}
+
+	deltas := make([]Delta, 0, len(byName))
+	for _, d := range byName {
+		d.P99 = d.After.P99 - d.Before.P99
+		d.ErrorRate = d.After.ErrorRate - d.Before.ErrorRate
+		deltas = append(deltas, *d)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		di, dj := deltas[i], deltas[j]
+		switch {
+		case by == ByErrorRate && di.ErrorRate != dj.ErrorRate:
+			return di.ErrorRate > dj.ErrorRate
+		case by != ByErrorRate && di.P99 != dj.P99:
+			return di.P99 > dj.P99
+		}
+		return di.Name < dj.Name
+	})
+	return deltas
+}
+
+// latencyHistogram is a mergeable histogram of latencies with
+// logarithmically sized buckets, each 10% wider than the previous one,
+// from 1µs to several minutes. Percentiles are accurate to within 10%.
+type latencyHistogram struct {
+	count, errors int64
+	buckets       [latencyBuckets]int64
+}
+
+const (
+	latencyBuckets = 200
+	latencyGrowth  = 1.1
+)
+
+// latencyBucket returns the index of the bucket that d is counted in.
+func latencyBucket(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us <= 1 {
+		return 0
+	}
+	i := int(math.Log(us)/math.Log(latencyGrowth)) + 1
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+	return i
+}
+
+// latencyBucketBound returns the upper bound of bucket i.
+func latencyBucketBound(i int) time.Duration {
+	return time.Duration(math.Pow(latencyGrowth, float64(i)) * float64(time.Microsecond))
+}
+
+func (h *latencyHistogram) add(d time.Duration, err bool) {
+	h.count++
+	if err {
+		h.errors++
+	}
+	h.buckets[latencyBucket(d)]++
+}
+
+func (h *latencyHistogram) stats(name string) NameStats {
+	return NameStats{
+		Name:      name,
+		Count:     h.count,
+		Errors:    h.errors,
+		ErrorRate: float64(h.errors) / float64(h.count),
+		P50:       h.percentile(0.5),
+		P99:       h.percentile(0.99),
+	}
+}
+
+func (h *latencyHistogram) merge(o *latencyHistogram) {
+	h.count += o.count
+	h.errors += o.errors
+	for i, c := range o.buckets {
+		h.buckets[i] += c
+	}
+}
+
+// heat returns the number of latencies in each of HeatBands. Latencies
+// are assigned to bands by the lower bound of their bucket.
+func (h *latencyHistogram) heat() []int64 {
+	heat := make([]int64, len(HeatBands)+1)
+	for i, c := range h.buckets {
+		if c == 0 {
+			continue
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = latencyBucketBound(i - 1)
+		}
+		band := sort.Search(len(HeatBands), func(j int) bool { return lower < HeatBands[j] })
+		heat[band] += c
+	}
+	return heat
+}
+
+// percentile returns the upper bound of the bucket containing the p-th
+// (0 <= p <= 1) percentile latency, using the nearest-rank method.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := nearestRank(p, h.count)
+	var seen int64
+	for i, c := range h.buckets {
+		seen += c
+		if seen >= rank {
+			return latencyBucketBound(i)
+		}
+	}
+	return latencyBucketBound(latencyBuckets - 1)
+}
@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/httptrace"
+)
+
+func TestAggregator(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	agg := &Aggregator{Collector: ms}
+
+	record := func(span uint64, name string, d time.Duration, status int) {
+		rec := appdash.NewRecorder(appdash.SpanID{Trace: 1, Span: appdash.ID(span)}, agg)
+		rec.Name(name)
+		start := time.Unix(100, 0)
+		rec.Event(httptrace.ServerEvent{
+			Response:   httptrace.ResponseInfo{StatusCode: status},
+			ServerRecv: start,
+			ServerSend: start.Add(d),
+		})
+		if errs := rec.Errors(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	span := uint64(1)
+	for i := 0; i < 99; i++ {
+		record(span, "GET /fast", time.Millisecond, 200)
+		span++
+	}
+	record(span, "GET /fast", 500*time.Millisecond, 200)
+	span++
+	for i := 0; i < 10; i++ {
+		status := 200
+		if i%2 == 0 {
+			status = 500
+		}
+		record(span, "GET /slow", 100*time.Millisecond, status)
+		span++
+	}
+
+	// The spans must still reach the underlying collector.
+	if _, err := ms.Trace(1); err != nil {
+		t.Fatal(err)
+	}
+
+	top := agg.Top(0, time.Minute, ByP99)
+	if len(top) != 2 {
+		t.Fatalf("got %d span names, want 2: %+v", len(top), top)
+	}
+	slow, fast := top[0], top[1]
+	if slow.Name != "GET /slow" || fast.Name != "GET /fast" {
+		t.Fatalf("got order %q, %q, want GET /slow first", slow.Name, fast.Name)
+	}
+	if slow.Count != 10 || slow.Errors != 5 || slow.ErrorRate != 0.5 {
+		t.Errorf("got GET /slow %+v, want 10 spans with 5 errors", slow)
+	}
+	if fast.Count != 100 || fast.Errors != 0 {
+		t.Errorf("got GET /fast %+v, want 100 spans with no errors", fast)
+	}
+	within := func(got, want time.Duration) bool {
+		return got >= want && float64(got) <= float64(want)*latencyGrowth
+	}
+	if !within(fast.P50, time.Millisecond) || !within(fast.P99, time.Millisecond) {
+		t.Errorf("got GET /fast p50 %s, p99 %s, want ~1ms", fast.P50, fast.P99)
+	}
+	if !within(slow.P99, 100*time.Millisecond) {
+		t.Errorf("got GET /slow p99 %s, want ~100ms", slow.P99)
+	}
+
+	if top := agg.Top(1, time.Minute, ByErrorRate); len(top) != 1 || top[0].Name != "GET /slow" {
+		t.Errorf("got top by error rate %+v, want GET /slow", top)
+	}
+}
+
+func TestAggregator_pending(t *testing.T) {
+	agg := &Aggregator{Collector: appdash.NewMemoryStore(), MaxPending: 1}
+
+	// A span whose name is never collected is counted as "unknown" once
+	// it is evicted.
+	start := time.Unix(100, 0)
+	for span := appdash.ID(1); span <= 2; span++ {
+		rec := appdash.NewRecorder(appdash.SpanID{Trace: 1, Span: span}, agg)
+		rec.Event(httptrace.ServerEvent{ServerRecv: start, ServerSend: start.Add(time.Second)})
+	}
+	top := agg.Top(0, time.Minute, ByP99)
+	if len(top) != 1 || top[0].Name != "unknown" || top[0].Count != 1 {
+		t.Errorf("got %+v, want 1 unknown span", top)
+	}
+}
+
+func TestAggregator_ignored(t *testing.T) {
+	agg := &Aggregator{Collector: appdash.NewMemoryStore()}
+	record := func(d time.Duration, status int, class string) {
+		rec := appdash.NewRecorder(appdash.NewRootSpanID(), agg)
+		rec.Name("GET /foo")
+		start := time.Unix(100, 0)
+		rec.Event(httptrace.ServerEvent{
+			Response:   httptrace.ResponseInfo{StatusCode: status},
+			Class:      class,
+			ServerRecv: start,
+			ServerSend: start.Add(d),
+		})
+		if errs := rec.Errors(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	record(time.Millisecond, 200, "")
+	record(time.Millisecond, 500, "")
+	want := agg.Top(0, time.Minute, ByP99)
+
+	// Ignored spans (e.g., slow or failing health checks) must not move
+	// the count, the latencies or the error rate.
+	for i := 0; i < 10; i++ {
+		record(time.Second, 503, httptrace.ClassIgnore)
+	}
+	if got := agg.Top(0, time.Minute, ByP99); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v with ignored spans, want %+v", got, want)
+	}
+	if len(want) != 1 || want[0].Count != 2 || want[0].ErrorRate != 0.5 {
+		t.Errorf("got %+v, want 2 spans with an error rate of 0.5", want)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	for _, d := range []time.Duration{0, time.Microsecond, time.Hour, 100 * time.Hour} {
+		h.add(d, false)
+	}
+	if got := h.percentile(0.25); got != time.Microsecond {
+		t.Errorf("got p25 %s, want 1µs", got)
+	}
+	if got, max := h.percentile(1), latencyBucketBound(latencyBuckets-1); got != max {
+		t.Errorf("got p100 %s, want the largest bucket bound %s", got, max)
+	}
+}
+
+func TestAggregator_Between(t *testing.T) {
+	agg := &Aggregator{Collector: appdash.NewMemoryStore()}
+	rec := appdash.NewRecorder(appdash.NewRootSpanID(), agg)
+	rec.Name("GET /foo")
+	start := time.Unix(100, 0)
+	rec.Event(httptrace.ServerEvent{ServerRecv: start, ServerSend: start.Add(time.Second)})
+
+	now := time.Now()
+	if got := agg.Between(now.Add(-time.Minute), now.Add(time.Minute)); len(got) != 1 || got[0].Name != "GET /foo" {
+		t.Errorf("got %+v, want GET /foo", got)
+	}
+	if got := agg.Between(now.Add(-time.Hour), now.Add(-30*time.Minute)); len(got) != 0 {
+		t.Errorf("got %+v in an earlier window, want none", got)
+	}
+}
+
+func TestAggregator_Oldest(t *testing.T) {
+	agg := &Aggregator{Collector: appdash.NewMemoryStore(), Window: time.Hour}
+	before := time.Now()
+	if got := agg.Oldest(); got.Before(before) {
+		t.Errorf("got Oldest %s before anything was collected, want now", got)
+	}
+
+	rec := appdash.NewRecorder(appdash.NewRootSpanID(), agg)
+	rec.Name("GET /foo")
+	if got, want := agg.Oldest(), before.Truncate(time.Minute); !got.Equal(want) {
+		t.Errorf("got Oldest %s, want the first collection %s", got, want)
+	}
+
+	agg.since = before.Add(-2 * time.Hour)
+	now := time.Now()
+	if got := agg.Oldest(); got.Before(now.Add(-time.Hour-time.Minute)) || got.After(now.Add(-time.Hour)) {
+		t.Errorf("got Oldest %s, want the start of the window", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	before := []NameStats{
+		{Name: "a", Count: 10, P99: 10 * time.Millisecond},
+		{Name: "b", Count: 10, Errors: 1, ErrorRate: 0.1, P99: 50 * time.Millisecond},
+		{Name: "gone", Count: 1, P99: time.Second},
+	}
+	after := []NameStats{
+		{Name: "a", Count: 10, P99: 40 * time.Millisecond},
+		{Name: "b", Count: 10, Errors: 5, ErrorRate: 0.5, P99: 50 * time.Millisecond},
+		{Name: "new", Count: 1, P99: 20 * time.Millisecond},
+	}
+
+	names := func(deltas []Delta) (names []string) {
+		for _, d := range deltas {
+			names = append(names, d.Name)
+		}
+		return names
+	}
+	deltas := Compare(before, after, ByP99)
+	if got, want := names(deltas), []string{"a", "new", "b", "gone"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got order %q by p99, want %q", got, want)
+	}
+	if d := deltas[0]; d.P99 != 30*time.Millisecond || d.Before.P99 != 10*time.Millisecond {
+		t.Errorf("got delta %+v, want p99 +30ms", d)
+	}
+	if d := deltas[3]; d.After.Count != 0 || d.P99 != -time.Second {
+		t.Errorf("got delta %+v, want a removed span name", d)
+	}
+
+	deltas = Compare(before, after, ByErrorRate)
+	if got, want := names(deltas), []string{"b", "a", "gone", "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got order %q by error rate, want %q", got, want)
+	}
+	if d := deltas[0]; d.ErrorRate < 0.39 || d.ErrorRate > 0.41 {
+		t.Errorf("got error rate delta %v, want 0.4", d.ErrorRate)
+	}
+}
+
+func TestAggregator_Series(t *testing.T) {
+	agg := &Aggregator{Collector: appdash.NewMemoryStore()}
+	start := time.Unix(100, 0)
+	for _, d := range []time.Duration{time.Millisecond, 5 * time.Millisecond, 2 * time.Second} {
+		rec := appdash.NewRecorder(appdash.NewRootSpanID(), agg)
+		rec.Name("GET /foo")
+		rec.Event(httptrace.ServerEvent{ServerRecv: start, ServerSend: start.Add(d)})
+	}
+	deployed := time.Now()
+	if _, err := appdash.RecordDeploy(agg, "web", "v2", deployed); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	points := agg.Series(now.Add(-time.Minute), now.Add(time.Minute))
+	if len(points) != 1 {
+		t.Fatalf("got %d points, want 1: %+v", len(points), points)
+	}
+	if p := points[0]; p.Count != 3 || !reflect.DeepEqual(p.Heat, []int64{1, 1, 0, 0, 1, 0}) {
+		t.Errorf("got point %+v, want 3 spans in the 1ms, 10ms and 10s bands", p)
+	}
+
+	deploys := agg.Deploys(now.Add(-time.Minute), now.Add(time.Minute))
+	if len(deploys) != 1 || deploys[0].Service != "web" || deploys[0].Version != "v2" || !deploys[0].Time.Equal(deployed) {
+		t.Errorf("got deploys %+v, want web v2 at %s", deploys, deployed)
+	}
+}
@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// An InfluxExporter aggregates the spans held by a Queryer into per-span-name
+// latency, throughput and error points and writes them to W using the
+// InfluxDB line protocol.
+//
+// Each point has the span name as its "span" tag and the following fields:
+//
+//	count   number of spans that finished in the window, except ignored
+//	        ones (see IsIgnored)
+//	errors  number of those spans that failed (see IsError)
+//	rate    spans per second over the window
+//	mean, p50, p90, p99, max   span latency in milliseconds
+type InfluxExporter struct {
+	// Queryer is the source of the traces to aggregate.
+	Queryer appdash.Queryer
+
+	// W is where points are written to, for example a writer created by
+	// NewInfluxDBWriter. All of the points for a single window are written
+	// using a single call to W.Write.
+	W io.Writer
+
+	// Measurement is the measurement name of the points. If empty,
+	// "appdash" is used.
+	Measurement string
+
+	// Tags are additional tags added to every point (e.g. the host or
+	// environment name).
+	Tags map[string]string
+
+	// IsError, if non-nil, is called to determine whether a span failed. If
+	// nil, the package-level IsError function is used.
+	IsError func([]appdash.Event) bool
+
+	// Log is used by ExportEvery to log failed exports. If nil, the
+	// standard logger is used.
+	Log *log.Logger
+}
+
+// Export writes a point for each span name with spans that finished in the
+// time window [start, end). The points are timestamped with end. If no spans
+// finished in the window, nothing is written.
+func (e *InfluxExporter) Export(start, end time.Time) error {
+	traces, err := e.Queryer.Traces()
+	if err != nil {
+		return err
+	}
+
+	byName := map[string]*stats{}
+	err = walkSpans(traces, func(s *appdash.Span) error {
+		m, ok, err := newSpanMetric(s, e.IsError)
+		if err != nil || !ok || m.Ignored {
+			return err
+		}
+		if m.End.Before(start) || !m.End.Before(end) {
+			return nil
+		}
+		st, present := byName[m.Name]
+		if !present {
+			st = &stats{}
+			byName[m.Name] = st
+		}
+		st.add(m)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(byName) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	window := end.Sub(start).Seconds()
+	for _, name := range names {
+		st := byName[name]
+		st.finish()
+		e.writeTags(&buf, name)
+		fmt.Fprintf(&buf, " count=%di,errors=%di", st.Count, st.Errors)
+		if window > 0 {
+			fmt.Fprintf(&buf, ",rate=%s", formatFloat(float64(st.Count)/window))
+		}
+		fmt.Fprintf(&buf, ",mean=%s,p50=%s,p90=%s,p99=%s,max=%s %d\n",
+			formatFloat(msec(st.mean())),
+			formatFloat(msec(st.percentile(0.5))),
+			formatFloat(msec(st.percentile(0.9))),
+			formatFloat(msec(st.percentile(0.99))),
+			formatFloat(msec(st.percentile(1))),
+			end.UnixNano(),
+		)
+	}
+	_, err = e.W.Write(buf.Bytes())
+	return err
+}
+
+// ExportEvery exports the spans that finished during each interval, forever.
+// Failed exports are logged and retried at the next interval, together with
+// the spans that finished since, so that a transient InfluxDB outage does not
+// stop the export or leave a gap in it.
+func (e *InfluxExporter) ExportEvery(interval time.Duration) {
+	start := time.Now()
+	for {
+		time.Sleep(interval)
+
+		end := time.Now()
+		if err := e.Export(start, end); err != nil {
+			e.log().Printf("InfluxDB export of spans since %s failed (will retry): %s", start.Format(time.RFC3339), err)
+			continue
+		}
+		start = end
+	}
+}
+
+func (e *InfluxExporter) log() *log.Logger {
+	if e.Log == nil {
+		return log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return e.Log
+}
+
+// writeTags writes the measurement name and the sorted tag set of a point
+// for the given span name.
+func (e *InfluxExporter) writeTags(buf *bytes.Buffer, spanName string) {
+	measurement := e.Measurement
+	if measurement == "" {
+		measurement = "appdash"
+	}
+	buf.WriteString(measurementEscaper.Replace(measurement))
+
+	tags := make(map[string]string, len(e.Tags)+1)
+	for k, v := range e.Tags {
+		tags[k] = v
+	}
+	tags["span"] = spanName
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // InfluxDB recommends sorted tags
+	for _, k := range keys {
+		if tags[k] == "" {
+			continue // empty tag values are invalid
+		}
+		fmt.Fprintf(buf, ",%s=%s", tagEscaper.Replace(k), tagEscaper.Replace(tags[k]))
+	}
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// NewInfluxDBWriter returns a writer that sends each write, which must consist
+// of whole lines in the line protocol, to the InfluxDB HTTP API at addr (e.g.
+// "http://localhost:8086") for storage in the database db. Writes that take
+// longer than InfluxDBWriteTimeout fail.
+func NewInfluxDBWriter(addr, db string) io.Writer {
+	return &influxDBWriter{
+		url: strings.TrimSuffix(addr, "/") + "/write?" + url.Values{
+			"db":        []string{db},
+			"precision": []string{"ns"},
+		}.Encode(),
+		client: &http.Client{Timeout: InfluxDBWriteTimeout},
+	}
+}
+
+// InfluxDBWriteTimeout is how long a writer created by NewInfluxDBWriter
+// waits for InfluxDB to accept a write.
+const InfluxDBWriteTimeout = 30 * time.Second
+
+type influxDBWriter struct {
+	url    string
+	client *http.Client
+}
+
+func (w *influxDBWriter) Write(p []byte) (int, error) {
+	resp, err := w.client.Post(w.url, "text/plain; charset=utf-8", bytes.NewReader(p))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("InfluxDB write: HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return len(p), nil
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/httptrace"
+	"sourcegraph.com/sourcegraph/appdash/sqltrace"
+)
+
+func TestInfluxExporter(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	start := time.Unix(100, 0)
+
+	collect := func(id appdash.SpanID, name string, e appdash.Event) {
+		rec := appdash.NewRecorder(id, ms)
+		rec.Name(name)
+		rec.Event(e)
+		if errs := rec.Errors(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	root := appdash.SpanID{Trace: 1, Span: 1}
+	collect(root, "GET /foo", httptrace.ServerEvent{
+		Response:   httptrace.ResponseInfo{StatusCode: 500},
+		ServerRecv: start,
+		ServerSend: start.Add(40 * time.Millisecond),
+	})
+	collect(appdash.SpanID{Trace: 1, Span: 2, Parent: 1}, "query", sqltrace.SQLEvent{
+		ClientSend: start,
+		ClientRecv: start.Add(10 * time.Millisecond),
+	})
+	collect(appdash.SpanID{Trace: 1, Span: 3, Parent: 1}, "query", sqltrace.SQLEvent{
+		ClientSend: start,
+		ClientRecv: start.Add(30 * time.Millisecond),
+	})
+
+	// Ignored (e.g., a health check); must not be counted.
+	collect(appdash.SpanID{Trace: 3, Span: 5}, "GET /foo", httptrace.ServerEvent{
+		Response:   httptrace.ResponseInfo{StatusCode: 503},
+		Class:      httptrace.ClassIgnore,
+		ServerRecv: start,
+		ServerSend: start.Add(5 * time.Second),
+	})
+
+	// Finishes after the window; must not be counted.
+	collect(appdash.SpanID{Trace: 2, Span: 4}, "GET /foo", httptrace.ServerEvent{
+		Response:   httptrace.ResponseInfo{StatusCode: 200},
+		ServerRecv: start,
+		ServerSend: start.Add(20 * time.Second),
+	})
+
+	var buf bytes.Buffer
+	e := &InfluxExporter{
+		Queryer: ms,
+		W:       &buf,
+		Tags:    map[string]string{"host": "a b"},
+	}
+	if err := e.Export(start, start.Add(10*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `appdash,host=a\ b,span=GET\ /foo count=1i,errors=1i,rate=0.1,mean=40,p50=40,p90=40,p99=40,max=40 110000000000
+appdash,host=a\ b,span=query count=2i,errors=0i,rate=0.2,mean=20,p50=10,p90=30,p99=30,max=30 110000000000
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got points\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInfluxExporter_empty(t *testing.T) {
+	var buf bytes.Buffer
+	e := &InfluxExporter{Queryer: appdash.NewMemoryStore(), W: &buf}
+	if err := e.Export(time.Unix(0, 0), time.Unix(10, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got points %q, want none", buf.String())
+	}
+}
+
+func TestStats_percentile(t *testing.T) {
+	s := &stats{}
+	for _, ms := range []int{4, 1, 3, 2} {
+		s.add(spanMetric{Duration: time.Duration(ms) * time.Millisecond})
+	}
+	s.finish()
+	// Nearest-rank: the p55 of 4 values is the ceil(2.2) = 3rd.
+	for p, want := range map[float64]time.Duration{0: time.Millisecond, 0.25: time.Millisecond, 0.55: 3 * time.Millisecond, 1: 4 * time.Millisecond} {
+		if got := s.percentile(p); got != want {
+			t.Errorf("got p%v %s, want %s", p*100, got, want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package metrics derives time-series metrics (latency, throughput and error
+// counts) from the spans collected by appdash, so that they can be sent to
+// existing metrics systems such as InfluxDB and statsd.
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/httptrace"
+)
+
+// IsError is the default function used to determine whether a span's events
+// describe a failed operation. A span is considered failed if it contains an
+// HTTP server or client event classified as httptrace.ClassFailure. Events
+// recorded without a class are failures if they have a 5xx status code, or
+// if they are HTTP client events whose request failed entirely (status code
+// -1).
+func IsError(events []appdash.Event) bool {
+	for _, e := range events {
+		switch e := e.(type) {
+		case httptrace.ServerEvent:
+			if e.Class != "" {
+				if e.Class == httptrace.ClassFailure {
+					return true
+				}
+			} else if e.Response.StatusCode >= 500 {
+				return true
+			}
+		case httptrace.ClientEvent:
+			if e.Class != "" {
+				if e.Class == httptrace.ClassFailure {
+					return true
+				}
+			} else if e.Response.StatusCode >= 500 || e.Response.StatusCode < 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsIgnored reports whether a span's events describe an operation that is
+// left out of metrics altogether: one with an HTTP server or client event
+// classified as httptrace.ClassIgnore (e.g., a health check probe). Ignored
+// spans count toward neither the number of spans nor their latencies and
+// error rates.
+func IsIgnored(events []appdash.Event) bool {
+	for _, e := range events {
+		switch e := e.(type) {
+		case httptrace.ServerEvent:
+			if e.Class == httptrace.ClassIgnore {
+				return true
+			}
+		case httptrace.ClientEvent:
+			if e.Class == httptrace.ClassIgnore {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spanMetric is the metrics-relevant information about a single span.
+type spanMetric struct {
+	Name     string
+	Duration time.Duration
+	End      time.Time
+	Error    bool
+	Ignored  bool // see IsIgnored
+}
+
+// newSpanMetric returns the metric information about the span s. Spans
+// without any TimespanEvent have no meaningful duration, and ok is false for
+// them. Ignored spans (see IsIgnored) have m.Ignored set and must not be
+// counted.
+func newSpanMetric(s *appdash.Span, isError func([]appdash.Event) bool) (m spanMetric, ok bool, err error) {
+	var events []appdash.Event
+	if err := appdash.UnmarshalEvents(s.Annotations, &events); err != nil {
+		return m, false, err
+	}
+
+	// Like the traceapp profile view, we use the largest timespan event the
+	// span has as its duration.
+	for _, ev := range events {
+		ts, isTimespan := ev.(appdash.TimespanEvent)
+		if !isTimespan {
+			continue
+		}
+		if d := ts.End().Sub(ts.Start()); !ok || d > m.Duration {
+			m.Duration = d
+			m.End = ts.End()
+			ok = true
+		}
+	}
+	if !ok {
+		return m, false, nil
+	}
+
+	m.Name = s.Name()
+	if m.Name == "" {
+		m.Name = "unknown"
+	}
+	if isError == nil {
+		isError = IsError
+	}
+	m.Error = isError(events)
+	m.Ignored = IsIgnored(events)
+	return m, true, nil
+}
+
+// walkSpans calls f for each span in the traces and their descendants.
+func walkSpans(traces []*appdash.Trace, f func(*appdash.Span) error) error {
+	for _, t := range traces {
+		if err := f(&t.Span); err != nil {
+			return err
+		}
+		if err := walkSpans(t.Sub, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stats is a set of aggregated span metrics.
+type stats struct {
+	Count, Errors int64
+	Durations     []time.Duration // sorted once finish is called
+}
+
+func (s *stats) add(m spanMetric) {
+	s.Count++
+	if m.Error {
+		s.Errors++
+	}
+	s.Durations = append(s.Durations, m.Duration)
+}
+
+// finish sorts the durations; it must be called before percentile or mean.
+func (s *stats) finish() {
+	sort.Sort(durations(s.Durations))
+}
+
+// percentile returns the p-th (0 <= p <= 1) percentile duration using the
+// nearest-rank method.
+func (s *stats) percentile(p float64) time.Duration {
+	if len(s.Durations) == 0 {
+		return 0
+	}
+	return s.Durations[nearestRank(p, int64(len(s.Durations)))-1]
+}
+
+// nearestRank returns the (1-based) rank of the p-th (0 <= p <= 1)
+// percentile of n > 0 sorted values, using the nearest-rank method.
+func nearestRank(p float64, n int64) int64 {
+	rank := int64(math.Ceil(p * float64(n)))
+	if rank < 1 {
+		rank = 1
+	} else if rank > n {
+		rank = n
+	}
+	return rank
+}
+
+func (s *stats) mean() time.Duration {
+	if len(s.Durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range s.Durations {
+		sum += d
+	}
+	return sum / time.Duration(len(s.Durations))
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// msec returns d in (fractional) milliseconds, which is the unit that all
+// latencies are reported in.
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
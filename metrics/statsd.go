@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// maxPendingNames is the maximum number of span names that a StatsdCollector
+// remembers for spans whose timespan event has not been collected yet.
+const maxPendingNames = 10000
+
+// A StatsdCollector is a Collector that forwards spans to an underlying
+// Collector and, in addition, sends a timing and a counter metric for each
+// span with a timespan event to a statsd server over UDP.
+//
+// In plain statsd mode the span name and status ("ok" or "error") are part of
+// the metric names:
+//
+//	appdash.<name>.<status>.duration:12.5|ms
+//	appdash.<name>.<status>.count:1|c
+//
+// In Dogstatsd mode they are sent as tags instead:
+//
+//	appdash.span.duration:12.5|ms|#span:<name>,status:<status>
+//	appdash.span.count:1|c|#span:<name>,status:<status>
+//
+// Sending metrics is best-effort: failures to send never cause Collect to
+// return an error.
+type StatsdCollector struct {
+	// Collector is the underlying collector that spans are sent to.
+	appdash.Collector
+
+	// Addr is the UDP address of the statsd server, e.g. "localhost:8125".
+	Addr string
+
+	// Prefix is the prefix of all metric names. If empty, "appdash" is
+	// used.
+	Prefix string
+
+	// Dogstatsd is whether to send the span name and status as Dogstatsd
+	// tags instead of as part of the metric names.
+	Dogstatsd bool
+
+	// IsError, if non-nil, is called to determine whether a span failed. If
+	// nil, the package-level IsError function is used.
+	IsError func([]appdash.Event) bool
+
+	// Debug is whether to log errors sending metrics.
+	Debug bool
+
+	mu    sync.Mutex                // guards conn and names
+	conn  net.Conn                  // UDP connection to the statsd server
+	names map[appdash.SpanID]string // names of spans awaiting a timespan event
+}
+
+// Collect implements the Collector interface by forwarding the span to the
+// underlying collector and sending its metrics to the statsd server.
+func (sc *StatsdCollector) Collect(id appdash.SpanID, anns ...appdash.Annotation) error {
+	err := sc.Collector.Collect(id, anns...)
+	sc.record(id, anns)
+	return err
+}
+
+// record sends the metrics for a span if anns contains a timespan event. The
+// span's name is usually recorded separately from (and before) its events, so
+// names are remembered until the timespan event arrives.
+func (sc *StatsdCollector) record(id appdash.SpanID, anns appdash.Annotations) {
+	span := &appdash.Span{ID: id, Annotations: anns}
+	m, ok, err := newSpanMetric(span, sc.IsError)
+	if err != nil {
+		sc.debugf("unmarshal events of span %v: %s", id, err)
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.names == nil {
+		sc.names = map[appdash.SpanID]string{}
+	}
+	name := span.Name()
+	if !ok {
+		if name != "" {
+			if len(sc.names) >= maxPendingNames {
+				// Spans that never get a timespan event would otherwise
+				// be remembered forever.
+				sc.names = map[appdash.SpanID]string{}
+			}
+			sc.names[id] = name
+		}
+		return
+	}
+	if name == "" {
+		if name = sc.names[id]; name == "" {
+			name = "unknown"
+		}
+	}
+	delete(sc.names, id)
+	if m.Ignored {
+		return
+	}
+	m.Name = name
+
+	if err := sc.send(sc.format(m)); err != nil {
+		sc.debugf("send: %s", err)
+	}
+}
+
+// format returns the statsd packet for the span metric m.
+func (sc *StatsdCollector) format(m spanMetric) []byte {
+	prefix := sc.Prefix
+	if prefix == "" {
+		prefix = "appdash"
+	}
+	status := "ok"
+	if m.Error {
+		status = "error"
+	}
+
+	var buf bytes.Buffer
+	if sc.Dogstatsd {
+		tags := fmt.Sprintf("|#span:%s,status:%s", dogstatsdTagEscaper.Replace(m.Name), status)
+		fmt.Fprintf(&buf, "%s.span.duration:%s|ms%s\n", prefix, formatFloat(msec(m.Duration)), tags)
+		fmt.Fprintf(&buf, "%s.span.count:1|c%s", prefix, tags)
+	} else {
+		name := fmt.Sprintf("%s.%s.%s", prefix, statsdName(m.Name), status)
+		fmt.Fprintf(&buf, "%s.duration:%s|ms\n", name, formatFloat(msec(m.Duration)))
+		fmt.Fprintf(&buf, "%s.count:1|c", name)
+	}
+	return buf.Bytes()
+}
+
+// send sends a packet to the statsd server, connecting first if needed. It
+// must be called with sc.mu held.
+func (sc *StatsdCollector) send(p []byte) error {
+	if sc.conn == nil {
+		c, err := net.Dial("udp", sc.Addr)
+		if err != nil {
+			return err
+		}
+		sc.conn = c
+	}
+	if _, err := sc.conn.Write(p); err != nil {
+		sc.conn.Close()
+		sc.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection to the statsd server.
+func (sc *StatsdCollector) Close() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.conn != nil {
+		err := sc.conn.Close()
+		sc.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (sc *StatsdCollector) debugf(format string, args ...interface{}) {
+	if sc.Debug {
+		log.Printf("StatsdCollector[%s]: "+format, append([]interface{}{sc.Addr}, args...)...)
+	}
+}
+
+var dogstatsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "\n", "_")
+
+// statsdName returns s with all characters that are not valid in a statsd
+// metric name segment replaced by underscores.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/httptrace"
+)
+
+func TestStatsdCollector(t *testing.T) {
+	tests := []struct {
+		dogstatsd bool
+		want      string
+	}{
+		{
+			want: "appdash.GET__foo.error.duration:40|ms\nappdash.GET__foo.error.count:1|c",
+		},
+		{
+			dogstatsd: true,
+			want:      "appdash.span.duration:40|ms|#span:GET /foo,status:error\nappdash.span.count:1|c|#span:GET /foo,status:error",
+		},
+	}
+	for _, test := range tests {
+		l, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ms := appdash.NewMemoryStore()
+		sc := &StatsdCollector{
+			Collector: ms,
+			Addr:      l.LocalAddr().String(),
+			Dogstatsd: test.dogstatsd,
+		}
+		rec := appdash.NewRecorder(appdash.SpanID{Trace: 1, Span: 2}, sc)
+		rec.Name("GET /foo")
+		start := time.Unix(100, 0)
+		rec.Event(httptrace.ServerEvent{
+			Response:   httptrace.ResponseInfo{StatusCode: 503},
+			ServerRecv: start,
+			ServerSend: start.Add(40 * time.Millisecond),
+		})
+		if errs := rec.Errors(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+
+		// The spans must still reach the underlying collector.
+		if _, err := ms.Trace(1); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1024)
+		l.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := l.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != test.want {
+			t.Errorf("dogstatsd=%v: got packet %q, want %q", test.dogstatsd, got, test.want)
+		}
+		if len(sc.names) != 0 {
+			t.Errorf("dogstatsd=%v: got %d pending names, want 0", test.dogstatsd, len(sc.names))
+		}
+		sc.Close()
+		l.Close()
+	}
+}
+
+func TestStatsdCollector_ignored(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	sc := &StatsdCollector{Collector: appdash.NewMemoryStore(), Addr: l.LocalAddr().String()}
+	defer sc.Close()
+
+	rec := appdash.NewRecorder(appdash.SpanID{Trace: 1, Span: 2}, sc)
+	rec.Name("GET /healthz")
+	start := time.Unix(100, 0)
+	rec.Event(httptrace.ServerEvent{
+		Response:   httptrace.ResponseInfo{StatusCode: 503},
+		Class:      httptrace.ClassIgnore,
+		ServerRecv: start,
+		ServerSend: start.Add(40 * time.Millisecond),
+	})
+	if errs := rec.Errors(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	buf := make([]byte, 1024)
+	l.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := l.ReadFrom(buf); err == nil {
+		t.Errorf("got packet %q for an ignored span, want none", buf[:n])
+	}
+	if len(sc.names) != 0 {
+		t.Errorf("got %d pending names, want 0", len(sc.names))
+	}
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// StoreMetrics is an appdash.StoreInstrumentation that counts store
+// operations and their errors and durations. It is an http.Handler that
+// serves the counts in the Prometheus text exposition format, so it can be
+// scraped by Prometheus:
+//
+//	sm := &metrics.StoreMetrics{}
+//	store := &appdash.InstrumentedStore{Store: appdash.NewMemoryStore(), Instrumentation: sm}
+//	http.Handle("/metrics", sm)
+//
+// The following metrics are served, with an "op" label for queries:
+//
+//	appdash_store_writes_total, appdash_store_write_errors_total, appdash_store_write_seconds
+//	appdash_store_queries_total, appdash_store_query_errors_total, appdash_store_query_seconds
+//	appdash_store_evictions_total, appdash_store_eviction_errors_total, appdash_store_eviction_seconds
+//	appdash_store_evicted_traces_total
+//
+// The *_seconds metrics are summaries (with only _sum and _count).
+type StoreMetrics struct {
+	mu        sync.Mutex
+	writes    opStats
+	queries   map[string]*opStats // by op
+	evictions opStats
+	evicted   int64 // number of traces evicted
+}
+
+var _ appdash.StoreInstrumentation = (*StoreMetrics)(nil)
+
+// opStats counts the operations of a single kind.
+type opStats struct {
+	Count, Errors int64
+	Duration      time.Duration
+}
+
+func (s *opStats) add(d time.Duration, err error) {
+	s.Count++
+	s.Duration += d
+	if err != nil {
+		s.Errors++
+	}
+}
+
+// OnWrite implements appdash.StoreInstrumentation.
+func (m *StoreMetrics) OnWrite(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes.add(d, err)
+}
+
+// OnQuery implements appdash.StoreInstrumentation.
+func (m *StoreMetrics) OnQuery(op string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queries == nil {
+		m.queries = map[string]*opStats{}
+	}
+	s, ok := m.queries[op]
+	if !ok {
+		s = &opStats{}
+		m.queries[op] = s
+	}
+	s.add(d, err)
+}
+
+// OnEvict implements appdash.StoreInstrumentation.
+func (m *StoreMetrics) OnEvict(n int, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evictions.add(d, err)
+	if err == nil {
+		m.evicted += int64(n)
+	}
+}
+
+// ServeHTTP implements http.Handler by serving the metrics in the
+// Prometheus text exposition format.
+func (m *StoreMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(m.exposition())
+}
+
+func (m *StoreMetrics) exposition() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+	writeOpStats(&buf, "write", "writes", "annotations collected by the store", map[string]*opStats{"": &m.writes})
+	writeOpStats(&buf, "query", "queries", "queries of the store", m.queries)
+	writeOpStats(&buf, "eviction", "evictions", "deletions of traces from the store", map[string]*opStats{"": &m.evictions})
+	fmt.Fprintf(&buf, "# HELP appdash_store_evicted_traces_total Number of traces deleted from the store.\n")
+	fmt.Fprintf(&buf, "# TYPE appdash_store_evicted_traces_total counter\n")
+	fmt.Fprintf(&buf, "appdash_store_evicted_traces_total %d\n", m.evicted)
+	return buf.Bytes()
+}
+
+// writeOpStats writes the metrics for a kind of operation. The keys of
+// byOp are the values of the "op" label (or "" for no label).
+func writeOpStats(buf *bytes.Buffer, singular, plural, help string, byOp map[string]*opStats) {
+	ops := make([]string, 0, len(byOp))
+	for op := range byOp {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	labels := func(op string) string {
+		if op == "" {
+			return ""
+		}
+		return fmt.Sprintf(`{op="%s"}`, op)
+	}
+
+	metric := func(name, typ, help string, value func(s *opStats) string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, op := range ops {
+			fmt.Fprintf(buf, "%s%s %s\n", name, labels(op), value(byOp[op]))
+		}
+	}
+	metric("appdash_store_"+plural+"_total", "counter", "Number of "+help+".",
+		func(s *opStats) string { return fmt.Sprint(s.Count) })
+	metric("appdash_store_"+singular+"_errors_total", "counter", "Number of failed "+help+".",
+		func(s *opStats) string { return fmt.Sprint(s.Errors) })
+
+	name := "appdash_store_" + singular + "_seconds"
+	fmt.Fprintf(buf, "# HELP %s Duration of %s.\n# TYPE %s summary\n", name, help, name)
+	for _, op := range ops {
+		s := byOp[op]
+		fmt.Fprintf(buf, "%s_sum%s %s\n", name, labels(op), formatFloat(s.Duration.Seconds()))
+		fmt.Fprintf(buf, "%s_count%s %d\n", name, labels(op), s.Count)
+	}
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreMetrics(t *testing.T) {
+	sm := &StoreMetrics{}
+	sm.OnWrite(time.Second, nil)
+	sm.OnWrite(500*time.Millisecond, errors.New("x"))
+	sm.OnQuery("Traces", 2*time.Second, nil)
+	sm.OnQuery("Trace", time.Second, nil)
+	sm.OnEvict(3, 250*time.Millisecond, nil)
+
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, nil)
+
+	want := []string{
+		"appdash_store_writes_total 2",
+		"appdash_store_write_errors_total 1",
+		"appdash_store_write_seconds_sum 1.5",
+		"appdash_store_write_seconds_count 2",
+		`appdash_store_queries_total{op="Trace"} 1`,
+		`appdash_store_queries_total{op="Traces"} 1`,
+		`appdash_store_query_seconds_sum{op="Traces"} 2`,
+		"appdash_store_evictions_total 1",
+		"appdash_store_eviction_seconds_sum 0.25",
+		"appdash_store_evicted_traces_total 3",
+		"# TYPE appdash_store_write_seconds summary",
+	}
+	lines := map[string]bool{}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		lines[line] = true
+	}
+	for _, line := range want {
+		if !lines[line] {
+			t.Errorf("missing line %q in output:\n%s", line, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package appdash
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// OverheadKey is the key of the annotation that a Recorder adds to each
+// event it records while overhead measurement is enabled (see
+// SetMeasureOverhead). Its value is the time, in milliseconds, that the
+// Recorder has spent marshaling and collecting the span's events so far,
+// up to and including marshaling the event it accompanies. The largest
+// value on a span is thus (nearly) its total overhead.
+const OverheadKey = "Recorder.Overhead"
+
+// measureOverhead is whether overhead measurement is enabled globally
+// (see SetMeasureOverhead).
+var measureOverhead int32
+
+// overhead holds the counts returned by Overhead. Its fields are
+// accessed atomically.
+var overhead struct {
+	events, marshal, collect int64
+}
+
+// SetMeasureOverhead enables or disables overhead measurement
+// globally. While it is enabled, Recorders time how long they spend
+// marshaling events and handing them to their collectors (which, for a
+// ChunkedCollector, is the time spent queueing them), annotate each
+// event with the span's overhead so far (see OverheadKey), and add the
+// times to the totals returned by Overhead. It is disabled by default.
+func SetMeasureOverhead(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&measureOverhead, v)
+}
+
+// MeasuringOverhead reports whether overhead measurement is enabled
+// globally.
+func MeasuringOverhead() bool {
+	return atomic.LoadInt32(&measureOverhead) == 1
+}
+
+// RecorderOverhead is the time that Recorders in this process have
+// spent recording events while overhead measurement was enabled.
+type RecorderOverhead struct {
+	// Events is the number of events recorded.
+	Events int64
+
+	// Marshal is the total time spent marshaling events into
+	// annotations.
+	Marshal time.Duration
+
+	// Collect is the total time spent in collectors' Collect methods.
+	Collect time.Duration
+}
+
+// PerEvent returns the mean overhead of recording an event, or zero if
+// no events were recorded.
+func (o RecorderOverhead) PerEvent() time.Duration {
+	if o.Events == 0 {
+		return 0
+	}
+	return (o.Marshal + o.Collect) / time.Duration(o.Events)
+}
+
+// Overhead returns the time that Recorders in this process have spent
+// recording events while overhead measurement was enabled (see
+// SetMeasureOverhead). Applications can export it to their metrics
+// systems, e.g.:
+//
+//	expvar.Publish("appdash.overhead", expvar.Func(func() interface{} { return appdash.Overhead() }))
+func Overhead() RecorderOverhead {
+	return RecorderOverhead{
+		Events:  atomic.LoadInt64(&overhead.events),
+		Marshal: time.Duration(atomic.LoadInt64(&overhead.marshal)),
+		Collect: time.Duration(atomic.LoadInt64(&overhead.collect)),
+	}
+}
+
+// measuredEvent records e as Event does, measuring the overhead of
+// doing so.
+func (r *Recorder) measuredEvent(e Event) {
+	start := time.Now()
+	as, err := MarshalEvent(e)
+	marshal := time.Since(start)
+	atomic.AddInt64(&overhead.marshal, int64(marshal))
+	atomic.AddInt64(&overhead.events, 1)
+	spanOverhead := time.Duration(atomic.AddInt64(&r.overhead, int64(marshal)))
+	if err != nil {
+		r.error("Event", err)
+		return
+	}
+
+	ms := float64(spanOverhead) / float64(time.Millisecond)
+	as = append(as, Annotation{Key: OverheadKey, Value: []byte(strconv.FormatFloat(ms, 'f', -1, 64))})
+	start = time.Now()
+	err = r.failsafeAnnotation(as...)
+	collect := time.Since(start)
+	atomic.AddInt64(&overhead.collect, int64(collect))
+	atomic.AddInt64(&r.overhead, int64(collect))
+	if err != nil {
+		r.error("Annotation", err)
+	}
+}
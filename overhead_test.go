@@ -0,0 +1,53 @@
+package appdash
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRecorder_measureOverhead(t *testing.T) {
+	var anns Annotations
+	r := NewRecorder(SpanID{1, 2, 3}, collectorFunc(func(_ SpanID, as ...Annotation) error {
+		anns = append(anns, as...)
+		return nil
+	}))
+
+	r.Msg("unmeasured")
+	if v := anns.get(OverheadKey); v != nil {
+		t.Errorf("got %s annotation %q while not measuring overhead", OverheadKey, v)
+	}
+
+	before := Overhead()
+	SetMeasureOverhead(true)
+	defer SetMeasureOverhead(false)
+	r.Msg("a")
+	r.Msg("b")
+
+	var values []float64
+	for _, a := range anns {
+		if a.Key == OverheadKey {
+			v, err := strconv.ParseFloat(string(a.Value), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+	}
+	if len(values) != 2 {
+		t.Fatalf("got %d %s annotations, want 2", len(values), OverheadKey)
+	}
+	if values[1] <= values[0] {
+		t.Errorf("got span overhead %v after %v, want it to grow", values[1], values[0])
+	}
+
+	after := Overhead()
+	if n := after.Events - before.Events; n != 2 {
+		t.Errorf("got %d more events, want 2", n)
+	}
+	if after.Marshal <= before.Marshal || after.Collect <= before.Collect {
+		t.Errorf("got overhead %+v after %+v, want marshal and collect times to grow", after, before)
+	}
+	if after.PerEvent() <= 0 {
+		t.Errorf("got PerEvent %v, want > 0", after.PerEvent())
+	}
+}
@@ -3,13 +3,46 @@ package appdash
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
+// disabled is whether recording is disabled globally (see SetEnabled).
+var disabled int32
+
+// SetEnabled enables or disables recording globally. While it is
+// disabled, Recorders and the collectors in this package return
+// immediately without marshaling events or sending anything, so that
+// libraries can ship with instrumentation that is dormant (and nearly
+// free) until an application enables it. Recording is enabled by
+// default.
+func SetEnabled(enabled bool) {
+	var v int32
+	if !enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&disabled, v)
+}
+
+// Enabled reports whether recording is enabled globally.
+func Enabled() bool {
+	return atomic.LoadInt32(&disabled) == 0
+}
+
 // A Recorder is associated with a span and records annotations on the
 // span by sending them to a collector.
 type Recorder struct {
 	SpanID // the span ID that annotations are about
 
+	// overhead is the time spent recording the span's events while
+	// overhead measurement is enabled, in nanoseconds. It is accessed
+	// atomically, so it is kept 64-bit aligned.
+	overhead int64
+
+	// Disabled is whether this recorder (and the children created from
+	// it after Disabled is set) record nothing, regardless of the global
+	// setting (see SetEnabled).
+	Disabled bool
+
 	collector Collector // the collector to send to
 
 	errors   []error    // errors since the last call to Errors
@@ -31,29 +64,59 @@ func NewRecorder(span SpanID, c Collector) *Recorder {
 // Child creates a new Recorder with the same collector and a new
 // child SpanID whose parent is this recorder's SpanID.
 func (r *Recorder) Child() *Recorder {
-	return NewRecorder(NewSpanID(r.SpanID), r.collector)
+	c := NewRecorder(NewSpanID(r.SpanID), r.collector)
+	c.Disabled = r.Disabled
+	return c
+}
+
+// Enabled reports whether the recorder records anything, i.e., whether
+// recording is enabled globally and the recorder is not Disabled.
+// Instrumentation that does expensive work to construct events can
+// check it first.
+func (r *Recorder) Enabled() bool {
+	return !r.Disabled && Enabled()
 }
 
 // Name sets the name of this span.
 func (r *Recorder) Name(name string) {
+	if !r.Enabled() {
+		return
+	}
 	r.Event(spanName{name})
 }
 
 // Msg records a Msg event (an event with a human-readable message) on
 // the span.
 func (r *Recorder) Msg(msg string) {
+	if !r.Enabled() {
+		return
+	}
 	r.Event(Msg(msg))
 }
 
 // Log records a Log event (an event with the current timestamp and a
 // human-readable message) on the span.
 func (r *Recorder) Log(msg string) {
+	if !r.Enabled() {
+		return
+	}
 	r.Event(Log(msg))
 }
 
 // Event records any event that implements the Event, TimespanEvent, or
 // TimestampedEvent interfaces.
+//
+// While the recorder is not enabled, Event returns immediately, but
+// constructing the event may still allocate; check Enabled first to
+// avoid that in hot paths.
 func (r *Recorder) Event(e Event) {
+	if !r.Enabled() {
+		return
+	}
+	if MeasuringOverhead() {
+		r.measuredEvent(e)
+		return
+	}
 	as, err := MarshalEvent(e)
 	if err != nil {
 		r.error("Event", err)
@@ -64,6 +127,9 @@ func (r *Recorder) Event(e Event) {
 
 // Annotation records raw annotations on the span.
 func (r *Recorder) Annotation(as ...Annotation) {
+	if !r.Enabled() {
+		return
+	}
 	if err := r.failsafeAnnotation(as...); err != nil {
 		r.error("Annotation", err)
 	}
@@ -88,3 +88,50 @@ func diffAnnotationsFromEvent(anns Annotations, e Event) (diff []string) {
 	}
 	return diff
 }
+
+func TestRecorder_disabled(t *testing.T) {
+	calledCollect := 0
+	c := collectorFunc(func(SpanID, ...Annotation) error {
+		calledCollect++
+		return nil
+	})
+
+	r := NewRecorder(SpanID{1, 2, 3}, c)
+	r.Disabled = true
+	r.Name("name")
+	r.Child().Msg("msg")
+	if calledCollect != 0 {
+		t.Errorf("got calledCollect %d for disabled recorder, want 0", calledCollect)
+	}
+
+	SetEnabled(false)
+	defer SetEnabled(true)
+	r = NewRecorder(SpanID{1, 2, 3}, c)
+	if allocs := testing.AllocsPerRun(100, func() {
+		r.Name("name")
+		r.Log("log")
+		r.Msg("msg")
+	}); allocs != 0 {
+		t.Errorf("got %.1f allocations while disabled, want 0", allocs)
+	}
+	if calledCollect != 0 {
+		t.Errorf("got calledCollect %d while disabled, want 0", calledCollect)
+	}
+
+	SetEnabled(true)
+	r.Name("name")
+	if calledCollect != 1 {
+		t.Errorf("got calledCollect %d after re-enabling, want 1", calledCollect)
+	}
+}
+
+func BenchmarkRecorder_disabled(b *testing.B) {
+	SetEnabled(false)
+	defer SetEnabled(true)
+	r := NewRecorder(SpanID{1, 2, 3}, collectorFunc(func(SpanID, ...Annotation) error { return nil }))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Name("name")
+		r.Msg("msg")
+	}
+}
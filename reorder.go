@@ -0,0 +1,192 @@
+package appdash
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A reorderBuffer holds the packets received by a CollectorServer for
+// a window of time before committing them to a collector, so that the
+// annotations of each span are collected in the order in which their
+// clients sent them (by client timestamp), and so that packets that
+// were sent more than once (e.g., by a RemoteCollector retrying after
+// a broken connection) are collected only once.
+type reorderBuffer struct {
+	c      Collector
+	window time.Duration
+
+	// maxPending is the number of pending packets at which all of them
+	// are committed at once, without waiting for the window to pass.
+	maxPending int
+
+	// onCommitError, if non-nil, is called when the collector returns
+	// an error for a committed packet.
+	onCommitError func(SpanID, error)
+
+	commitMu sync.Mutex // serializes flushes, so that they commit packets in order
+
+	mu      sync.Mutex
+	pending []*reorderPacket
+	seq     uint64                   // arrival sequence number of the last packet added
+	seen    map[reorderKey]time.Time // keys of pending and recently committed packets, with the times they may be forgotten
+	started bool                     // whether run is running
+}
+
+// maxReorderPending is the number of packets that a CollectorServer
+// holds for its ReorderWindow before it commits them early.
+const maxReorderPending = 4096
+
+// A reorderPacket is a packet held in a reorderBuffer.
+type reorderPacket struct {
+	span SpanID
+	anns Annotations
+	ts   int64     // client timestamp (or receive time), in Unix nanoseconds
+	recv time.Time // time the packet was received
+	seq  uint64    // arrival order, to break ties between equal timestamps
+}
+
+// A reorderKey identifies a packet for the purpose of detecting
+// duplicates.
+type reorderKey struct {
+	span SpanID
+	ts   int64
+	sum  uint64 // hash of the packet's annotations
+}
+
+func newReorderBuffer(c Collector, window time.Duration) *reorderBuffer {
+	return &reorderBuffer{c: c, window: window, maxPending: maxReorderPending, seen: map[reorderKey]time.Time{}}
+}
+
+// add adds a packet to the buffer, received at time recv and sent by
+// the client at ts (in Unix nanoseconds, or zero if unknown). The sum
+// is the hash of the annotations the client sent (see
+// hashAnnotations). It returns false if the packet duplicates one
+// that is pending or was committed within the last window, in which
+// case it is dropped.
+//
+// Duplicates are detected only among packets that carry a client
+// timestamp.
+//
+// If the buffer holds maxPending packets, add commits all of them
+// before it returns, so that a flood of packets cannot grow the buffer
+// without bound (and the client that fills it waits for the commit).
+func (b *reorderBuffer) add(span SpanID, anns Annotations, ts int64, sum uint64, recv time.Time) bool {
+	b.mu.Lock()
+	if !b.started {
+		b.started = true
+		go b.run()
+	}
+
+	if ts != 0 {
+		key := reorderKey{span: span, ts: ts, sum: sum}
+		if _, dup := b.seen[key]; dup {
+			b.mu.Unlock()
+			return false
+		}
+		b.seen[key] = recv.Add(2 * b.window)
+	} else {
+		ts = recv.UnixNano()
+	}
+	b.seq++
+	b.pending = append(b.pending, &reorderPacket{span: span, anns: anns, ts: ts, recv: recv, seq: b.seq})
+	full := len(b.pending) >= b.maxPending
+	b.mu.Unlock()
+
+	if full {
+		b.flush(recv, true)
+	}
+	return true
+}
+
+// run periodically commits the packets that have been held for the
+// window. It returns once the buffer holds no packets and remembers no
+// recently committed ones; add starts it again.
+func (b *reorderBuffer) run() {
+	interval := b.window / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for now := range t.C {
+		b.flush(now, false)
+
+		b.mu.Lock()
+		idle := len(b.pending) == 0 && len(b.seen) == 0
+		if idle {
+			b.started = false
+		}
+		b.mu.Unlock()
+		if idle {
+			return
+		}
+	}
+}
+
+// flush commits the packets that were received at least one window
+// before now (or, if all is true, every pending packet), along with
+// any other pending packets that were sent no later than they were,
+// in order of their client timestamps.
+func (b *reorderBuffer) flush(now time.Time, all bool) {
+	b.commitMu.Lock()
+	defer b.commitMu.Unlock()
+
+	b.mu.Lock()
+	var maxTS int64
+	ready := false
+	for _, p := range b.pending {
+		if all || !p.recv.Add(b.window).After(now) {
+			if !ready || p.ts > maxTS {
+				maxTS = p.ts
+			}
+			ready = true
+		}
+	}
+	var commit []*reorderPacket
+	if ready {
+		keep := b.pending[:0]
+		for _, p := range b.pending {
+			if all || p.ts <= maxTS || !p.recv.Add(b.window).After(now) {
+				commit = append(commit, p)
+			} else {
+				keep = append(keep, p)
+			}
+		}
+		for i := len(keep); i < len(b.pending); i++ {
+			b.pending[i] = nil
+		}
+		b.pending = keep
+	}
+	for key, expires := range b.seen {
+		if now.After(expires) {
+			delete(b.seen, key)
+		}
+	}
+	b.mu.Unlock()
+
+	sort.Slice(commit, func(i, j int) bool {
+		if commit[i].ts != commit[j].ts {
+			return commit[i].ts < commit[j].ts
+		}
+		return commit[i].seq < commit[j].seq
+	})
+	for _, p := range commit {
+		if err := b.c.Collect(p.span, p.anns...); err != nil && b.onCommitError != nil {
+			b.onCommitError(p.span, err)
+		}
+	}
+}
+
+// hashAnnotations returns a hash of the keys and values of anns.
+func hashAnnotations(anns Annotations) uint64 {
+	h := fnv.New64a()
+	for _, a := range anns {
+		h.Write([]byte(a.Key))
+		h.Write([]byte{0})
+		h.Write(a.Value)
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,49 @@
+package appdash
+
+import (
+	"strconv"
+	"time"
+)
+
+func init() { RegisterEvent(RetentionEvent{}) }
+
+// retentionKey is the annotation key of RetentionEvent.KeepFor.
+const retentionKey = "Retention.KeepFor"
+
+// RetentionEvent is a hint for how long the trace that a span belongs to
+// should be kept, overriding the store's default (see RecentStore). For
+// example, a checkout flow may be kept for weeks and health checks for
+// only minutes. It applies to the whole trace; if several spans of a
+// trace have hints, the longest one is used.
+type RetentionEvent struct {
+	// KeepFor is how long the trace should be kept after it is first
+	// seen.
+	KeepFor time.Duration `trace:"Retention.KeepFor"`
+}
+
+// KeepFor returns a RetentionEvent hinting that the trace should be kept
+// for d.
+func KeepFor(d time.Duration) RetentionEvent {
+	return RetentionEvent{KeepFor: d}
+}
+
+// Schema implements the Event interface.
+func (RetentionEvent) Schema() string { return "Retention" }
+
+// retentionHint returns the retention hint in anns, if any. It is
+// cheaper than unmarshaling a RetentionEvent, since it is called for
+// every collected span.
+func retentionHint(anns []Annotation) (time.Duration, bool) {
+	for _, a := range anns {
+		if a.Key != retentionKey {
+			continue
+		}
+		// Durations are flattened to milliseconds (see flattenValue).
+		ms, err := strconv.ParseFloat(string(a.Value), 64)
+		if err != nil || ms <= 0 {
+			return 0, false
+		}
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	return 0, false
+}
@@ -0,0 +1,91 @@
+package appdash
+
+import "fmt"
+
+func init() { RegisterEvent(SamplingEvent{}) }
+
+// Samplers that may be recorded in a SamplingEvent.
+const (
+	// SamplerHead is a probabilistic decision made when the trace
+	// started, before anything about it was known. Head-sampled traces
+	// are representative of all traffic.
+	SamplerHead = "head"
+
+	// SamplerTail is a decision made by a rule after the trace (or
+	// part of it) completed, e.g. to keep slow or failed requests.
+	// Tail-sampled traces are biased toward what the rule selects.
+	SamplerTail = "tail"
+
+	// SamplerDebug is a decision forced by the caller, e.g. by a debug
+	// header or flag.
+	SamplerDebug = "debug"
+)
+
+// Sampling decisions that may be recorded in a SamplingEvent.
+const (
+	// DecisionSampled means the sampler chose to keep the trace.
+	DecisionSampled = "sampled"
+
+	// DecisionForced means the trace was kept regardless of the
+	// sampler's own decision.
+	DecisionForced = "forced"
+)
+
+// SamplingEvent records which sampler kept a trace and why, so that
+// people looking at the trace know whether it is representative. It
+// should be recorded on the trace's root span.
+type SamplingEvent struct {
+	// Sampler is the kind of sampler that made the decision (SamplerHead,
+	// SamplerTail, SamplerDebug, or an application-specific name).
+	Sampler string `trace:"Sampling.Sampler"`
+
+	// Decision is the decision that was made (DecisionSampled or
+	// DecisionForced).
+	Decision string `trace:"Sampling.Decision"`
+
+	// Rate is the probability with which a head sampler keeps traces,
+	// if known.
+	Rate float64 `trace:"Sampling.Rate"`
+
+	// Rule is the name of the tail sampling rule that kept the trace.
+	Rule string `trace:"Sampling.Rule"`
+}
+
+// HeadSampled returns a SamplingEvent for a trace that was kept by a
+// head sampler with the given probability.
+func HeadSampled(rate float64) SamplingEvent {
+	return SamplingEvent{Sampler: SamplerHead, Decision: DecisionSampled, Rate: rate}
+}
+
+// TailSampled returns a SamplingEvent for a trace that was kept by the
+// named tail sampling rule.
+func TailSampled(rule string) SamplingEvent {
+	return SamplingEvent{Sampler: SamplerTail, Decision: DecisionSampled, Rule: rule}
+}
+
+// DebugSampled returns a SamplingEvent for a trace that was forced to be
+// kept for debugging.
+func DebugSampled() SamplingEvent {
+	return SamplingEvent{Sampler: SamplerDebug, Decision: DecisionForced}
+}
+
+// Schema implements the Event interface.
+func (SamplingEvent) Schema() string { return "Sampling" }
+
+// Important implements the ImportantEvent interface.
+func (SamplingEvent) Important() []string {
+	return []string{"Sampling.Sampler", "Sampling.Decision"}
+}
+
+// Label returns a short description of the decision, such as
+// "head 1%" or "tail (slow-requests)". (It is not named String because
+// events that implement fmt.Stringer are marshaled as a single value.)
+func (e SamplingEvent) Label() string {
+	switch {
+	case e.Rate > 0 && e.Rate < 1:
+		return fmt.Sprintf("%s %.3g%%", e.Sampler, e.Rate*100)
+	case e.Rule != "":
+		return fmt.Sprintf("%s (%s)", e.Sampler, e.Rule)
+	}
+	return e.Sampler
+}
@@ -0,0 +1,28 @@
+package appdash
+
+import "testing"
+
+func TestSamplingEvent_marshalRoundTrip(t *testing.T) {
+	tests := map[string]SamplingEvent{
+		"head 1%":             HeadSampled(0.01),
+		"head":                HeadSampled(1),
+		"tail (slow-request)": TailSampled("slow-request"),
+		"debug":               DebugSampled(),
+	}
+	for wantStr, e := range tests {
+		anns, err := MarshalEvent(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e2 SamplingEvent
+		if err := UnmarshalEvent(anns, &e2); err != nil {
+			t.Fatal(err)
+		}
+		if e2.Sampler != e.Sampler || e2.Decision != e.Decision || e2.Rule != e.Rule {
+			t.Errorf("got %+v, want %+v", e2, e)
+		}
+		if s := e2.Label(); s != wantStr {
+			t.Errorf("got Label %q, want %q", s, wantStr)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Package snapshot captures normalized snapshots of the traces in an
+// appdash store, for golden-file testing of instrumentation.
+//
+// A snapshot omits what varies between runs (span IDs, timestamps, and
+// the values of any other annotations the test chooses to ignore) and
+// orders traces and spans deterministically, so that running the same
+// instrumented code twice yields the same snapshot. A test typically
+// records its traces in a MemoryStore and compares the snapshot with a
+// golden file:
+//
+//	snap, err := snapshot.Take(store, &snapshot.Options{IgnoreKeys: []string{"SQL.Duration"}})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	golden, err := ioutil.ReadFile("testdata/traces.golden")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	if d := snapshot.Diff(string(golden), snap.String()); d != "" {
+//		t.Errorf("traces differ from golden file (-want +got):\n%s", d)
+//	}
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// Placeholders that replace annotation values in snapshots.
+const (
+	// Timestamp replaces values that are timestamps.
+	Timestamp = "<timestamp>"
+
+	// Ignored replaces the values of keys in Options.IgnoreKeys.
+	Ignored = "<ignored>"
+)
+
+// Options configures how snapshots are normalized.
+type Options struct {
+	// IgnoreKeys are the annotation keys whose values vary between runs
+	// (e.g., durations or generated IDs) and are replaced with Ignored.
+	IgnoreKeys []string
+
+	// KeepTimestamps is whether to keep annotation values that are
+	// timestamps instead of replacing them with Timestamp.
+	KeepTimestamps bool
+}
+
+// A Snapshot is a normalized representation of a set of traces.
+type Snapshot struct {
+	// Traces are the root spans of the traces, in a stable order.
+	Traces []*Span
+}
+
+// A Span is a normalized span.
+type Span struct {
+	// Name is the span's name, if any.
+	Name string
+
+	// Annotations are the span's annotations (except its name), sorted
+	// by key and then value.
+	Annotations []Annotation
+
+	// Children are the span's children, in a stable order.
+	Children []*Span
+}
+
+// An Annotation is a normalized annotation.
+type Annotation struct {
+	Key, Value string
+}
+
+// Take returns a snapshot of all of the traces in q. If opt is nil,
+// default options are used.
+func Take(q appdash.Queryer, opt *Options) (*Snapshot, error) {
+	traces, err := q.Traces()
+	if err != nil {
+		return nil, err
+	}
+	return FromTraces(traces, opt), nil
+}
+
+// FromTraces returns a snapshot of the given traces. If opt is nil,
+// default options are used.
+func FromTraces(traces []*appdash.Trace, opt *Options) *Snapshot {
+	if opt == nil {
+		opt = &Options{}
+	}
+	ignore := make(map[string]bool, len(opt.IgnoreKeys))
+	for _, k := range opt.IgnoreKeys {
+		ignore[k] = true
+	}
+
+	s := &Snapshot{}
+	for _, t := range traces {
+		s.Traces = append(s.Traces, normalize(t, ignore, opt.KeepTimestamps))
+	}
+	sortSpans(s.Traces)
+	return s
+}
+
+func normalize(t *appdash.Trace, ignore map[string]bool, keepTimestamps bool) *Span {
+	s := &Span{Name: t.Span.Name()}
+	for _, a := range t.Span.Annotations {
+		if a.Key == "Name" {
+			continue
+		}
+		v := string(a.Value)
+		switch {
+		case ignore[a.Key]:
+			v = Ignored
+		case !keepTimestamps && isTimestamp(v):
+			v = Timestamp
+		}
+		s.Annotations = append(s.Annotations, Annotation{Key: a.Key, Value: v})
+	}
+	sort.Slice(s.Annotations, func(i, j int) bool {
+		a, b := s.Annotations[i], s.Annotations[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Value < b.Value
+	})
+	for _, sub := range t.Sub {
+		s.Children = append(s.Children, normalize(sub, ignore, keepTimestamps))
+	}
+	sortSpans(s.Children)
+	return s
+}
+
+// isTimestamp reports whether v is a timestamp as written by
+// appdash.MarshalEvent.
+func isTimestamp(v string) bool {
+	_, err := time.Parse(time.RFC3339Nano, v)
+	return err == nil
+}
+
+// sortSpans sorts spans by their text representation, which (because
+// IDs are omitted) is the only stable order.
+func sortSpans(spans []*Span) {
+	keys := make(map[*Span]string, len(spans))
+	for _, s := range spans {
+		keys[s] = s.String()
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return keys[spans[i]] < keys[spans[j]] })
+}
+
+// String returns the text representation of the snapshot, suitable for
+// storing in a golden file.
+func (s *Snapshot) String() string {
+	var b strings.Builder
+	for _, t := range s.Traces {
+		t.write(&b, 0)
+	}
+	return b.String()
+}
+
+// String returns the text representation of the span and its
+// descendants.
+func (s *Span) String() string {
+	var b strings.Builder
+	s.write(&b, 0)
+	return b.String()
+}
+
+func (s *Span) write(b *strings.Builder, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(b, "%s- span %q\n", indent, s.Name)
+	for _, a := range s.Annotations {
+		fmt.Fprintf(b, "%s    %s = %q\n", indent, a.Key, a.Value)
+	}
+	for _, c := range s.Children {
+		c.write(b, depth+1)
+	}
+}
+
+// Diff returns a line-by-line diff of two snapshot texts (as returned
+// by Snapshot.String), or "" if they are equal. Removed lines are
+// prefixed with "-" and added lines with "+".
+func Diff(want, got string) string {
+	if want == got {
+		return ""
+	}
+	a, b := strings.SplitAfter(want, "\n"), strings.SplitAfter(got, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var d strings.Builder
+	line := func(prefix, s string) {
+		if s == "" {
+			return // after the final newline
+		}
+		d.WriteString(prefix + strings.TrimSuffix(s, "\n") + "\n")
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			line(" ", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			line("-", a[i])
+			i++
+		default:
+			line("+", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		line("-", a[i])
+	}
+	for ; j < len(b); j++ {
+		line("+", b[j])
+	}
+	return d.String()
+}
@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// record records the same traces, with new random IDs each time.
+func record() *appdash.MemoryStore {
+	ms := appdash.NewMemoryStore()
+	for _, name := range []string{"b", "a"} {
+		rec := appdash.NewRecorder(appdash.NewRootSpanID(), ms)
+		rec.Name(name)
+		rec.Log("hello")
+		for _, child := range []string{"y", "x"} {
+			c := rec.Child()
+			c.Name(child)
+			c.Annotation(appdash.Annotation{Key: "ID", Value: []byte(c.SpanID.String())})
+		}
+	}
+	return ms
+}
+
+func TestTake(t *testing.T) {
+	opt := &Options{IgnoreKeys: []string{"ID"}}
+	snap1, err := Take(record(), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap2, err := Take(record(), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(snap1.String(), snap2.String()); d != "" {
+		t.Errorf("snapshots of the same traces differ:\n%s", d)
+	}
+
+	want := `- span "a"
+    Msg = "hello"
+    Time = "<timestamp>"
+    _schema:log = ""
+    _schema:name = ""
+  - span "x"
+      ID = "<ignored>"
+      _schema:name = ""
+  - span "y"
+      ID = "<ignored>"
+      _schema:name = ""
+- span "b"
+    Msg = "hello"
+    Time = "<timestamp>"
+    _schema:log = ""
+    _schema:name = ""
+  - span "x"
+      ID = "<ignored>"
+      _schema:name = ""
+  - span "y"
+      ID = "<ignored>"
+      _schema:name = ""
+`
+	if d := Diff(want, snap1.String()); d != "" {
+		t.Errorf("got snapshot (-want +got):\n%s", d)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	want := "a\nb\nc\n"
+	got := "a\nc\nd\n"
+	if d, wantDiff := Diff(want, got), " a\n-b\n c\n+d\n"; d != wantDiff {
+		t.Errorf("got diff %q, want %q", d, wantDiff)
+	}
+	if d := Diff(want, want); d != "" {
+		t.Errorf("got diff %q of equal texts, want none", d)
+	}
+}
@@ -68,12 +68,13 @@ func (id SpanID) wire() *wire.CollectPacket_SpanID {
 	}
 }
 
-// spanIDFromWire returns a SpanID from it's protobuf definition.
+// spanIDFromWire returns a SpanID from it's protobuf definition. The
+// parent is optional (and zero if it's absent).
 func spanIDFromWire(w *wire.CollectPacket_SpanID) SpanID {
 	return SpanID{
-		Trace:  ID(*w.Trace),
-		Span:   ID(*w.Span),
-		Parent: ID(*w.Parent),
+		Trace:  ID(w.GetTrace()),
+		Span:   ID(w.GetSpan()),
+		Parent: ID(w.GetParent()),
 	}
 }
 
@@ -226,8 +227,8 @@ func (as Annotations) get(key string) []byte {
 }
 
 // StringMap returns the annotations as a key-value map. Only one
-// annotation for a key appears in the map, and it is chosen
-// arbitrarily among the annotations with the same key.
+// annotation for a key appears in the map: the last one, so that
+// annotations collected later for a span supersede earlier ones.
 func (as Annotations) StringMap() map[string]string {
 	m := make(map[string]string, len(as))
 	for _, a := range as {
@@ -236,6 +237,30 @@ func (as Annotations) StringMap() map[string]string {
 	return m
 }
 
+// clone returns a copy of as whose values are copied into a single new
+// buffer, for collectors that retain annotations after Collect returns
+// (so that callers may reuse theirs).
+func (as Annotations) clone() Annotations {
+	if len(as) == 0 {
+		return nil
+	}
+	var n int
+	for _, a := range as {
+		n += len(a.Value)
+	}
+	buf := make([]byte, 0, n)
+	as2 := make(Annotations, len(as))
+	for i, a := range as {
+		as2[i].Key = a.Key
+		if a.Value != nil {
+			start := len(buf)
+			buf = append(buf, a.Value...)
+			as2[i].Value = buf[start:len(buf):len(buf)]
+		}
+	}
+	return as2
+}
+
 // wire returns the set of annotations as their protobuf definitions.
 func (as Annotations) wire() (w []*wire.CollectPacket_Annotation) {
 	for _, a := range as {
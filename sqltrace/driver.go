@@ -0,0 +1,422 @@
+package sqltrace
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+type contextKey int
+
+const recorderKey contextKey = iota
+
+// NewContext returns a copy of ctx that carries the recorder rec. Queries made
+// with the returned context through a Driver are recorded as child spans of
+// rec's span.
+func NewContext(ctx context.Context, rec *appdash.Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey, rec)
+}
+
+// FromContext returns the recorder carried by ctx, or nil if there is none.
+func FromContext(ctx context.Context) *appdash.Recorder {
+	rec, _ := ctx.Value(recorderKey).(*appdash.Recorder)
+	return rec
+}
+
+// Driver is a database/sql driver that wraps another driver and records
+// a span with an SQLEvent for each statement executed using a context that
+// carries a recorder (see NewContext). Each database transaction begun with
+// such a context is also recorded as a span with a TxEvent, and the spans of
+// the statements executed in the transaction are its children.
+//
+// To use it, register it under a new name and open databases using that name:
+//
+//	sql.Register("appdash-postgres", &sqltrace.Driver{Driver: &pq.Driver{}})
+//	db, err := sql.Open("appdash-postgres", dsn)
+//	...
+//	rows, err := db.QueryContext(sqltrace.NewContext(ctx, rec), "SELECT ...")
+//
+// Statements are recorded as described for NewSQLEvent.
+type Driver struct {
+	// Driver is the underlying driver.
+	driver.Driver
+
+	// ExplainThreshold, if non-zero, is the duration above which the
+	// plans of traced statements are captured by running EXPLAIN on them
+	// (see ExplainEvent).
+	ExplainThreshold time.Duration
+
+	// Dialect is the SQL dialect of the database, used to determine how
+	// to run EXPLAIN. It is a key of ExplainPrefixes (e.g., "postgres",
+	// "mysql" or "sqlite"). If empty or unknown, "EXPLAIN " is used.
+	Dialect string
+
+	// LockWait, if non-nil, is called after each statement executed in
+	// a traced transaction (once its rows are closed, for a query) to
+	// get how long the statement waited for locks held by other
+	// transactions, which database/sql cannot observe. It is passed the
+	// underlying driver's connection, which it may use to ask the
+	// database (e.g., for the LOCK_TIME of the connection's last
+	// statement in MySQL's performance_schema). If it returns an error,
+	// the statement's lock wait is not counted. The lock waits of a
+	// transaction's statements are recorded in TxEvent.LockWaitDuration.
+	LockWait func(ctx context.Context, conn driver.Conn) (time.Duration, error)
+}
+
+// Open implements the driver.Driver interface.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, d: d}, nil
+}
+
+// conn wraps a driver.Conn. database/sql never uses a conn concurrently, so
+// tx needs no locking.
+type conn struct {
+	driver.Conn
+	d  *Driver
+	tx *tx // the current transaction, if it is traced
+}
+
+// parent returns the recorder for the parent span of a statement executed
+// with the given context: the current transaction's if there is one, and
+// the one carried by ctx otherwise.
+func (c *conn) parent(ctx context.Context) *appdash.Recorder {
+	if c.tx != nil {
+		return c.tx.rec
+	}
+	return FromContext(ctx)
+}
+
+// record runs the statement query with the function f and, if the statement
+// has a parent span, records it. If the statement is a query, rows points to
+// the rows that f sets.
+func (c *conn) record(ctx context.Context, query string, args []driver.NamedValue, rows *driver.Rows, f func() error) error {
+	parent := c.parent(ctx)
+	if parent == nil {
+		return f()
+	}
+
+	e := NewSQLEvent(query, "")
+	err := f()
+	e.ClientRecv = time.Now()
+	if err == driver.ErrSkip {
+		return err // database/sql retries using another method
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if c.tx != nil {
+		c.tx.e.StatementDuration += e.ClientRecv.Sub(e.ClientSend)
+		c.tx.e.Statements++
+	}
+
+	rec := parent.Child()
+	rec.Name(e.SQL)
+	rec.Kind(appdash.SpanKindClient)
+	rec.Event(e)
+
+	// These use the connection, so they run once it is free, and in
+	// this order, because EXPLAIN is itself a statement whose lock wait
+	// would be reported instead of this one's.
+	var after []func()
+	if c.tx != nil && c.d.LockWait != nil {
+		t := c.tx
+		after = append(after, func() {
+			if d, err := c.d.LockWait(ctx, c.Conn); err == nil {
+				t.e.LockWaitDuration += d
+			}
+		})
+	}
+	if err == nil && c.d.shouldExplain(query, e.ClientRecv.Sub(e.ClientSend)) {
+		after = append(after, func() { rec.Event(c.explain(ctx, query, args)) })
+	}
+	if len(after) > 0 {
+		run := func() {
+			for _, f := range after {
+				f()
+			}
+		}
+		if rows != nil && *rows != nil {
+			// The connection is busy until the rows are closed.
+			*rows = &afterCloseRows{Rows: *rows, after: run}
+		} else {
+			run()
+		}
+	}
+	return err
+}
+
+// afterCloseRows wraps the rows of a query, calling after when they are
+// closed (because the connection cannot be used until then).
+type afterCloseRows struct {
+	driver.Rows
+	after func()
+	done  bool
+}
+
+func (r *afterCloseRows) Close() error {
+	err := r.Rows.Close()
+	if !r.done {
+		r.done = true
+		r.after()
+	}
+	return err
+}
+
+// HasNextResultSet implements the driver.RowsNextResultSet interface.
+func (r *afterCloseRows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet implements the driver.RowsNextResultSet interface.
+func (r *afterCloseRows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
+
+// Prepare implements the driver.Conn interface.
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext implements the driver.ConnPrepareContext interface.
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		s   driver.Stmt
+		err error
+	)
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, conn: c, query: query}, nil
+}
+
+// ExecContext implements the driver.ExecerContext interface.
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (res driver.Result, err error) {
+	err = c.record(ctx, query, args, nil, func() error {
+		if ec, ok := c.Conn.(driver.ExecerContext); ok {
+			res, err = ec.ExecContext(ctx, query, args)
+			return err
+		}
+		if e, ok := c.Conn.(driver.Execer); ok {
+			var vs []driver.Value
+			if vs, err = namedValuesToValues(args); err != nil {
+				return err
+			}
+			res, err = e.Exec(query, vs)
+			return err
+		}
+		return driver.ErrSkip
+	})
+	return res, err
+}
+
+// QueryContext implements the driver.QueryerContext interface.
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	err = c.record(ctx, query, args, &rows, func() error {
+		if qc, ok := c.Conn.(driver.QueryerContext); ok {
+			rows, err = qc.QueryContext(ctx, query, args)
+			return err
+		}
+		if q, ok := c.Conn.(driver.Queryer); ok {
+			var vs []driver.Value
+			if vs, err = namedValuesToValues(args); err != nil {
+				return err
+			}
+			rows, err = q.Query(query, vs)
+			return err
+		}
+		return driver.ErrSkip
+	})
+	return rows, err
+}
+
+// Begin implements the driver.Conn interface.
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements the driver.ConnBeginTx interface.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var (
+		t   driver.Tx
+		err error
+	)
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		t, err = bc.BeginTx(ctx, opts)
+	} else {
+		if opts.Isolation != 0 || opts.ReadOnly {
+			return nil, errors.New("sqltrace: underlying driver does not support transaction options")
+		}
+		t, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parent := FromContext(ctx)
+	if parent == nil {
+		return t, nil // not traced
+	}
+	c.tx = &tx{Tx: t, conn: c, rec: parent.Child()}
+	c.tx.e.TxStart = start
+	c.tx.e.BeginDuration = time.Since(start)
+	c.tx.e.ReadOnly = opts.ReadOnly
+	return c.tx, nil
+}
+
+// Ping implements the driver.Pinger interface.
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession implements the driver.SessionResetter interface.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if sr, ok := c.Conn.(driver.SessionResetter); ok {
+		return sr.ResetSession(ctx)
+	}
+	return nil
+}
+
+// CheckNamedValue implements the driver.NamedValueChecker interface.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip // use the default conversion
+}
+
+// stmt wraps a prepared driver.Stmt.
+type stmt struct {
+	driver.Stmt
+	conn  *conn
+	query string
+}
+
+// Exec implements the driver.Stmt interface.
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), valuesToNamedValues(args))
+}
+
+// Query implements the driver.Stmt interface.
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), valuesToNamedValues(args))
+}
+
+// ExecContext implements the driver.StmtExecContext interface.
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	err = s.conn.record(ctx, s.query, args, nil, func() error {
+		if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+			res, err = ec.ExecContext(ctx, args)
+			return err
+		}
+		var vs []driver.Value
+		if vs, err = namedValuesToValues(args); err != nil {
+			return err
+		}
+		res, err = s.Stmt.Exec(vs)
+		return err
+	})
+	return res, err
+}
+
+// QueryContext implements the driver.StmtQueryContext interface.
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	err = s.conn.record(ctx, s.query, args, &rows, func() error {
+		if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			rows, err = qc.QueryContext(ctx, args)
+			return err
+		}
+		var vs []driver.Value
+		if vs, err = namedValuesToValues(args); err != nil {
+			return err
+		}
+		rows, err = s.Stmt.Query(vs)
+		return err
+	})
+	return rows, err
+}
+
+// ColumnConverter implements the driver.ColumnConverter interface.
+func (s *stmt) ColumnConverter(idx int) driver.ValueConverter {
+	if cc, ok := s.Stmt.(driver.ColumnConverter); ok {
+		return cc.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+// tx wraps a traced driver.Tx.
+type tx struct {
+	driver.Tx
+	conn *conn
+	rec  *appdash.Recorder
+	e    TxEvent
+}
+
+// Commit implements the driver.Tx interface.
+func (t *tx) Commit() error {
+	return t.end("commit", t.Tx.Commit)
+}
+
+// Rollback implements the driver.Tx interface.
+func (t *tx) Rollback() error {
+	return t.end("rollback", t.Tx.Rollback)
+}
+
+func (t *tx) end(outcome string, f func() error) error {
+	start := time.Now()
+	err := f()
+	t.e.TxEnd = time.Now()
+	t.e.Outcome = outcome
+	t.e.EndDuration = t.e.TxEnd.Sub(start)
+	if err != nil {
+		t.e.Error = err.Error()
+	}
+	t.e.IdleDuration = t.e.TxEnd.Sub(t.e.TxStart) - t.e.BeginDuration - t.e.EndDuration - t.e.StatementDuration
+	t.conn.tx = nil
+
+	t.rec.Name("transaction")
+	t.rec.Kind(appdash.SpanKindClient)
+	t.rec.Event(t.e)
+	return err
+}
+
+func namedValuesToValues(nvs []driver.NamedValue) ([]driver.Value, error) {
+	vs := make([]driver.Value, len(nvs))
+	for i, nv := range nvs {
+		if nv.Name != "" {
+			return nil, errors.New("sqltrace: underlying driver does not support named parameters")
+		}
+		vs[i] = nv.Value
+	}
+	return vs, nil
+}
+
+func valuesToNamedValues(vs []driver.Value) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(vs))
+	for i, v := range vs {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nvs
+}
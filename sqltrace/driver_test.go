@@ -0,0 +1,312 @@
+package sqltrace
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+func init() {
+	sql.Register("sqltrace-fake", &Driver{Driver: fakeDriver{}})
+	sql.Register("sqltrace-fake-explain", &Driver{
+		Driver:           fakeDriver{},
+		ExplainThreshold: time.Nanosecond,
+		Dialect:          "sqlite",
+	})
+	sql.Register("sqltrace-fake-lockwait", &Driver{
+		Driver: fakeDriver{},
+		LockWait: func(ctx context.Context, conn driver.Conn) (time.Duration, error) {
+			if _, ok := conn.(fakeConn); !ok {
+				return 0, errors.New("got a wrapped connection")
+			}
+			return 5 * time.Millisecond, nil
+		},
+	})
+}
+
+func TestDriver(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	rec := appdash.NewRecorder(appdash.SpanID{Trace: 1, Span: 1}, appdash.NewLocalCollector(ms))
+	rec.Name("root")
+	ctx := NewContext(context.Background(), rec)
+
+	db, err := sql.Open("sqltrace-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Statements executed without a recorder are not traced.
+	if _, err := db.Exec("UPDATE t SET a = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if trace, err := ms.Trace(1); err != nil || len(trace.Sub) != 0 {
+		t.Fatalf("got %v (err %v), want a trace without children", trace, err)
+	}
+
+	if _, err := db.ExecContext(ctx, "UPDATE t SET a = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "fail"); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("UPDATE t SET a = 2"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := tx.Query("SELECT a FROM t WHERE b = 'x'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := ms.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Sub) != 4 {
+		t.Fatalf("got %d child spans, want 4", len(trace.Sub))
+	}
+
+	var stmts, txs []*appdash.Trace
+	for _, sub := range trace.Sub {
+		if sub.Span.Name() == "transaction" {
+			txs = append(txs, sub)
+		} else {
+			stmts = append(stmts, sub)
+		}
+	}
+	if len(stmts) != 2 || len(txs) != 2 {
+		t.Fatalf("got %d statement spans and %d transaction spans, want 2 and 2", len(stmts), len(txs))
+	}
+
+	errs := 0
+	for _, s := range stmts {
+		var e SQLEvent
+		if err := appdash.UnmarshalEvent(s.Span.Annotations, &e); err != nil {
+			t.Fatal(err)
+		}
+		switch e.SQL {
+		case "UPDATE t SET a = ?":
+		case "fail":
+			if e.Error == "" {
+				t.Error("got no error recorded for failed statement")
+			}
+			errs++
+		default:
+			t.Errorf("unexpected statement %q", e.SQL)
+		}
+	}
+	if errs != 1 {
+		t.Errorf("got %d failed statements, want 1", errs)
+	}
+
+	for _, tx := range txs {
+		var e TxEvent
+		if err := appdash.UnmarshalEvent(tx.Span.Annotations, &e); err != nil {
+			t.Fatal(err)
+		}
+		switch e.Outcome {
+		case "commit":
+			if e.Statements != 2 || len(tx.Sub) != 2 {
+				t.Errorf("got %d statements and %d child spans in committed transaction, want 2 and 2", e.Statements, len(tx.Sub))
+			}
+		case "rollback":
+			if e.Statements != 0 || len(tx.Sub) != 0 {
+				t.Errorf("got %d statements and %d child spans in rolled back transaction, want 0 and 0", e.Statements, len(tx.Sub))
+			}
+		default:
+			t.Errorf("unexpected transaction outcome %q", e.Outcome)
+		}
+		if e.TxEnd.Before(e.TxStart) {
+			t.Errorf("got transaction end %v before start %v", e.TxEnd, e.TxStart)
+		}
+	}
+}
+
+func TestDriver_explain(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	rec := appdash.NewRecorder(appdash.SpanID{Trace: 1, Span: 1}, appdash.NewLocalCollector(ms))
+	rec.Name("root")
+	ctx := NewContext(context.Background(), rec)
+
+	db, err := sql.Open("sqltrace-fake-explain", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "SELECT a FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE t2 (a int)"); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := ms.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Sub) != 2 {
+		t.Fatalf("got %d child spans, want 2", len(trace.Sub))
+	}
+	for _, sub := range trace.Sub {
+		switch name := sub.Span.Name(); name {
+		case "SELECT a FROM t":
+			var e ExplainEvent
+			if err := appdash.UnmarshalEvent(sub.Span.Annotations, &e); err != nil {
+				t.Fatal(err)
+			}
+			if want := "id\tdetail\n2\tSCAN t"; e.Plan != want || e.Error != "" {
+				t.Errorf("got plan %q (error %q), want %q", e.Plan, e.Error, want)
+			}
+		case "CREATE TABLE t2 (a int)":
+			for _, a := range sub.Span.Annotations {
+				if a.Key == "Explain.Plan" {
+					t.Errorf("got plan %q for DDL statement, want none", a.Value)
+				}
+			}
+		default:
+			t.Errorf("unexpected span %q", name)
+		}
+	}
+}
+
+func TestDriver_lockWait(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	rec := appdash.NewRecorder(appdash.SpanID{Trace: 1, Span: 1}, appdash.NewLocalCollector(ms))
+	ctx := NewContext(context.Background(), rec)
+
+	db, err := sql.Open("sqltrace-fake-lockwait", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Statements outside transactions have no lock waits recorded.
+	if _, err := db.ExecContext(ctx, "UPDATE t SET a = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("UPDATE t SET a = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("fail"); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+	rows, err := tx.Query("SELECT a FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := ms.Trace(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range trace.Sub {
+		if sub.Span.Name() != "transaction" {
+			continue
+		}
+		var e TxEvent
+		if err := appdash.UnmarshalEvent(sub.Span.Annotations, &e); err != nil {
+			t.Fatal(err)
+		}
+		if want := 15 * time.Millisecond; e.LockWaitDuration != want {
+			t.Errorf("got lock wait %v, want %v", e.LockWaitDuration, want)
+		}
+		return
+	}
+	t.Fatal("no transaction span")
+}
+
+// fakeDriver is a database/sql driver that implements only the required
+// interfaces, so that the fallback code paths of Driver are exercised.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt(query), nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+type fakeStmt string
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s == "fail" {
+		return nil, errors.New("fail")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s == "fail" {
+		return nil, errors.New("fail")
+	}
+	if strings.HasPrefix(string(s), "EXPLAIN QUERY PLAN ") {
+		return &fakeRows{
+			cols: []string{"id", "detail"},
+			data: [][]driver.Value{{int64(2), []byte("SCAN t")}},
+		}, nil
+	}
+	return &fakeRows{cols: []string{"a"}}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	data [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.data[0])
+	r.data = r.data[1:]
+	return nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
@@ -0,0 +1,135 @@
+package sqltrace
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// ExplainPrefixes maps SQL dialect names (see Driver.Dialect) to the prefix
+// that turns a statement into a statement that returns its query plan.
+var ExplainPrefixes = map[string]string{
+	"postgres": "EXPLAIN ",
+	"mysql":    "EXPLAIN ",
+	"sqlite":   "EXPLAIN QUERY PLAN ",
+}
+
+// maxPlanSize is the maximum size of a recorded query plan.
+const maxPlanSize = 16 * 1024
+
+// ExplainEvent is the query plan of a slow statement, recorded by Driver on
+// the statement's span.
+type ExplainEvent struct {
+	// Plan is the output of EXPLAIN, one line per row. For results with
+	// multiple columns, the first line contains the column names and
+	// values are separated by tabs.
+	Plan string `trace:"Explain.Plan"`
+
+	// Error is the error that occurred while running EXPLAIN, if any.
+	Error string `trace:"Explain.Error"`
+}
+
+// Schema implements the appdash Event interface by returning this event's
+// constant schema string, "SQLExplain".
+func (ExplainEvent) Schema() string { return "SQLExplain" }
+
+// Important implements the appdash ImportantEvent by returning the plan key.
+func (ExplainEvent) Important() []string { return []string{"Explain.Plan"} }
+
+func init() { appdash.RegisterEvent(ExplainEvent{}) }
+
+// shouldExplain reports whether the plan of query, which took d to run,
+// should be captured.
+func (d *Driver) shouldExplain(query string, dur time.Duration) bool {
+	if d.ExplainThreshold == 0 || dur < d.ExplainThreshold {
+		return false
+	}
+	// Only explain statements that EXPLAIN supports in all dialects (and
+	// not, e.g., DDL statements).
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH", "REPLACE":
+		return true
+	}
+	return false
+}
+
+// explain runs EXPLAIN on query with the given arguments on the underlying
+// connection and returns the resulting event.
+func (c *conn) explain(ctx context.Context, query string, args []driver.NamedValue) ExplainEvent {
+	prefix, ok := ExplainPrefixes[c.d.Dialect]
+	if !ok {
+		prefix = "EXPLAIN "
+	}
+	plan, err := c.query(ctx, prefix+query, args)
+	if err != nil {
+		return ExplainEvent{Error: err.Error()}
+	}
+	return ExplainEvent{Plan: plan}
+}
+
+// query runs query on the underlying connection and returns its result as
+// text.
+func (c *conn) query(ctx context.Context, query string, args []driver.NamedValue) (string, error) {
+	var (
+		rows driver.Rows
+		err  error
+	)
+	if qc, ok := c.Conn.(driver.QueryerContext); ok {
+		rows, err = qc.QueryContext(ctx, query, args)
+	} else {
+		err = driver.ErrSkip
+	}
+	if err == driver.ErrSkip {
+		var s driver.Stmt
+		s, err = c.Conn.Prepare(query)
+		if err != nil {
+			return "", err
+		}
+		defer s.Close()
+		var vs []driver.Value
+		if vs, err = namedValuesToValues(args); err != nil {
+			return "", err
+		}
+		rows, err = s.Query(vs)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var buf strings.Builder
+	cols := rows.Columns()
+	if len(cols) > 1 {
+		buf.WriteString(strings.Join(cols, "\t"))
+		buf.WriteByte('\n')
+	}
+	dest := make([]driver.Value, len(cols))
+	for buf.Len() < maxPlanSize {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		for i, v := range dest {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			if b, ok := v.([]byte); ok {
+				buf.Write(b)
+			} else if v != nil {
+				fmt.Fprint(&buf, v)
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
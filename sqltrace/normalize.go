@@ -0,0 +1,186 @@
+package sqltrace
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// RecordRawSQL is whether NewSQLEvent records SQL queries verbatim. By default
+// queries are normalized (see Normalize), so that literal values, which may be
+// sensitive, are not recorded.
+var RecordRawSQL = false
+
+// NewSQLEvent returns a new SQL event for the given query, with its ClientSend
+// time set to the current time. Unless RecordRawSQL is true, the query is
+// normalized first. The returned event should have its ClientRecv time set
+// before being recorded.
+//
+// Because normalized queries with the same shape are identical, they are
+// suitable for use as span names, so that the traceapp aggregation groups
+// them together.
+func NewSQLEvent(query, tag string) *SQLEvent {
+	if !RecordRawSQL {
+		query = Normalize(query)
+	}
+	return &SQLEvent{
+		SQL:        query,
+		Tag:        tag,
+		ClientSend: time.Now(),
+	}
+}
+
+// inList matches IN lists consisting only of '?' placeholders.
+var inList = regexp.MustCompile(`(?i)\bIN\s*\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+
+// Normalize returns the shape of the SQL query: string (including
+// PostgreSQL dollar-quoted) and numeric literals are replaced by '?'
+// placeholders, IN lists of literals are collapsed to a
+// single placeholder, comments are removed and whitespace is collapsed.
+// Existing placeholders ("?", "$1", ":name", "@p1") and quoted identifiers are
+// preserved. For example,
+//
+//	SELECT * FROM t WHERE a = 'x' AND b IN (1, 2, 3) AND c = $1
+//
+// is normalized to
+//
+//	SELECT * FROM t WHERE a = ? AND b IN (?) AND c = $1
+func Normalize(query string) string {
+	var buf strings.Builder
+	buf.Grow(len(query))
+
+	space := false // whether whitespace is pending
+	emit := func(s string) {
+		if space && buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		space = false
+		buf.WriteString(s)
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case isSpace(c):
+			space = true
+			i++
+
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			// Line comment.
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+			space = true
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			// Block comment.
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				i += 2 + j + 2
+			} else {
+				i = len(query)
+			}
+			space = true
+
+		case c == '\'':
+			// String literal, with '' and \' escapes.
+			i++
+			for i < len(query) {
+				if query[i] == '\\' {
+					i += 2
+					continue
+				}
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			emit("?")
+
+		case c == '$' && dollarTag(query[i:]) != "":
+			// Dollar-quoted string literal ($$...$$ or $tag$...$tag$).
+			tag := dollarTag(query[i:])
+			if j := strings.Index(query[i+len(tag):], tag); j >= 0 {
+				i += len(tag) + j + len(tag)
+			} else {
+				i = len(query)
+			}
+			emit("?")
+
+		case c == '"' || c == '`':
+			// Quoted identifier.
+			j := strings.IndexByte(query[i+1:], c)
+			if j < 0 {
+				j = len(query) - i - 1
+			} else {
+				j++
+			}
+			emit(query[i : i+j+1])
+			i += j + 1
+
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			// Numeric literal (identifiers and placeholders that contain
+			// digits are consumed whole below, so this is always the
+			// start of a number).
+			j := i + 1
+			for j < len(query) && (isIdent(query[j]) || query[j] == '.' ||
+				((query[j] == '+' || query[j] == '-') && (query[j-1] == 'e' || query[j-1] == 'E'))) {
+				j++
+			}
+			emit("?")
+			i = j
+
+		case isIdent(c) || c == '$' || c == ':' || c == '@':
+			// Identifier, keyword or placeholder.
+			j := i + 1
+			for j < len(query) && (isIdent(query[j]) || query[j] == '$') {
+				j++
+			}
+			emit(query[i:j])
+			i = j
+
+		default:
+			emit(query[i : i+1])
+			i++
+		}
+	}
+
+	return inList.ReplaceAllStringFunc(buf.String(), func(s string) string {
+		return s[:2] + " (?)" // preserve the case of "IN"
+	})
+}
+
+// dollarTag returns the opening tag of the dollar-quoted string literal
+// that s starts with ("$$" or "$tag$"), or "" if s doesn't start with
+// one. Tags can't start with a digit, so placeholders like "$1" aren't
+// mistaken for them.
+func dollarTag(s string) string {
+	if len(s) < 2 || s[0] != '$' || isDigit(s[1]) {
+		return ""
+	}
+	for j := 1; j < len(s); j++ {
+		if s[j] == '$' {
+			return s[:j+1]
+		}
+		if !isIdent(s[j]) {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isSpace(c byte) bool { return c < 0x80 && unicode.IsSpace(rune(c)) }
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// isIdent reports whether c may be part of an identifier. All non-ASCII bytes
+// are treated as identifier characters.
+func isIdent(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '_' || c >= 0x80
+}
@@ -0,0 +1,38 @@
+package sqltrace
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1": "SELECT ?",
+		"SELECT * FROM t WHERE a = 'x' AND b IN (1, 2, 3) AND c = $1":    "SELECT * FROM t WHERE a = ? AND b IN (?) AND c = $1",
+		"select * from t where id in(?,?,?)":                             "select * from t where id in (?)",
+		"SELECT * FROM t WHERE id IN ($1, $2)":                           "SELECT * FROM t WHERE id IN ($1, $2)",
+		"SELECT  *\n\tFROM t -- comment 'x'\nWHERE a = 'it''s' /* 42 */": "SELECT * FROM t WHERE a = ?",
+		`SELECT "col1", ` + "`t2`.`c3`" + ` FROM t1 WHERE x = 'a\'b'`:    `SELECT "col1", ` + "`t2`.`c3`" + ` FROM t1 WHERE x = ?`,
+		"UPDATE t SET a = -1.5e+3, b = 0x1F, c = .5 WHERE d = :name":     "UPDATE t SET a = -?, b = ?, c = ? WHERE d = :name",
+		"SELECT a::int FROM t2 WHERE b = @p1":                            "SELECT a::int FROM t2 WHERE b = @p1",
+		"INSERT INTO t (a, b) VALUES (1, 'x')":                           "INSERT INTO t (a, b) VALUES (?, ?)",
+		"SELECT $$it's x$$, $q$ $$ 'y'$q$ FROM t WHERE c = $1":           "SELECT ?, ? FROM t WHERE c = $1",
+		"SELECT $b$unterminated secret":                                  "SELECT ?",
+	}
+	for query, want := range tests {
+		if got := Normalize(query); got != want {
+			t.Errorf("Normalize(%q):\ngot  %q\nwant %q", query, got, want)
+		}
+	}
+}
+
+func TestNewSQLEvent(t *testing.T) {
+	const query = "SELECT * FROM users WHERE email = 'a@example.com'"
+
+	if e := NewSQLEvent(query, "t"); e.SQL != "SELECT * FROM users WHERE email = ?" {
+		t.Errorf("got SQL %q, want normalized query", e.SQL)
+	}
+
+	RecordRawSQL = true
+	defer func() { RecordRawSQL = false }()
+	if e := NewSQLEvent(query, "t"); e.SQL != query {
+		t.Errorf("got SQL %q with RecordRawSQL, want %q", e.SQL, query)
+	}
+}
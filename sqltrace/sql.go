@@ -14,6 +14,7 @@ type SQLEvent struct {
 	Tag        string
 	ClientSend time.Time
 	ClientRecv time.Time
+	Error      string // the error returned by the query, if any
 }
 
 // Schema implements the appdash Event interface by returning this event's
@@ -32,4 +33,73 @@ func (e SQLEvent) Start() time.Time { return e.ClientSend }
 // which the SQL query returned / was received.
 func (e SQLEvent) End() time.Time { return e.ClientRecv }
 
-func init() { appdash.RegisterEvent(SQLEvent{}) }
+// TxEvent is a database transaction event for use with appdash. It is
+// recorded by Driver on the span of each traced transaction, whose children
+// are the spans of the statements executed in the transaction.
+//
+// Locks taken by the transaction's statements are held from the statement
+// that acquires them until the transaction ends, so a long IdleDuration,
+// during which the application holds the transaction open without using it,
+// blocks other transactions. Time spent waiting for locks held by other
+// transactions is not observable through database/sql; it is recorded in
+// LockWaitDuration if the Driver's LockWait hook is set, and is otherwise
+// only part of StatementDuration.
+type TxEvent struct {
+	// Outcome is "commit" or "rollback".
+	Outcome string `trace:"Tx.Outcome"`
+
+	// Error is the error returned by the commit or rollback, if any. A
+	// transaction that failed to commit is rolled back.
+	Error string `trace:"Tx.Error"`
+
+	// ReadOnly is whether the transaction was begun as read-only.
+	ReadOnly bool `trace:"Tx.ReadOnly"`
+
+	// Statements is the number of statements executed in the
+	// transaction.
+	Statements int `trace:"Tx.Statements"`
+
+	// BeginDuration and EndDuration are the durations of beginning and
+	// committing (or rolling back) the transaction.
+	BeginDuration time.Duration `trace:"Tx.BeginDuration"`
+	EndDuration   time.Duration `trace:"Tx.EndDuration"`
+
+	// StatementDuration is the total duration of the statements executed
+	// in the transaction.
+	StatementDuration time.Duration `trace:"Tx.StatementDuration"`
+
+	// LockWaitDuration is the part of StatementDuration that the
+	// statements spent waiting for locks held by other transactions, as
+	// reported by Driver.LockWait. It is zero if LockWait is nil.
+	LockWaitDuration time.Duration `trace:"Tx.LockWaitDuration"`
+
+	// IdleDuration is the rest of the transaction's duration, during
+	// which it was open but not in use by the database.
+	IdleDuration time.Duration `trace:"Tx.IdleDuration"`
+
+	TxStart time.Time `trace:"Tx.Start"`
+	TxEnd   time.Time `trace:"Tx.End"`
+}
+
+// Schema implements the appdash Event interface by returning this event's
+// constant schema string, "SQLTx".
+func (TxEvent) Schema() string { return "SQLTx" }
+
+// Important implements the appdash ImportantEvent by returning the outcome,
+// statement count, idle duration and lock wait duration keys.
+func (TxEvent) Important() []string {
+	return []string{"Tx.Outcome", "Tx.Statements", "Tx.IdleDuration", "Tx.LockWaitDuration"}
+}
+
+// Start implements the appdash TimespanEvent interface by returning the time
+// at which the transaction was begun.
+func (e TxEvent) Start() time.Time { return e.TxStart }
+
+// End implements the appdash TimespanEvent interface by returning the time at
+// which the transaction was committed or rolled back.
+func (e TxEvent) End() time.Time { return e.TxEnd }
+
+func init() {
+	appdash.RegisterEvent(SQLEvent{})
+	appdash.RegisterEvent(TxEvent{})
+}
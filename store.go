@@ -1,6 +1,7 @@
 package appdash
 
 import (
+	"context"
 	"encoding/gob"
 	"errors"
 	"io"
@@ -24,6 +25,10 @@ var (
 	// ErrTraceNotFound is returned by Store.GetTrace when no trace is
 	// found with the given ID.
 	ErrTraceNotFound = errors.New("trace not found")
+
+	// ErrStoreClosed is returned by the methods of a store that has
+	// been closed.
+	ErrStoreClosed = errors.New("store is closed")
 )
 
 // A Queryer indexes spans and makes them queryable.
@@ -50,21 +55,50 @@ type MemoryStore struct {
 
 	sync.Mutex // protects trace
 
+	// Conflicts is how annotations collected for a span that already
+	// has annotations with the same keys are merged. It must be set
+	// before the store is used.
+	Conflicts ConflictPolicy
+
 	log bool
+
+	closed bool // whether Close has been called
 }
 
 // Compile-time "implements" check.
 var _ interface {
 	Store
 	Queryer
+	ContextCollector
+	ContextStore
+	ContextQueryer
 } = (*MemoryStore)(nil)
 
+// CollectContext implements the ContextCollector interface.
+func (ms *MemoryStore) CollectContext(ctx context.Context, id SpanID, as ...Annotation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ms.Collect(id, as...)
+}
+
 // Collect implements the Collector interface by collecting the events that
 // occured in the span in-memory.
 func (ms *MemoryStore) Collect(id SpanID, as ...Annotation) error {
 	ms.Lock()
 	defer ms.Unlock()
 
+	if ms.closed {
+		return ErrStoreClosed
+	}
+
+	// Copy the annotations, since the caller may reuse them.
+	return ms.collectNoLock(id, Annotations(as).clone())
+}
+
+// collectNoLock collects the annotations, which it retains, on the span
+// id. The ms.Mutex lock must be held while calling collectNoLock.
+func (ms *MemoryStore) collectNoLock(id SpanID, as Annotations) error {
 	if ms.log {
 		log.Printf("Collect %v", id)
 	}
@@ -77,7 +111,7 @@ func (ms *MemoryStore) Collect(id SpanID, as ...Annotation) error {
 	// Create or update span.
 	s, present := ms.span[id.Trace][id.Span]
 	if !present {
-		s = &Trace{Span: Span{ID: id, Annotations: as}}
+		s = &Trace{Span: Span{ID: id, Annotations: ms.Conflicts.merge(nil, as)}}
 		ms.span[id.Trace][id.Span] = s
 	} else {
 		if ms.log {
@@ -85,7 +119,7 @@ func (ms *MemoryStore) Collect(id SpanID, as ...Annotation) error {
 				log.Printf("Add %d annotations to %v", len(as), id)
 			}
 		}
-		s.Annotations = append(s.Annotations, as...)
+		s.Annotations = ms.Conflicts.merge(s.Annotations, as)
 		return nil
 	}
 
@@ -202,7 +236,18 @@ func (ms *MemoryStore) Trace(id ID) (*Trace, error) {
 	return ms.traceNoLock(id)
 }
 
+// TraceContext implements the ContextStore interface.
+func (ms *MemoryStore) TraceContext(ctx context.Context, id ID) (*Trace, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ms.Trace(id)
+}
+
 func (ms *MemoryStore) traceNoLock(id ID) (*Trace, error) {
+	if ms.closed {
+		return nil, ErrStoreClosed
+	}
 	t, present := ms.trace[id]
 	if !present {
 		return nil, ErrTraceNotFound
@@ -212,11 +257,28 @@ func (ms *MemoryStore) traceNoLock(id ID) (*Trace, error) {
 
 // Traces implements the Queryer interface.
 func (ms *MemoryStore) Traces() ([]*Trace, error) {
+	return ms.TracesContext(context.Background())
+}
+
+// TracesContext implements the ContextQueryer interface.
+func (ms *MemoryStore) TracesContext(ctx context.Context) ([]*Trace, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	ms.Lock()
 	defer ms.Unlock()
 
+	if ms.closed {
+		return nil, ErrStoreClosed
+	}
 	var ts []*Trace
 	for id := range ms.trace {
+		if len(ts)%1000 == 999 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
 		t, err := ms.traceNoLock(id)
 		if err != nil {
 			return nil, err
@@ -232,6 +294,9 @@ func (ms *MemoryStore) Delete(traces ...ID) error {
 	ms.Lock()
 	defer ms.Unlock()
 
+	if ms.closed {
+		return ErrStoreClosed
+	}
 	for _, id := range traces {
 		delete(ms.trace, id)
 		delete(ms.span, id)
@@ -239,6 +304,21 @@ func (ms *MemoryStore) Delete(traces ...ID) error {
 	return nil
 }
 
+// Close discards all of the traces in the store. After Close, all of the
+// store's methods return ErrStoreClosed.
+func (ms *MemoryStore) Close() error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	if ms.closed {
+		return ErrStoreClosed
+	}
+	ms.closed = true
+	ms.trace = nil
+	ms.span = nil
+	return nil
+}
+
 type memoryStoreData struct {
 	Trace map[ID]*Trace
 	Span  map[ID]map[ID]*Trace
@@ -250,6 +330,9 @@ func (ms *MemoryStore) Write(w io.Writer) error {
 	ms.Lock()
 	defer ms.Unlock()
 
+	if ms.closed {
+		return ErrStoreClosed
+	}
 	data := memoryStoreData{ms.trace, ms.span}
 	return gob.NewEncoder(w).Encode(data)
 }
@@ -309,10 +392,18 @@ type DeleteStore interface {
 
 // A RecentStore wraps another store and deletes old traces after a
 // specified amount of time.
+//
+// Traces with a retention hint (see RetentionEvent) are deleted after
+// the hinted amount of time instead.
 type RecentStore struct {
 	// MinEvictAge is the minimum age of a trace before it is evicted.
 	MinEvictAge time.Duration
 
+	// MaxHintAge, if non-zero, is the maximum amount of time that a
+	// retention hint may keep a trace for. Longer hints are reduced to
+	// it.
+	MaxHintAge time.Duration
+
 	// DeleteStore is the underlying store that spans are saved to and
 	// deleted from.
 	DeleteStore
@@ -323,15 +414,30 @@ type RecentStore struct {
 	// created maps trace ID to the UnixNano time it was first seen.
 	created map[ID]int64
 
+	// keepFor maps trace ID to the retention hint for the trace, if
+	// any.
+	keepFor map[ID]time.Duration
+
+	// minKeepFor is the shortest retention hint of the traces that
+	// were not yet evicted at the last eviction (or were collected
+	// since), if shorter than MinEvictAge.
+	minKeepFor time.Duration
+
 	// lastEvicted is the last time the eviction process was run.
 	lastEvicted time.Time
 
-	mu sync.Mutex // mu guards created and lastEvicted
+	mu sync.Mutex // mu guards created, keepFor, minKeepFor and lastEvicted
 }
 
 // Collect calls the underlying store's Collect and records the time
 // that this trace was first seen.
 func (rs *RecentStore) Collect(id SpanID, anns ...Annotation) error {
+	return rs.CollectContext(context.Background(), id, anns...)
+}
+
+// CollectContext implements the ContextCollector interface. It is like
+// Collect, but passes ctx to the underlying store (see CollectContext).
+func (rs *RecentStore) CollectContext(ctx context.Context, id SpanID, anns ...Annotation) error {
 	rs.mu.Lock()
 	if rs.created == nil {
 		rs.created = map[ID]int64{}
@@ -339,25 +445,73 @@ func (rs *RecentStore) Collect(id SpanID, anns ...Annotation) error {
 	if _, present := rs.created[id.Trace]; !present {
 		rs.created[id.Trace] = time.Now().UnixNano()
 	}
-	if time.Since(rs.lastEvicted) > rs.MinEvictAge {
-		rs.evictBefore(time.Now().Add(-1 * rs.MinEvictAge))
+	if d, ok := retentionHint(anns); ok {
+		rs.setKeepFor(id.Trace, d)
+	}
+	interval := rs.MinEvictAge
+	if rs.minKeepFor > 0 && rs.minKeepFor < interval {
+		interval = rs.minKeepFor
+		if interval < minHintEvictInterval {
+			interval = minHintEvictInterval
+		}
+		if interval > rs.MinEvictAge {
+			interval = rs.MinEvictAge
+		}
+	}
+	if time.Since(rs.lastEvicted) > interval {
+		rs.evictExpired(time.Now())
 	}
 	rs.mu.Unlock()
 
-	return rs.DeleteStore.Collect(id, anns...)
+	return CollectContext(ctx, rs.DeleteStore, id, anns...)
+}
+
+// minHintEvictInterval is the minimum interval between evictions run
+// early because of retention hints shorter than MinEvictAge, so that
+// clients cannot make every Collect scan for expired traces by sending
+// tiny hints. Such hints are honored to within this interval.
+const minHintEvictInterval = time.Second
+
+// setKeepFor records a retention hint for a trace. The rs.mu lock must
+// be held while calling setKeepFor.
+func (rs *RecentStore) setKeepFor(trace ID, d time.Duration) {
+	if rs.MaxHintAge > 0 && d > rs.MaxHintAge {
+		d = rs.MaxHintAge
+	}
+	if rs.keepFor == nil {
+		rs.keepFor = map[ID]time.Duration{}
+	}
+	if cur, ok := rs.keepFor[trace]; ok && cur >= d {
+		return
+	}
+	rs.keepFor[trace] = d
+	if d < rs.MinEvictAge && (rs.minKeepFor == 0 || d < rs.minKeepFor) {
+		rs.minKeepFor = d
+	}
 }
 
-// evictBefore evicts traces that were created before t. The rs.mu lock
-// must be held while calling evictBefore.
-func (rs *RecentStore) evictBefore(t time.Time) {
+// evictExpired evicts traces that are older than MinEvictAge (or their
+// retention hint) at time now. The rs.mu lock must be held while
+// calling evictExpired.
+func (rs *RecentStore) evictExpired(now time.Time) {
 	evictStart := time.Now()
 	rs.lastEvicted = evictStart
-	tnano := t.UnixNano()
 	var toEvict []ID
+	rs.minKeepFor = 0
 	for id, ct := range rs.created {
-		if ct < tnano {
+		age := rs.MinEvictAge
+		d, hinted := rs.keepFor[id]
+		if hinted {
+			age = d
+		}
+		if ct < now.Add(-age).UnixNano() {
 			toEvict = append(toEvict, id)
 			delete(rs.created, id)
+			delete(rs.keepFor, id)
+			continue
+		}
+		if hinted && d < rs.MinEvictAge && (rs.minKeepFor == 0 || d < rs.minKeepFor) {
+			rs.minKeepFor = d
 		}
 	}
 	if len(toEvict) == 0 {
@@ -365,7 +519,7 @@ func (rs *RecentStore) evictBefore(t time.Time) {
 	}
 
 	if rs.Debug {
-		log.Printf("RecentStore: deleting %d traces created before %s (age check took %s)", len(toEvict), t, time.Since(evictStart))
+		log.Printf("RecentStore: deleting %d expired traces (age check took %s)", len(toEvict), time.Since(evictStart))
 	}
 
 	// Spawn separate goroutine so we don't hold the rs.mu lock.
@@ -375,7 +529,7 @@ func (rs *RecentStore) evictBefore(t time.Time) {
 			log.Printf("RecentStore: failed to delete traces: %s", err)
 		}
 		if rs.Debug {
-			log.Printf("RecentStore: finished deleting %d traces created before %s (took %s)", len(toEvict), t, time.Since(deleteStart))
+			log.Printf("RecentStore: finished deleting %d expired traces (took %s)", len(toEvict), time.Since(deleteStart))
 		}
 	}()
 }
@@ -314,6 +314,24 @@ func (t *Trace) sortSubRecursive() {
 	}
 }
 
+func TestMemoryStore_Close(t *testing.T) {
+	ms := storeT{t, NewMemoryStore()}
+	ms.MustCollect(SpanID{1, 2, 0})
+
+	if err := ms.Store.(*MemoryStore).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Collect(SpanID{1, 2, 0}); err != ErrStoreClosed {
+		t.Errorf("Collect: got err %v, want ErrStoreClosed", err)
+	}
+	if _, err := ms.Trace(1); err != ErrStoreClosed {
+		t.Errorf("Trace: got err %v, want ErrStoreClosed", err)
+	}
+	if _, err := ms.Store.(*MemoryStore).Traces(); err != ErrStoreClosed {
+		t.Errorf("Traces: got err %v, want ErrStoreClosed", err)
+	}
+}
+
 func TestRecentStore(t *testing.T) {
 	const age = time.Millisecond * 10
 
@@ -340,6 +358,92 @@ func TestRecentStore(t *testing.T) {
 	}
 }
 
+func TestRecentStore_retentionHint(t *testing.T) {
+	const age = time.Hour
+
+	ms := NewMemoryStore()
+	recent := &RecentStore{DeleteStore: ms, MinEvictAge: age}
+	rs := &storeT{t, recent}
+
+	keep := func(d time.Duration) []Annotation {
+		anns, err := MarshalEvent(KeepFor(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return anns
+	}
+	// elapse makes d pass for the traces collected so far and since the
+	// last eviction.
+	elapse := func(d time.Duration) {
+		recent.mu.Lock()
+		defer recent.mu.Unlock()
+		for id := range recent.created {
+			recent.created[id] -= int64(d)
+		}
+		recent.lastEvicted = recent.lastEvicted.Add(-d)
+	}
+	// waitTraces waits for the asynchronous deletion of evicted traces
+	// to leave the traces want in the store.
+	waitTraces := func(want map[ID]bool) {
+		t.Helper()
+		var got map[ID]bool
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			traces, _ := ms.Traces()
+			got = map[ID]bool{}
+			for _, t := range traces {
+				got[t.ID.Trace] = true
+			}
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+		}
+		t.Errorf("got traces %v, want %v", got, want)
+	}
+
+	rs.MustCollect(SpanID{1, 2, 3}, keep(2*age)...)
+	rs.MustCollect(SpanID{2, 3, 4})
+	rs.MustCollect(SpanID{3, 4, 5}, keep(10*time.Minute)...)
+
+	// The shortest hint is shorter than MinEvictAge, so it determines
+	// how often traces are evicted.
+	elapse(11 * time.Minute)
+	rs.MustCollect(SpanID{4, 5, 6})
+	waitTraces(map[ID]bool{1: true, 2: true, 4: true})
+
+	// Once the trace with the short hint is evicted, MinEvictAge
+	// determines how often traces are evicted again.
+	elapse(11 * time.Minute)
+	last := recent.lastEvicted
+	rs.MustCollect(SpanID{4, 6, 5})
+	if recent.lastEvicted != last {
+		t.Error("traces were evicted after the shortest hint's trace was evicted")
+	}
+
+	elapse(age)
+	rs.MustCollect(SpanID{5, 6, 7})
+	waitTraces(map[ID]bool{1: true, 5: true})
+}
+
+func TestRecentStore_tinyRetentionHint(t *testing.T) {
+	recent := &RecentStore{DeleteStore: NewMemoryStore(), MinEvictAge: time.Hour}
+	rs := &storeT{t, recent}
+
+	anns, err := MarshalEvent(KeepFor(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.MustCollect(SpanID{1, 2, 3}, anns...)
+
+	// Tiny hints do not make every Collect scan for expired traces.
+	last := recent.lastEvicted
+	for i := 0; i < 10; i++ {
+		rs.MustCollect(SpanID{2, ID(i + 1), 0}, anns...)
+	}
+	if recent.lastEvicted != last {
+		t.Error("traces were evicted within minHintEvictInterval of the last eviction")
+	}
+}
+
 func compareTraces(a, b *Trace) (diff []string) {
 	var cmp func(parent ID, a, b *Trace)
 	cmp = func(parent ID, a, b *Trace) {
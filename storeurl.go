@@ -0,0 +1,120 @@
+package appdash
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A StoreOpener opens the store described by a URL whose scheme it was
+// registered for (see RegisterStore). Backend-specific options are taken
+// from the URL's host, path and query.
+type StoreOpener func(u *url.URL) (Store, error)
+
+var (
+	storeOpenersMu sync.Mutex
+	storeOpeners   = map[string]StoreOpener{"memory": openMemoryStore}
+)
+
+// RegisterStore makes a store backend available to OpenStore under the
+// given URL scheme. It is typically called from the init function of the
+// package that implements the backend. It panics if the scheme is
+// already registered.
+func RegisterStore(scheme string, open StoreOpener) {
+	storeOpenersMu.Lock()
+	defer storeOpenersMu.Unlock()
+	if _, dup := storeOpeners[scheme]; dup {
+		panic("appdash: RegisterStore called twice for scheme " + scheme)
+	}
+	storeOpeners[scheme] = open
+}
+
+// StoreSchemes returns the URL schemes of the registered store backends,
+// sorted.
+func StoreSchemes() []string {
+	storeOpenersMu.Lock()
+	defer storeOpenersMu.Unlock()
+	schemes := make([]string, 0, len(storeOpeners))
+	for s := range storeOpeners {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// OpenStore opens the store described by rawurl, using the backend
+// registered for its scheme. The "memory" scheme is always available
+// (see NewMemoryStore):
+//
+//	memory://                                an empty, unpersisted store
+//	memory:///tmp/appdash.gob                loaded from and persisted to a file
+//	memory:///tmp/appdash.gob?persist=10s    ... every 10s (default 2s; 0 to only load)
+//	memory://?conflicts=last-writer-wins     with the given ConflictPolicy
+//
+// If persisting a memory store to its file fails, the program exits, so
+// that it doesn't go on without saving the traces it collects.
+func OpenStore(rawurl string) (Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	storeOpenersMu.Lock()
+	open, ok := storeOpeners[u.Scheme]
+	storeOpenersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("appdash: unknown store scheme %q in %q (registered: %v)", u.Scheme, rawurl, StoreSchemes())
+	}
+	return open(u)
+}
+
+// openMemoryStore opens a "memory" store URL (see OpenStore).
+func openMemoryStore(u *url.URL) (Store, error) {
+	if u.Host != "" {
+		return nil, fmt.Errorf("appdash: memory store URL %q must not have a host (use memory:///path for a file)", u)
+	}
+	ms := NewMemoryStore()
+	if s := u.Query().Get("conflicts"); s != "" {
+		var err error
+		ms.Conflicts, err = ParseConflictPolicy(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if u.Path == "" {
+		return ms, nil
+	}
+
+	persist := 2 * time.Second
+	if s := u.Query().Get("persist"); s != "" {
+		var err error
+		persist, err = time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("appdash: invalid persist interval in memory store URL %q: %s", u, err)
+		}
+	}
+
+	f, err := os.Open(u.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if f != nil {
+		n, err := ms.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("appdash: reading memory store from %s: %s", u.Path, err)
+		}
+		log.Printf("Read %d traces from file %s", n, u.Path)
+	}
+	if persist > 0 {
+		go func() {
+			if err := PersistEvery(ms, persist, u.Path); err != nil {
+				log.Fatalf("appdash: persisting memory store to %s failed: %s", u.Path, err)
+			}
+		}()
+	}
+	return ms, nil
+}
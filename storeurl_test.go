@@ -0,0 +1,81 @@
+package appdash
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenStore_memory(t *testing.T) {
+	s, err := OpenStore("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*MemoryStore); !ok {
+		t.Errorf("got %T, want *MemoryStore", s)
+	}
+
+	s, err = OpenStore("memory://?conflicts=last-writer-wins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := s.(*MemoryStore).Conflicts; p != LastWriterWins {
+		t.Errorf("got conflict policy %v, want %v", p, LastWriterWins)
+	}
+
+	// A file-backed store is loaded from its file.
+	dir, err := ioutil.TempDir("", "appdash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "store.gob")
+	ms := NewMemoryStore()
+	if err := ms.Collect(SpanID{Trace: 1, Span: 1}, Annotation{Key: "Name", Value: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s, err = OpenStore("memory://" + file + "?persist=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Trace(1); err != nil {
+		t.Errorf("trace not loaded from %s: %s", file, err)
+	}
+
+	for _, bad := range []string{"memory://host/path", "memory:///path?persist=x", "memory://?conflicts=x"} {
+		if _, err := OpenStore(bad); err == nil {
+			t.Errorf("OpenStore(%q): got no error", bad)
+		}
+	}
+}
+
+func TestOpenStore_registered(t *testing.T) {
+	var got *url.URL
+	RegisterStore("test-backend", func(u *url.URL) (Store, error) {
+		got = u
+		return NewMemoryStore(), nil
+	})
+	if _, err := OpenStore("test-backend://db.example.com/traces?timeout=5s"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Host != "db.example.com" || got.Path != "/traces" || got.Query().Get("timeout") != "5s" {
+		t.Errorf("opener got URL %v", got)
+	}
+
+	_, err := OpenStore("nosuch://")
+	if err == nil || !strings.Contains(err.Error(), "test-backend") {
+		t.Errorf("got error %v, want one listing the registered schemes", err)
+	}
+}
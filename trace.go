@@ -37,6 +37,29 @@ func (t *Trace) FindSpan(spanID ID) *Trace {
 	return nil
 }
 
+// Orphans returns the spans in t (and their subtrees) whose parent span
+// is not in t, because it has not been collected (yet). Stores attach
+// such spans to the root of their trace until their parent arrives, at
+// which point they are moved under it, so orphans whose parent never
+// arrives would otherwise be indistinguishable from the root's children.
+//
+// If the trace's root span has not been collected either, t's root is a
+// span whose parent is missing (t.ID.Parent != 0); it is not included.
+func (t *Trace) Orphans() []*Trace {
+	var orphans []*Trace
+	var walk func(*Trace)
+	walk = func(p *Trace) {
+		for _, c := range p.Sub {
+			if c.Span.ID.Parent != p.Span.ID.Span {
+				orphans = append(orphans, c)
+			}
+			walk(c)
+		}
+	}
+	walk(t)
+	return orphans
+}
+
 // TreeString returns the Trace as a formatted string that visually
 // represents the trace's tree.
 func (t *Trace) TreeString() string {
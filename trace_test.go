@@ -1,6 +1,9 @@
 package appdash
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestTrace_TreeString(t *testing.T) {
 	t.Skip("TODO")
@@ -90,3 +93,39 @@ func TestTrace_FindSpan(t *testing.T) {
 		}
 	}
 }
+
+func TestTrace_Orphans(t *testing.T) {
+	ms := NewMemoryStore()
+	collect := func(id SpanID) {
+		if err := ms.Collect(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orphans := func() (ids []ID) {
+		x, err := ms.Trace(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range x.Orphans() {
+			ids = append(ids, o.Span.ID.Span)
+		}
+		return ids
+	}
+
+	collect(SpanID{1, 1, 0})
+	collect(SpanID{1, 3, 2}) // parent 2 arrives late
+	collect(SpanID{1, 5, 4}) // parent 4 never arrives
+	collect(SpanID{1, 6, 5})
+	if got, want := orphans(), []ID{3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got orphans %v, want %v", got, want)
+	}
+
+	collect(SpanID{1, 2, 1})
+	if got, want := orphans(), []ID{5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after collecting the late parent, got orphans %v, want %v", got, want)
+	}
+	x, _ := ms.Trace(1)
+	if s := x.FindSpan(2); s == nil || len(s.Sub) != 1 || s.Sub[0].Span.ID.Span != 3 {
+		t.Errorf("late parent's subtree is %+v, want span 3 re-parented under it", s)
+	}
+}
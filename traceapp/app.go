@@ -19,15 +19,18 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/elazarl/go-bindata-assetfs"
 	"github.com/gorilla/mux"
 
 	"sourcegraph.com/sourcegraph/appdash"
 	static "sourcegraph.com/sourcegraph/appdash-data"
+	"sourcegraph.com/sourcegraph/appdash/metrics"
 )
 
 // App is an HTTP application handler that also exposes methods for
@@ -38,8 +41,20 @@ type App struct {
 	Store   appdash.Store
 	Queryer appdash.Queryer
 
+	// Aggregator, if set, serves the top span names and compare APIs
+	// and the dashboard widget that displays them.
+	Aggregator *metrics.Aggregator
+
+	// Scorer ranks the traces in the trace list. If nil, DefaultScorer
+	// is used.
+	Scorer TraceScorer
+
 	tmplLock sync.Mutex
 	tmpls    map[string]*htmpl.Template
+
+	deployLock  sync.Mutex
+	deployTime  time.Time // when deployCache was loaded
+	deployCache []appdash.DeployEvent
 }
 
 // New creates a new application handler. If r is nil, a new router is
@@ -59,6 +74,12 @@ func New(r *Router) *App {
 	r.r.Get(TraceUploadRoute).Handler(handlerFunc(app.serveTraceUpload))
 	r.r.Get(TracesRoute).Handler(handlerFunc(app.serveTraces))
 	r.r.Get(AggregateRoute).Handler(handlerFunc(app.serveAggregate))
+	r.r.Get(TopRoute).Handler(handlerFunc(app.serveTop))
+	r.r.Get(CompareRoute).Handler(handlerFunc(app.serveCompare))
+	r.r.Get(SeriesRoute).Handler(handlerFunc(app.serveSeries))
+	r.r.Get(DeployRoute).Handler(handlerFunc(app.serveDeploy))
+	r.r.Get(ImportRoute).HandlerFunc(app.serveImport)
+	r.r.Get(StatusRoute).Handler(handlerFunc(app.serveStatus))
 
 	// Static file serving.
 	r.r.Get(StaticRoute).Handler(http.StripPrefix("/static/", http.FileServer(&assetfs.AssetFS{
@@ -78,7 +99,10 @@ func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (a *App) serveRoot(w http.ResponseWriter, r *http.Request) error {
 	return a.renderTemplate(w, r, "root.html", http.StatusOK, &struct {
 		TemplateCommon
-	}{})
+		Top bool
+	}{
+		Top: a.Aggregator != nil,
+	})
 }
 
 func (a *App) serveTrace(w http.ResponseWriter, r *http.Request) error {
@@ -89,7 +113,7 @@ func (a *App) serveTrace(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	trace, err := a.Store.Trace(traceID)
+	trace, err := appdash.TraceContext(r.Context(), a.Store, traceID)
 	if err != nil {
 		return err
 	}
@@ -131,38 +155,94 @@ func (a *App) serveTrace(w http.ResponseWriter, r *http.Request) error {
 
 	return a.renderTemplate(w, r, "trace.html", http.StatusOK, &struct {
 		TemplateCommon
-		Trace      *appdash.Trace
-		VisData    []timelineItem
-		ProfileURL string
+		Trace       *appdash.Trace
+		VisData     []timelineItem
+		ProfileURL  string
+		MissingRoot bool
+		Orphans     []*appdash.Trace
 	}{
-		Trace:      trace,
-		VisData:    visData,
-		ProfileURL: profile.String(),
+		Trace:       trace,
+		VisData:     visData,
+		ProfileURL:  profile.String(),
+		MissingRoot: v["Span"] == "" && trace.ID.Parent != 0,
+		Orphans:     trace.Orphans(),
 	})
 }
 
 func (a *App) serveTraces(w http.ResponseWriter, r *http.Request) error {
-	traces, err := a.Queryer.Traces()
+	// If they searched for an annotation, only show the traces that
+	// contain it. On stores that cannot filter traces themselves, the
+	// search gives up after scanning appdash.DefaultMaxScan spans and
+	// shows the traces found so far.
+	q := r.URL.Query()
+	filter := appdash.TraceFilter{Key: q.Get("key"), Value: q.Get("value")}
+	if re := q.Get("regexp"); re != "" {
+		var err error
+		filter.ValueRegexp, err = regexp.Compile(re)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return err
+		}
+	}
+	var (
+		traces    []*appdash.Trace
+		err       error
+		filtered  = filter.Key != "" || filter.Value != "" || filter.ValueRegexp != nil
+		truncated bool
+	)
+	if filtered {
+		traces, err = appdash.FilterTraces(r.Context(), a.Queryer, filter)
+		if errors.Is(err, appdash.ErrScanLimit) {
+			truncated, err = true, nil
+		}
+	} else {
+		traces, err = appdash.TracesContext(r.Context(), a.Queryer)
+	}
 	if err != nil {
 		return err
 	}
 
-	// Sort the traces by ID to ensure that the display order doesn't change upon
-	// multiple page reloads if Queryer.Traces is e.g. backed by a map (which has
-	// a random iteration order).
-	sort.Sort(tracesByID(traces))
+	// Rank the traces so that the most interesting ones come first, unless
+	// ordering by ID was requested. Ties are broken by ID to ensure that the
+	// display order doesn't change upon multiple page reloads if
+	// Queryer.Traces is e.g. backed by a map (which has a random iteration
+	// order).
+	byID := r.URL.Query().Get("order") == "id"
+	if byID {
+		sort.Sort(tracesByID(traces))
+	} else {
+		scorer := a.Scorer
+		if scorer == nil {
+			scorer = DefaultScorer
+		}
+		rankTraces(traces, scorer)
+	}
 
 	return a.renderTemplate(w, r, "traces.html", http.StatusOK, &struct {
 		TemplateCommon
-		Traces []*appdash.Trace
+		Traces    []*appdash.Trace
+		ByID      bool
+		Key       string
+		Value     string
+		Regexp    string
+		Filtered  bool
+		Truncated bool
+		MaxScan   int
 	}{
-		Traces: traces,
+		Traces:    traces,
+		ByID:      byID,
+		Key:       filter.Key,
+		Value:     filter.Value,
+		Regexp:    q.Get("regexp"),
+		Filtered:  filtered,
+		Truncated: truncated,
+		MaxScan:   appdash.DefaultMaxScan,
 	})
 }
 
 func (a *App) serveAggregate(w http.ResponseWriter, r *http.Request) error {
 	// By default we display all traces.
-	traces, err := a.Queryer.Traces()
+	traces, err := appdash.TracesContext(r.Context(), a.Queryer)
 	if err != nil {
 		return err
 	}
@@ -219,14 +299,14 @@ func (a *App) serveTraceUpload(w http.ResponseWriter, r *http.Request) error {
 	// Collect the unmarshaled traces, ignoring any previously existing ones (i.e.
 	// ones that would collide / be merged together).
 	for _, trace := range traces {
-		_, err = a.Store.Trace(trace.Span.ID.Trace)
-		if err != appdash.ErrTraceNotFound {
+		_, err = appdash.TraceContext(r.Context(), a.Store, trace.Span.ID.Trace)
+		if !errors.Is(err, appdash.ErrTraceNotFound) {
 			// The trace collides with an existing trace, ignore it.
 			continue
 		}
 
 		// Collect the trace (store it for later viewing).
-		if err = collectTrace(a.Store, trace); err != nil {
+		if err = collectTrace(r.Context(), a.Store, trace); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,190 @@
+package traceapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/metrics"
+)
+
+// seriesPoint is a single time bucket in the series API's response.
+// Latencies are in milliseconds.
+type seriesPoint struct {
+	Time   time.Time `json:"time"`
+	Count  int64     `json:"count"`
+	Errors int64     `json:"errors"`
+	P50    float64   `json:"p50"`
+	P99    float64   `json:"p99"`
+	Heat   []int64   `json:"heat"`
+}
+
+// deployMarker is a deploy marker in the series API's response, and the
+// request body of the deploy API.
+type deployMarker struct {
+	Service string    `json:"service"`
+	Version string    `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// serveSeries serves the latency and error time series of all spans, the
+// latency heatmap bands and the deploy markers over a recent window, as
+// JSON. The series only cover the Aggregator's Window (see
+// metrics.Aggregator.Oldest), but the deploy markers are also loaded from
+// the store, so they survive restarts for as long as it retains them. It
+// accepts the query parameter:
+//
+//	window  the window, as a Go duration (default 1h)
+func (a *App) serveSeries(w http.ResponseWriter, r *http.Request) error {
+	if a.Aggregator == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return errors.New("no aggregator is configured")
+	}
+
+	window := time.Hour
+	if s := r.URL.Query().Get("window"); s != "" {
+		var err error
+		window, err = time.ParseDuration(s)
+		if err != nil || window <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return errors.New("invalid window " + s)
+		}
+	}
+	start := time.Now().Add(-window)
+
+	var resp struct {
+		Bands   []float64      `json:"bands"` // upper bounds, in milliseconds
+		Points  []seriesPoint  `json:"points"`
+		Deploys []deployMarker `json:"deploys"`
+	}
+	for _, b := range metrics.HeatBands {
+		resp.Bands = append(resp.Bands, msec(b))
+	}
+	resp.Points = []seriesPoint{}
+	for _, p := range a.Aggregator.Series(start, time.Time{}) {
+		resp.Points = append(resp.Points, seriesPoint{
+			Time:   p.Time,
+			Count:  p.Count,
+			Errors: p.Errors,
+			P50:    msec(p.P50),
+			P99:    msec(p.P99),
+			Heat:   p.Heat,
+		})
+	}
+	deploys, err := a.deploys(r.Context(), start)
+	if err != nil {
+		return err
+	}
+	resp.Deploys = []deployMarker{}
+	for _, d := range deploys {
+		resp.Deploys = append(resp.Deploys, deployMarker{Service: d.Service, Version: d.Version, Time: d.Time})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// deployCacheTTL is how long the deploy markers loaded from the store are
+// reused before the store is scanned again.
+const deployCacheTTL = 30 * time.Second
+
+// deploys returns the deploy markers from start until now that are in
+// the App's Aggregator or in its store, in time order. If the store
+// cannot filter traces itself, only the markers found within
+// appdash.DefaultMaxScan spans are loaded from it. The markers loaded
+// from the store are cached for deployCacheTTL; new markers recorded
+// through the Aggregator show up immediately.
+func (a *App) deploys(ctx context.Context, start time.Time) ([]appdash.DeployEvent, error) {
+	type key struct {
+		service, version string
+		time             int64
+	}
+	seen := map[key]bool{}
+	var deploys []appdash.DeployEvent
+	add := func(d appdash.DeployEvent) {
+		k := key{d.Service, d.Version, d.Time.UnixNano()}
+		if d.Time.Before(start) || seen[k] {
+			return
+		}
+		seen[k] = true
+		deploys = append(deploys, d)
+	}
+
+	for _, d := range a.Aggregator.Deploys(start, time.Time{}) {
+		add(d)
+	}
+	if a.Queryer != nil {
+		stored, err := a.storedDeploys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range stored {
+			add(d)
+		}
+	}
+	sort.SliceStable(deploys, func(i, j int) bool { return deploys[i].Time.Before(deploys[j].Time) })
+	return deploys, nil
+}
+
+// storedDeploys returns all of the deploy markers in the App's store,
+// scanning it at most once per deployCacheTTL.
+func (a *App) storedDeploys(ctx context.Context) ([]appdash.DeployEvent, error) {
+	a.deployLock.Lock()
+	defer a.deployLock.Unlock()
+	if !a.deployTime.IsZero() && time.Since(a.deployTime) < deployCacheTTL {
+		return a.deployCache, nil
+	}
+
+	traces, err := appdash.FilterTraces(ctx, a.Queryer, appdash.TraceFilter{Key: "Deploy.Service"})
+	if err != nil && !errors.Is(err, appdash.ErrScanLimit) {
+		return nil, err
+	}
+	var deploys []appdash.DeployEvent
+	for _, t := range traces {
+		var d appdash.DeployEvent
+		if err := appdash.UnmarshalEvent(t.Span.Annotations, &d); err == nil && d.Service != "" {
+			deploys = append(deploys, d)
+		}
+	}
+	a.deployCache, a.deployTime = deploys, time.Now()
+	return deploys, nil
+}
+
+// serveDeploy records a deploy marker (see appdash.DeployEvent). The
+// request body is a JSON object such as:
+//
+//	{"service": "web", "version": "v1.2.3", "time": "2015-03-01T12:00:00Z"}
+//
+// The time is optional and defaults to now. The response is the ID of
+// the trace that the marker was recorded as.
+func (a *App) serveDeploy(w http.ResponseWriter, r *http.Request) error {
+	defer r.Body.Close()
+	var d deployMarker
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	if d.Service == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return errors.New("deploy marker has no service")
+	}
+
+	// Record the marker through the aggregator, if any, so that it shows
+	// up on the dashboard.
+	var c appdash.Collector = a.Store
+	if a.Aggregator != nil {
+		c = a.Aggregator
+	}
+	id, err := appdash.RecordDeploy(c, d.Service, d.Version, d.Time)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(struct {
+		Trace string `json:"trace"`
+	}{id.Trace.String()})
+}
@@ -0,0 +1,79 @@
+package traceapp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/metrics"
+)
+
+func TestApp_deploys(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	now := time.Now()
+
+	// A marker recorded before a restart is only in the store.
+	if _, err := appdash.RecordDeploy(ms, "web", "v1", now.Add(-10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appdash.RecordDeploy(ms, "web", "v0", now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	agg := &metrics.Aggregator{Collector: ms}
+	if _, err := appdash.RecordDeploy(agg, "api", "v2", now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &App{Store: ms, Queryer: ms, Aggregator: agg}
+	deploys, err := a.deploys(context.Background(), now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range deploys {
+		got = append(got, d.Service+" "+d.Version)
+	}
+	if want := []string{"web v1", "api v2"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got deploys %q, want %q", got, want)
+	}
+}
+
+func TestApp_deploysCached(t *testing.T) {
+	ms := appdash.NewMemoryStore()
+	now := time.Now()
+	if _, err := appdash.RecordDeploy(ms, "web", "v1", now.Add(-10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	agg := &metrics.Aggregator{Collector: ms}
+	a := &App{Store: ms, Queryer: ms, Aggregator: agg}
+	count := func() int {
+		deploys, err := a.deploys(context.Background(), now.Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(deploys)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("got %d deploys, want 1", n)
+	}
+
+	// A marker recorded straight into the store is not seen until the
+	// cache expires, but one recorded through the aggregator is.
+	if _, err := appdash.RecordDeploy(ms, "web", "v2", now.Add(-5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("got %d deploys before the cache expired, want 1", n)
+	}
+	if _, err := appdash.RecordDeploy(agg, "api", "v3", now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("got %d deploys after recording through the aggregator, want 2", n)
+	}
+	a.deployTime = a.deployTime.Add(-deployCacheTTL)
+	if n := count(); n != 3 {
+		t.Errorf("got %d deploys after the cache expired, want 3", n)
+	}
+}
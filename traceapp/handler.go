@@ -1,10 +1,13 @@
 package traceapp
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"runtime/debug"
+
+	"sourcegraph.com/sourcegraph/appdash"
 )
 
 type handlerFunc func(http.ResponseWriter, *http.Request) error
@@ -14,6 +17,12 @@ func (h handlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var rb responseBuffer
 
 	handleError := func(err error) {
+		switch {
+		case errors.Is(err, appdash.ErrTraceNotFound):
+			rb.Status = http.StatusNotFound
+		case rb.Status < 400:
+			rb.Status = http.StatusInternalServerError
+		}
 		log.Printf("%s %s: HTTP %d: %s", r.Method, r.URL.RequestURI(), rb.Status, err.Error())
 
 		// Never cache error responses.
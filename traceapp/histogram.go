@@ -0,0 +1,60 @@
+package traceapp
+
+import (
+	"fmt"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// histogramView is a HistogramEvent prepared for display by the templates.
+type histogramView struct {
+	appdash.HistogramEvent
+	Buckets []histogramBucket
+}
+
+type histogramBucket struct {
+	Label   string
+	Count   int64
+	Percent int // of the largest bucket, for the bar width
+}
+
+// histogram returns the histogram recorded in the given span annotations, or
+// nil if there is none.
+func histogram(anns appdash.Annotations) (*histogramView, error) {
+	var events []appdash.Event
+	if err := appdash.UnmarshalEvents(anns, &events); err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		h, ok := e.(appdash.HistogramEvent)
+		if !ok {
+			continue
+		}
+
+		v := &histogramView{HistogramEvent: h}
+		var max int64
+		for _, c := range h.Counts {
+			if c > max {
+				max = c
+			}
+		}
+		for i, c := range h.Counts {
+			var label string
+			switch {
+			case i < len(h.Bounds):
+				label = fmt.Sprintf("≤ %s", h.Bounds[i])
+			case len(h.Bounds) > 0:
+				label = fmt.Sprintf("> %s", h.Bounds[len(h.Bounds)-1])
+			default:
+				label = "all"
+			}
+			b := histogramBucket{Label: label, Count: c}
+			if max > 0 {
+				b.Percent = int(c * 100 / max)
+			}
+			v.Buckets = append(v.Buckets, b)
+		}
+		return v, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+package traceapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// serveImport bulk imports historical spans into the store (see
+// appdash.ImportSpans). The request body is a stream of JSON spans,
+// such as:
+//
+//	{"ID": {"Trace": "...", "Span": "...", "Parent": "..."}, "Annotations": [{"Key": "Name", "Value": "..."}]}
+//
+// Annotation values are base64-encoded. The optional batch query
+// parameter sets the number of spans added to the import at once. The
+// response is a stream of JSON progress reports, one per batch, and a
+// final one once the import is committed. Errors after the first
+// report are reported in a last one.
+//
+// Unlike the other handlers, serveImport does not buffer its response,
+// so that clients can follow the progress of long imports. Imported
+// spans bypass the App's Aggregator.
+func (a *App) serveImport(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	batchSize := 0
+	if s := r.URL.Query().Get("batch"); s != "" {
+		var err error
+		batchSize, err = strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid batch %q: %s", s, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	dec := json.NewDecoder(r.Body)
+	var decodeErr error
+	next := func() (*appdash.Span, error) {
+		var s appdash.Span
+		if err := dec.Decode(&s); err != nil {
+			if err != io.EOF {
+				decodeErr = fmt.Errorf("invalid span at offset %d: %w", dec.InputOffset(), err)
+				return nil, decodeErr
+			}
+			return nil, err
+		}
+		return &s, nil
+	}
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	wrote := false
+	_, err := appdash.ImportSpans(r.Context(), a.Store, next, batchSize, func(p appdash.ImportProgress) {
+		if !wrote {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			wrote = true
+		}
+		enc.Encode(importProgress{Spans: p.Spans, Batches: p.Batches, ElapsedMS: msec(p.Elapsed), Done: p.Done})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if err != nil {
+		log.Printf("%s %s: import: %s", r.Method, r.URL.RequestURI(), err)
+		if wrote {
+			enc.Encode(importProgress{Error: err.Error()})
+			return
+		}
+		status := http.StatusInternalServerError
+		if err == decodeErr {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// importProgress is a progress report of serveImport.
+type importProgress struct {
+	Spans     int64   `json:"spans"`
+	Batches   int64   `json:"batches"`
+	ElapsedMS float64 `json:"elapsedMS"`
+	Done      bool    `json:"done"`
+	Error     string  `json:"error,omitempty"`
+}
@@ -19,6 +19,12 @@ const (
 	TraceUploadRoute      = "traceapp.trace.upload"       // route name for a JSON trace upload
 	TracesRoute           = "traceapp.traces"             // route name for traces page
 	AggregateRoute        = "traceapp.aggregate"          // route name for aggregate trace view
+	TopRoute              = "traceapp.top"                // route name for JSON top span names
+	CompareRoute          = "traceapp.compare"            // route name for JSON span name deltas between two windows
+	SeriesRoute           = "traceapp.series"             // route name for JSON latency time series and deploy markers
+	DeployRoute           = "traceapp.deploy"             // route name for recording a deploy marker
+	ImportRoute           = "traceapp.import"             // route name for bulk importing historical spans
+	StatusRoute           = "traceapp.status"             // route name for JSON server health
 )
 
 // Router is a URL router for traceapp applications. It should be created via
@@ -39,6 +45,12 @@ func NewRouter(base *mux.Router) *Router {
 	base.Path("/traces/{Trace}/{Span}").Methods("GET").Name(TraceSpanRoute)
 	base.Path("/traces").Methods("GET").Name(TracesRoute)
 	base.Path("/aggregate").Methods("GET").Name(AggregateRoute)
+	base.Path("/api/top").Methods("GET").Name(TopRoute)
+	base.Path("/api/compare").Methods("GET").Name(CompareRoute)
+	base.Path("/api/series").Methods("GET").Name(SeriesRoute)
+	base.Path("/api/deploys").Methods("POST").Name(DeployRoute)
+	base.Path("/api/import").Methods("POST").Name(ImportRoute)
+	base.Path("/api/status").Methods("GET").Name(StatusRoute)
 	return &Router{base}
 }
 
@@ -0,0 +1,54 @@
+package traceapp
+
+import (
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// samplingView is a SamplingEvent prepared for display as a badge by the
+// templates.
+type samplingView struct {
+	appdash.SamplingEvent
+
+	// Class is the Bootstrap label class of the badge.
+	Class string
+
+	// Title explains what the decision means for how representative
+	// the trace is.
+	Title string
+}
+
+// sampling returns the sampling decision recorded in the given span
+// annotations, or nil if there is none.
+func sampling(anns appdash.Annotations) (*samplingView, error) {
+	var events []appdash.Event
+	if err := appdash.UnmarshalEvents(anns, &events); err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		s, ok := e.(appdash.SamplingEvent)
+		if !ok {
+			continue
+		}
+
+		v := &samplingView{SamplingEvent: s}
+		switch s.Sampler {
+		case appdash.SamplerHead:
+			v.Class = "label-default"
+			v.Title = "Kept by random sampling at the start of the trace; representative of all traffic."
+		case appdash.SamplerTail:
+			v.Class = "label-warning"
+			v.Title = "Kept by a rule after the trace completed; biased toward traces the rule selects."
+		case appdash.SamplerDebug:
+			v.Class = "label-danger"
+			v.Title = "Forced to be kept for debugging; not representative of normal traffic."
+		default:
+			v.Class = "label-info"
+			v.Title = "Kept by the " + s.Sampler + " sampler."
+		}
+		if s.Decision == appdash.DecisionForced && s.Sampler != appdash.SamplerDebug {
+			v.Title += " The sampler's decision was overridden."
+		}
+		return v, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,153 @@
+package traceapp
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/metrics"
+)
+
+// A TraceScorer scores traces for triage. The trace list displays
+// traces in order of descending score, so that the most interesting
+// ones come first.
+type TraceScorer interface {
+	// Score returns the scores of the traces, in the same order.
+	// Higher scores are more interesting. Scorers are given all of the
+	// listed traces at once, so that they can compare them. If Score
+	// returns a different number of scores, the traces are listed in
+	// ID order.
+	Score(traces []*appdash.Trace) []float64
+}
+
+// DefaultScorer is the TraceScorer used by an App whose Scorer is nil.
+// It ranks failed traces first, then slow and rare ones.
+var DefaultScorer TraceScorer = &WeightedScorer{Error: 4, Latency: 2, Rarity: 1}
+
+// WeightedScorer is a TraceScorer whose score for a trace is the
+// weighted sum of three signals, each between 0 and 1:
+//
+//   - error: 1 if any span in the trace failed, and 0 otherwise;
+//   - latency: the percentile of the trace's duration among the traces
+//     with the same name (i.e., the same endpoint);
+//   - rarity: the reciprocal of the number of traces with the trace's
+//     name, so that endpoints that are seldom hit stand out.
+//
+// A trace's name and duration are those of its root span (its largest
+// timespan event). Traces without a duration have a latency signal of
+// zero. Traces whose root span is ignored (see metrics.IsIgnored, e.g.
+// health checks) score zero and are left out of the latency and rarity
+// of the others.
+type WeightedScorer struct {
+	Error, Latency, Rarity float64
+
+	// IsError reports whether a span's events describe a failed
+	// operation. If nil, metrics.IsError is used.
+	IsError func([]appdash.Event) bool
+}
+
+// Score implements the TraceScorer interface.
+func (s *WeightedScorer) Score(traces []*appdash.Trace) []float64 {
+	isError := s.IsError
+	if isError == nil {
+		isError = metrics.IsError
+	}
+
+	names := make([]string, len(traces))
+	durations := make([]time.Duration, len(traces))
+	ignored := make([]bool, len(traces))
+	byName := map[string][]time.Duration{} // sorted durations of the traces with each name
+	counts := map[string]int{}
+	for i, t := range traces {
+		if ignored[i] = traceIgnored(t); ignored[i] {
+			continue
+		}
+		names[i] = t.Span.Name()
+		counts[names[i]]++
+		if d, ok := traceDuration(t); ok {
+			durations[i] = d
+			byName[names[i]] = append(byName[names[i]], d)
+		}
+	}
+	for _, ds := range byName {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	}
+
+	scores := make([]float64, len(traces))
+	for i, t := range traces {
+		if ignored[i] {
+			continue
+		}
+		var score float64
+		if s.Error != 0 && traceFailed(t, isError) {
+			score += s.Error
+		}
+		if ds := byName[names[i]]; durations[i] > 0 && len(ds) > 0 {
+			// The mid-rank percentile, so that a trace that is alone
+			// in its group (or tied with all of it) scores 0.5.
+			below := sort.Search(len(ds), func(j int) bool { return ds[j] >= durations[i] })
+			equal := sort.Search(len(ds), func(j int) bool { return ds[j] > durations[i] }) - below
+			score += s.Latency * (float64(below) + float64(equal)/2) / float64(len(ds))
+		}
+		score += s.Rarity / float64(counts[names[i]])
+		scores[i] = score
+	}
+	return scores
+}
+
+// traceDuration returns the duration of the trace's root span, which is
+// that of its largest timespan event. If it has none, ok is false.
+func traceDuration(t *appdash.Trace) (d time.Duration, ok bool) {
+	var events []appdash.Event
+	if err := appdash.UnmarshalEvents(t.Span.Annotations, &events); err != nil {
+		return 0, false
+	}
+	for _, e := range events {
+		if ts, isTimespan := e.(appdash.TimespanEvent); isTimespan {
+			if sd := ts.End().Sub(ts.Start()); !ok || sd > d {
+				d, ok = sd, true
+			}
+		}
+	}
+	return d, ok
+}
+
+// traceIgnored reports whether the trace's root span is ignored by
+// metrics (see metrics.IsIgnored).
+func traceIgnored(t *appdash.Trace) bool {
+	var events []appdash.Event
+	return appdash.UnmarshalEvents(t.Span.Annotations, &events) == nil && metrics.IsIgnored(events)
+}
+
+// traceFailed reports whether any span in the trace failed. Spans whose
+// events cannot be unmarshaled are not considered failed.
+func traceFailed(t *appdash.Trace, isError func([]appdash.Event) bool) bool {
+	var events []appdash.Event
+	if err := appdash.UnmarshalEvents(t.Span.Annotations, &events); err == nil && isError(events) {
+		return true
+	}
+	for _, sub := range t.Sub {
+		if traceFailed(sub, isError) {
+			return true
+		}
+	}
+	return false
+}
+
+// rankTraces sorts the traces in order of descending score, breaking
+// ties by ID. If the scorer does not return one score per trace, they
+// are left in ID order.
+func rankTraces(traces []*appdash.Trace, scorer TraceScorer) {
+	sort.Sort(tracesByID(traces))
+	scores := scorer.Score(traces)
+	if len(scores) != len(traces) {
+		log.Printf("trace scorer returned %d scores for %d traces; listing traces by ID", len(scores), len(traces))
+		return
+	}
+	byTrace := make(map[*appdash.Trace]float64, len(traces))
+	for i, t := range traces {
+		byTrace[t] = scores[i]
+	}
+	sort.SliceStable(traces, func(i, j int) bool { return byTrace[traces[i]] > byTrace[traces[j]] })
+}
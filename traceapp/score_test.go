@@ -0,0 +1,106 @@
+package traceapp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/appdash/httptrace"
+)
+
+// scoreTrace returns a single-span trace with the given ID, name,
+// duration and HTTP status.
+func scoreTrace(t *testing.T, id uint64, name string, d time.Duration, status int) *appdash.Trace {
+	start := time.Unix(100, 0)
+	anns, err := appdash.MarshalEvent(httptrace.ServerEvent{
+		Response:   httptrace.ResponseInfo{StatusCode: status},
+		ServerRecv: start,
+		ServerSend: start.Add(d),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anns = append(anns, appdash.Annotation{Key: "Name", Value: []byte(name)})
+	return &appdash.Trace{Span: appdash.Span{
+		ID:          appdash.SpanID{Trace: appdash.ID(id), Span: appdash.ID(id)},
+		Annotations: anns,
+	}}
+}
+
+func traceIDs(traces []*appdash.Trace) []appdash.ID {
+	ids := make([]appdash.ID, len(traces))
+	for i, t := range traces {
+		ids[i] = t.Span.ID.Trace
+	}
+	return ids
+}
+
+func TestWeightedScorer(t *testing.T) {
+	tests := []struct {
+		name   string
+		scorer *WeightedScorer
+		want   []appdash.ID
+	}{
+		{"error", &WeightedScorer{Error: 1}, []appdash.ID{3, 1, 2, 4}},
+		{"latency", &WeightedScorer{Latency: 1}, []appdash.ID{2, 1, 4, 3}},
+		{"rarity", &WeightedScorer{Rarity: 1}, []appdash.ID{4, 1, 2, 3}},
+		{"default", DefaultScorer.(*WeightedScorer), []appdash.ID{3, 2, 4, 1}},
+	}
+	for _, test := range tests {
+		traces := []*appdash.Trace{
+			scoreTrace(t, 4, "GET /rare", 5*time.Millisecond, 200),
+			scoreTrace(t, 3, "GET /a", time.Millisecond, 500),
+			scoreTrace(t, 2, "GET /a", 100*time.Millisecond, 200),
+			scoreTrace(t, 1, "GET /a", 10*time.Millisecond, 200),
+		}
+		rankTraces(traces, test.scorer)
+		if got := traceIDs(traces); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got order %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWeightedScorer_ignored(t *testing.T) {
+	traces := []*appdash.Trace{
+		scoreTrace(t, 1, "GET /a", time.Millisecond, 200),
+		scoreTrace(t, 2, "GET /a", 10*time.Millisecond, 200),
+	}
+	want := DefaultScorer.Score(traces)
+
+	// A slow, failed, ignored trace must score zero and not change the
+	// scores of the others.
+	start := time.Unix(100, 0)
+	anns, err := appdash.MarshalEvent(httptrace.ServerEvent{
+		Response:   httptrace.ResponseInfo{StatusCode: 503},
+		Class:      httptrace.ClassIgnore,
+		ServerRecv: start,
+		ServerSend: start.Add(time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anns = append(anns, appdash.Annotation{Key: "Name", Value: []byte("GET /a")})
+	ignored := &appdash.Trace{Span: appdash.Span{ID: appdash.SpanID{Trace: 3, Span: 3}, Annotations: anns}}
+	got := DefaultScorer.Score(append(traces, ignored))
+	if !reflect.DeepEqual(got, append(want, 0)) {
+		t.Errorf("got scores %v with an ignored trace, want %v", got, append(want, 0))
+	}
+}
+
+// badScorer returns too few scores.
+type badScorer struct{}
+
+func (badScorer) Score(traces []*appdash.Trace) []float64 { return []float64{1} }
+
+func TestRankTraces_badScorer(t *testing.T) {
+	traces := []*appdash.Trace{
+		scoreTrace(t, 3, "GET /a", time.Millisecond, 200),
+		scoreTrace(t, 1, "GET /a", time.Millisecond, 200),
+		scoreTrace(t, 2, "GET /a", time.Millisecond, 200),
+	}
+	rankTraces(traces, badScorer{})
+	if got, want := traceIDs(traces), []appdash.ID{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got order %v, want ID order %v", got, want)
+	}
+}
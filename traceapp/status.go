@@ -0,0 +1,95 @@
+package traceapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash"
+)
+
+// status is the response of serveStatus.
+type status struct {
+	// Time is the server's current time, so that clients can estimate
+	// the clock skew between them and the server.
+	Time time.Time `json:"time"`
+
+	// ProtocolVersion is the collector wire protocol version that the
+	// server was built with (see appdash.ProtocolVersion).
+	ProtocolVersion int `json:"protocolVersion"`
+
+	// Store describes the health of the store, and Sampling the
+	// sampling decisions recorded in the traces it holds.
+	Store    storeStatus    `json:"store"`
+	Sampling samplingStatus `json:"sampling"`
+}
+
+type storeStatus struct {
+	OK        bool    `json:"ok"`
+	Error     string  `json:"error,omitempty"`
+	Traces    int     `json:"traces"`
+	QueryMS   float64 `json:"queryMS"`
+	Queryable bool    `json:"queryable"`
+}
+
+type samplingStatus struct {
+	// Recorded is the number of traces whose root span has a sampling
+	// decision (see appdash.SamplingEvent).
+	Recorded int `json:"recorded"`
+
+	// Samplers counts the traces kept by each sampler.
+	Samplers map[string]int `json:"samplers"`
+
+	// HeadRates are the distinct head sampling rates recorded.
+	HeadRates []float64 `json:"headRates"`
+}
+
+// serveStatus serves a summary of the server's health as JSON, for
+// diagnostic tools such as the appdash doctor command.
+func (a *App) serveStatus(w http.ResponseWriter, r *http.Request) error {
+	st := status{
+		Time:            time.Now(),
+		ProtocolVersion: appdash.ProtocolVersion,
+		Sampling:        samplingStatus{Samplers: map[string]int{}, HeadRates: []float64{}},
+	}
+	if a.Queryer != nil {
+		st.Store.Queryable = true
+		start := time.Now()
+		traces, err := appdash.TracesContext(r.Context(), a.Queryer)
+		st.Store.QueryMS = msec(time.Since(start))
+		if err != nil {
+			st.Store.Error = err.Error()
+		} else {
+			st.Store.OK = true
+			st.Store.Traces = len(traces)
+		}
+		rates := map[float64]struct{}{}
+		for _, t := range traces {
+			v, err := sampling(t.Span.Annotations)
+			if err != nil || v == nil {
+				continue
+			}
+			st.Sampling.Recorded++
+			st.Sampling.Samplers[v.Sampler]++
+			if v.Sampler == appdash.SamplerHead && v.Rate > 0 {
+				rates[v.Rate] = struct{}{}
+			}
+		}
+		for rate := range rates {
+			st.Sampling.HeadRates = append(st.Sampling.HeadRates, rate)
+		}
+		sort.Float64s(st.Sampling.HeadRates)
+	} else {
+		// Without a Queryer, check that the store answers lookups.
+		_, err := appdash.TraceContext(r.Context(), a.Store, 0)
+		if err == nil || err == appdash.ErrTraceNotFound {
+			st.Store.OK = true
+		} else {
+			st.Store.Error = err.Error()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	return json.NewEncoder(w).Encode(st)
+}
@@ -87,10 +87,13 @@ func (a *App) parseHTMLTemplates(sets [][]string) error {
 		t.Funcs(htmpl.FuncMap{
 			"urlTo":             a.URLTo,
 			"urlToTrace":        a.URLToTrace,
+			"urlToTraceSpan":    a.URLToTraceSpan,
 			"itoa":              strconv.Itoa,
 			"str":               func(v interface{}) string { return fmt.Sprintf("%s", v) },
 			"durationClass":     durationClass,
 			"filterAnnotations": filterAnnotations,
+			"histogram":         histogram,
+			"sampling":          sampling,
 			"descendTraces":     func() bool { return false },
 		})
 		for _, tmp := range set {
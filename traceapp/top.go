@@ -0,0 +1,196 @@
+package traceapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/appdash/metrics"
+)
+
+// Defaults for the top span names API's query parameters.
+const (
+	defaultTopN      = 10
+	defaultTopWindow = 15 * time.Minute
+)
+
+// topItem is a single span name in the top span names API's response.
+// Latencies are in milliseconds.
+type topItem struct {
+	Name      string  `json:"name"`
+	Count     int64   `json:"count"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"errorRate"`
+	P50       float64 `json:"p50"`
+	P99       float64 `json:"p99"`
+}
+
+// serveTop serves the span names with the highest p99 latency (or error
+// rate) over a recent window, as JSON. It accepts the query parameters:
+//
+//	n       the number of span names to return (default 10)
+//	window  the window, as a Go duration (default 15m)
+//	by      "p99" (default) or "error-rate"
+func (a *App) serveTop(w http.ResponseWriter, r *http.Request) error {
+	if a.Aggregator == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return errors.New("no aggregator is configured")
+	}
+
+	q := r.URL.Query()
+	n := defaultTopN
+	if s := q.Get("n"); s != "" {
+		var err error
+		n, err = strconv.Atoi(s)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid n %q", s)
+		}
+	}
+	window := defaultTopWindow
+	if s := q.Get("window"); s != "" {
+		var err error
+		window, err = time.ParseDuration(s)
+		if err != nil || window <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid window %q", s)
+		}
+	}
+	by, err := parseTopOrder(q.Get("by"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+
+	top := a.Aggregator.Top(n, window, by)
+	items := make([]topItem, len(top))
+	for i, s := range top {
+		items[i] = newTopItem(s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(items)
+}
+
+func newTopItem(s metrics.NameStats) topItem {
+	return topItem{
+		Name:      s.Name,
+		Count:     s.Count,
+		Errors:    s.Errors,
+		ErrorRate: s.ErrorRate,
+		P50:       msec(s.P50),
+		P99:       msec(s.P99),
+	}
+}
+
+// compareItem is a single span name in the compare API's response.
+// Latencies are in milliseconds.
+type compareItem struct {
+	Name      string  `json:"name"`
+	Before    topItem `json:"before"`
+	After     topItem `json:"after"`
+	P99       float64 `json:"p99"`
+	ErrorRate float64 `json:"errorRate"`
+}
+
+// serveCompare serves the change in latency and error rate of each span
+// name between two time windows (e.g. before and after a deploy), sorted
+// by regression magnitude, as JSON. It accepts the query parameters:
+//
+//	before  the first window, as "start,end"
+//	after   the second window, as "start,end"
+//	by      "p99" (default) or "error-rate"
+//
+// Each time is either RFC 3339 or a Go duration relative to now (e.g.
+// "-1h"). The metrics come from the App's Aggregator, so a window that
+// starts before the oldest aggregated metrics (those older than the
+// Aggregator's Window, or collected before it started) is rejected.
+func (a *App) serveCompare(w http.ResponseWriter, r *http.Request) error {
+	if a.Aggregator == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return errors.New("no aggregator is configured")
+	}
+
+	q := r.URL.Query()
+	now := time.Now()
+	var windows [2][2]time.Time
+	for i, param := range []string{"before", "after"} {
+		parts := strings.Split(q.Get(param), ",")
+		if len(parts) != 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid %s %q (want \"start,end\")", param, q.Get(param))
+		}
+		for j, part := range parts {
+			t, err := parseTime(now, part)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return fmt.Errorf("invalid %s: %s", param, err)
+			}
+			windows[i][j] = t
+		}
+		if !windows[i][0].Before(windows[i][1]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid %s: start must be before end", param)
+		}
+	}
+	by, err := parseTopOrder(q.Get("by"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	oldest := a.Aggregator.Oldest()
+	for i, param := range []string{"before", "after"} {
+		if windows[i][0].Before(oldest) {
+			w.WriteHeader(http.StatusBadRequest)
+			return fmt.Errorf("invalid %s: starts at %s, before the oldest aggregated metrics at %s", param, windows[i][0].Format(time.RFC3339), oldest.Format(time.RFC3339))
+		}
+	}
+
+	before := a.Aggregator.Between(windows[0][0], windows[0][1])
+	after := a.Aggregator.Between(windows[1][0], windows[1][1])
+	deltas := metrics.Compare(before, after, by)
+	items := make([]compareItem, len(deltas))
+	for i, d := range deltas {
+		items[i] = compareItem{
+			Name:      d.Name,
+			Before:    newTopItem(d.Before),
+			After:     newTopItem(d.After),
+			P99:       msec(d.P99),
+			ErrorRate: d.ErrorRate,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(items)
+}
+
+// parseTopOrder parses the "by" query parameter.
+func parseTopOrder(s string) (metrics.TopOrder, error) {
+	switch s {
+	case "", string(metrics.ByP99):
+		return metrics.ByP99, nil
+	case string(metrics.ByErrorRate):
+		return metrics.ByErrorRate, nil
+	}
+	return "", fmt.Errorf("invalid by %q (want %q or %q)", s, metrics.ByP99, metrics.ByErrorRate)
+}
+
+// parseTime parses s as an RFC 3339 time or as a duration relative to
+// now.
+func parseTime(now time.Time, s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (want RFC 3339 or a duration relative to now)", s)
+	}
+	return t, nil
+}
+
+// msec returns d in (fractional) milliseconds.
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
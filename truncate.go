@@ -0,0 +1,96 @@
+package appdash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+)
+
+// A TruncatingCollector limits the size of annotation values before
+// passing them on to its underlying collector. It is typically placed in
+// front of a ChunkedCollector or RemoteCollector, so that a single huge
+// value (such as a long SQL query or a response body) is shortened
+// before it is queued, instead of making the whole packet exceed the
+// server's maximum message size.
+//
+// A value that is shortened ends with a marker of the form
+// "...[truncated N bytes]" (or "[sha256:HEX, N bytes]" when hashing), so
+// that it is never mistaken for the original.
+type TruncatingCollector struct {
+	// Collector is the underlying collector that the (possibly
+	// truncated) annotations are sent to.
+	Collector
+
+	// MaxValueSize is the maximum size, in bytes, of an annotation value
+	// (including the marker). Values larger than this are truncated (or
+	// hashed). If it is too small to hold the marker (about 25 bytes),
+	// values are cut to MaxValueSize bytes without one. If zero, values
+	// are passed through unchanged.
+	MaxValueSize int
+
+	// Hash, if true, replaces values larger than MaxValueSize with the
+	// SHA-256 hash of the full value instead of truncating them. Equal
+	// values then remain comparable across spans. The hash marker takes
+	// about 85 bytes; if MaxValueSize is smaller, values are truncated
+	// instead.
+	Hash bool
+}
+
+// Collect implements the Collector interface.
+func (tc *TruncatingCollector) Collect(id SpanID, anns ...Annotation) error {
+	if tc.MaxValueSize > 0 {
+		var copied bool
+		for i, a := range anns {
+			if len(a.Value) <= tc.MaxValueSize {
+				continue
+			}
+			if !copied {
+				// Don't modify the caller's slice.
+				anns = append([]Annotation(nil), anns...)
+				copied = true
+			}
+			anns[i].Value = tc.shorten(a.Value)
+		}
+	}
+	return tc.Collector.Collect(id, anns...)
+}
+
+// shorten returns the truncated (or hashed) form of v, which is longer
+// than tc.MaxValueSize. The result is never longer than tc.MaxValueSize.
+func (tc *TruncatingCollector) shorten(v []byte) []byte {
+	limit := tc.MaxValueSize
+	if tc.Hash {
+		sum := sha256.Sum256(v)
+		if h := fmt.Sprintf("[sha256:%s, %d bytes]", hex.EncodeToString(sum[:]), len(v)); len(h) <= limit {
+			return []byte(h)
+		}
+	}
+	if len(truncatedMarker(len(v))) > limit {
+		// There is no room for a marker.
+		return append([]byte(nil), v[:runeBoundary(v, limit)]...)
+	}
+
+	// The marker's length depends on the number of bytes dropped, so
+	// keep as much of the value as fits next to its marker.
+	n := runeBoundary(v, limit)
+	for n > 0 && n+len(truncatedMarker(len(v)-n)) > limit {
+		n = runeBoundary(v, n-1)
+	}
+	return append(append([]byte(nil), v[:n]...), truncatedMarker(len(v)-n)...)
+}
+
+// runeBoundary returns the largest n' <= n at which v can be cut
+// without splitting a multi-byte UTF-8 sequence.
+func runeBoundary(v []byte, n int) int {
+	for n > 0 && n < len(v) && !utf8.RuneStart(v[n]) {
+		n--
+	}
+	return n
+}
+
+// truncatedMarker returns the suffix that is appended to a value from
+// which n bytes were dropped.
+func truncatedMarker(n int) string {
+	return fmt.Sprintf("...[truncated %d bytes]", n)
+}
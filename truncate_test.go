@@ -0,0 +1,94 @@
+package appdash
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncatingCollector(t *testing.T) {
+	var got []Annotation
+	tc := &TruncatingCollector{
+		Collector: collectorFunc(func(_ SpanID, anns ...Annotation) error {
+			got = anns
+			return nil
+		}),
+		MaxValueSize: 32,
+	}
+
+	long := []byte(strings.Repeat("x", 100))
+	anns := []Annotation{{"short", []byte("v")}, {"long", long}}
+	if err := tc.Collect(SpanID{1, 2, 3}, anns...); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(anns[1].Value, long) {
+		t.Error("caller's annotations were modified")
+	}
+	if string(got[0].Value) != "v" {
+		t.Errorf("got short value %q, want unchanged", got[0].Value)
+	}
+	if want := "xxxxxxxxx...[truncated 91 bytes]"; string(got[1].Value) != want {
+		t.Errorf("got long value %q, want %q", got[1].Value, want)
+	}
+	if len(got[1].Value) > tc.MaxValueSize {
+		t.Errorf("got long value of %d bytes, want at most %d", len(got[1].Value), tc.MaxValueSize)
+	}
+
+	tc.Hash = true
+	tc.MaxValueSize = 90
+	if err := tc.Collect(SpanID{1, 2, 3}, anns...); err != nil {
+		t.Fatal(err)
+	}
+	if v := string(got[1].Value); !strings.HasPrefix(v, "[sha256:") || !strings.HasSuffix(v, ", 100 bytes]") {
+		t.Errorf("got hashed value %q", v)
+	}
+}
+
+func TestTruncatingCollector_utf8(t *testing.T) {
+	var got []Annotation
+	tc := &TruncatingCollector{
+		Collector: collectorFunc(func(_ SpanID, anns ...Annotation) error {
+			got = anns
+			return nil
+		}),
+		MaxValueSize: 30,
+	}
+	tc.Collect(SpanID{1, 2, 3}, Annotation{"k", []byte(strings.Repeat("é", 50))})
+	if v := string(got[0].Value); !strings.HasPrefix(v, "ééé...") {
+		t.Errorf("got value %q, want whole runes before the marker", v)
+	}
+}
+
+func TestTruncatingCollector_smallLimit(t *testing.T) {
+	var got []Annotation
+	tc := &TruncatingCollector{
+		Collector: collectorFunc(func(_ SpanID, anns ...Annotation) error {
+			got = anns
+			return nil
+		}),
+	}
+	values := []string{strings.Repeat("x", 100), strings.Repeat("é", 60), strings.Repeat("x", 30)}
+	for _, hash := range []bool{false, true} {
+		for limit := 1; limit <= 120; limit++ {
+			for _, v := range values {
+				tc.MaxValueSize, tc.Hash = limit, hash
+				tc.Collect(SpanID{1, 2, 3}, Annotation{"k", []byte(v)})
+				g := got[0].Value
+				if len(g) > limit || len(g) > len(v) {
+					t.Errorf("hash=%v, limit %d: got %d-byte value %q from %d bytes", hash, limit, len(g), g, len(v))
+				}
+				if !utf8.Valid(g) {
+					t.Errorf("hash=%v, limit %d: got invalid UTF-8 %q", hash, limit, g)
+				}
+			}
+		}
+	}
+
+	// Below the marker's length, values are cut without one.
+	tc.MaxValueSize, tc.Hash = 10, true
+	tc.Collect(SpanID{1, 2, 3}, Annotation{"k", []byte(values[0])})
+	if want := values[0][:10]; string(got[0].Value) != want {
+		t.Errorf("got %q, want %q", got[0].Value, want)
+	}
+}